@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git branch                          list, * marks the current one
+// git branch <name> [<start-point>]   create, -f moves an existing branch
+// git branch -d|-D <name>...          delete, -D even when not merged into HEAD
+// git branch -m|-M [<old>] <new>      rename, the current branch by default
+func branch(repo *helper.Repository, args []string) error {
+	usage := errors.New("usage: mygit branch [-f] <name> [<start-point>] | -d|-D <name>... | -m|-M [<old>] <new>")
+
+	if len(args) == 0 {
+		return listBranches(repo)
+	}
+
+	switch args[0] {
+	case "-d", "-D", "--delete":
+		if len(args) < 2 {
+			return usage
+		}
+
+		for _, name := range args[1:] {
+			sha, err := repo.DeleteBranch(name, args[0] == "-D")
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted branch %s (was %s).\n", name, sha[:7])
+		}
+
+		return nil
+
+	case "-m", "-M", "--move":
+		force := args[0] == "-M"
+
+		switch len(args) {
+		case 2:
+			current, err := repo.CurrentBranch()
+
+			if err != nil {
+				return err
+			}
+
+			if current == "" {
+				return errors.New("cannot rename the current branch while not on any")
+			}
+
+			return repo.RenameBranch(current, args[1], force)
+		case 3:
+			return repo.RenameBranch(args[1], args[2], force)
+		default:
+			return usage
+		}
+	}
+
+	force := false
+
+	if args[0] == "-f" || args[0] == "--force" {
+		force = true
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args) > 2 || strings.HasPrefix(args[0], "-") {
+		return usage
+	}
+
+	startPoint := "HEAD"
+
+	if len(args) == 2 {
+		startPoint = args[1]
+	}
+
+	startSHA, err := repo.ResolveRevision(startPoint)
+
+	if err != nil {
+		if startPoint == "HEAD" {
+			return fmt.Errorf("not a valid object name: '%s'", startPoint)
+		}
+
+		return err
+	}
+
+	return repo.CreateBranch(args[0], startSHA, force)
+}
+
+func listBranches(repo *helper.Repository) error {
+	current, err := repo.CurrentBranch()
+
+	if err != nil {
+		return err
+	}
+
+	branches, err := repo.ListRefs("refs/heads/")
+
+	if err != nil {
+		return err
+	}
+
+	// a detached HEAD is listed first
+	if current == "" {
+		headSHA, err := repo.ResolveRef("HEAD")
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("* (HEAD detached at %s)\n", headSHA[:7])
+	}
+
+	for _, ref := range branches {
+		name := strings.TrimPrefix(ref.Name, "refs/heads/")
+
+		if name == current {
+			fmt.Printf("* %s\n", name)
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+
+	return nil
+}
@@ -1,76 +1,249 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/codecrafters-io/git-starter-go/helper"
 )
 
-type DeltifiedObject struct {
-	baseObjectSHA string
-	instruction   []byte
+// progress on stderr instead of a silent wait, and the server's own
+// error messages. include-tag brings the tags of a shallow history.
+// a v0 server only sends ofs-deltas to clients that ask for them
+var cloneCapabilities = []string{"ofs-delta", "side-band-64k", "include-tag", "agent=mygit/0.1"}
+
+// git clone [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] [--filter <filter-spec>] [-j <n>] [--shared] <url> <dir>
+type cloneOptions struct {
+	shallow shallowOptions
+	// a partial clone, origin becomes the promisor remote
+	filter string
+	// how many goroutines resolve the deltas of the pack, 0 for one per CPU
+	jobs int
+	// a local clone borrows the objects of the source through
+	// objects/info/alternates instead of copying them
+	shared bool
 }
 
-func CloneRepo(repoUrl string, path string) error {
+func parseCloneArgs(args []string) (string, string, cloneOptions, error) {
+	usage := errors.New("usage: mygit clone [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] [--filter <filter-spec>] [-j <n>] [--shared] <url> <dir>")
+	options := cloneOptions{}
+	positional := []string{}
+
+	for i := 0; i < len(args); i++ {
+		used, err := options.shallow.parseArg(args, i)
+
+		if err != nil {
+			return "", "", options, err
+		}
+
+		switch {
+		case used > 0:
+			i += used - 1
+		case strings.HasPrefix(args[i], "--filter="):
+			options.filter = strings.TrimPrefix(args[i], "--filter=")
+		case args[i] == "--filter" && i+1 < len(args):
+			options.filter = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--jobs="):
+			if options.jobs, err = parseJobs(strings.TrimPrefix(args[i], "--jobs=")); err != nil {
+				return "", "", options, err
+			}
+		case (args[i] == "-j" || args[i] == "--jobs") && i+1 < len(args):
+			if options.jobs, err = parseJobs(args[i+1]); err != nil {
+				return "", "", options, err
+			}
+
+			i++
+		case args[i] == "-s" || args[i] == "--shared":
+			options.shared = true
+		case strings.HasPrefix(args[i], "-"):
+			return "", "", options, usage
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) != 2 {
+		return "", "", options, usage
+	}
+
+	if options.filter != "" {
+		if err := checkFilterSpec(options.filter); err != nil {
+			return "", "", options, err
+		}
+	}
+
+	return positional[0], positional[1], options, nil
+}
+
+// -j <n>, at least one
+func parseJobs(value string) (int, error) {
+	jobs, err := strconv.Atoi(value)
+
+	if err != nil || jobs <= 0 {
+		return 0, fmt.Errorf("jobs %s is not a positive number", value)
+	}
+
+	return jobs, nil
+}
+
+// an undeltified object, a delta base while the deltas on top of it are
+// resolved. offset is the one of its header in the pack file,
+// -1 for objects that come from outside the pack
+type PackedObject struct {
+	offset     int64
+	sha        string
+	objectType string
+	content    []byte
+}
+
+func CloneRepo(repoUrl string, path string, options cloneOptions) error {
+	// a repository on this machine is read directly, the history comes whole
+	localPath, isLocal := localRepositoryPath(repoUrl)
+	isFileUrl := strings.HasPrefix(repoUrl, "file://")
+
+	if isLocal {
+		if options.shallow.deepens() {
+			fmt.Fprintln(os.Stderr, "warning: --depth, --shallow-since and --shallow-exclude are ignored in local clones")
+			options.shallow = shallowOptions{}
+		}
+
+		if options.filter != "" {
+			fmt.Fprintln(os.Stderr, "warning: --filter is ignored in local clones")
+			options.filter = ""
+		}
+
+		// origin has to work from inside the clone too
+		absolutePath, err := filepath.Abs(localPath)
+
+		if err != nil {
+			return err
+		}
+
+		localPath = absolutePath
+
+		if !isFileUrl {
+			repoUrl = absolutePath
+		}
+	} else if options.shared {
+		fmt.Fprintln(os.Stderr, "warning: --shared is ignored, the source repository is not local")
+		options.shared = false
+	}
 
 	// 1. make dir
 	if err := os.MkdirAll(path, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
+		return fmt.Errorf("error creating directory: %w", err)
 	}
 
-	// 2. cd into the created folder
-	err := os.Chdir(path)
+	// 2. initialise git inside the created folder,
+	//    everything below goes through repo so the current directory is left alone
+	repo, err := helper.InitRepository(path)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error changing directory: %s\n", err)
+		return err
 	}
 
-	// 3. initialise git
-	helper.InitialiseGitDirectory()
-
-	// 4. ref discovery
+	// 3. ref discovery
 	fmt.Println("initialise ref discovery")
-	hash, error := refDiscovery(repoUrl)
+	advertisement, err := refDiscovery(repoUrl, "git-upload-pack", []string{"HEAD", "refs/heads/", "refs/tags/"})
 
-	if error != nil {
-		return error
+	if err != nil {
+		return err
 	}
 
-	// 4. request pack file
-	fmt.Println("initialise request pack file")
-	packFile, error := requestPackFile(repoUrl, hash)
+	// the same refs git clone fetches by default: every branch, the tags and HEAD.
+	// a shallow clone leaves out the tags, asking for one would bring its own
+	// shallow history, include-tag sends the ones that point into what we get
+	wants := []string{}
 
-	if error != nil {
-		return error
+	for _, ref := range advertisement.Refs {
+		isTag := strings.HasPrefix(ref.Name, "refs/tags/") && !options.shallow.deepens()
+
+		if (ref.Name == "HEAD" || strings.HasPrefix(ref.Name, "refs/heads/") || isTag) && !helper.ArrayContains(wants, ref.SHA) {
+			wants = append(wants, ref.SHA)
+		}
 	}
 
-	// 5. process pack file, build .git objects
-	// the first object should be same as the hash from ref discovery
-	fmt.Println("process pack file")
-	err = processPacketFile(packFile)
+	// like git, a plain path has its objects copied, hard linked when they
+	// are on the same filesystem. a file:// url gets a pack like any other
+	// remote, unless the objects are shared
+	if len(wants) > 0 && isLocal && (options.shared || !isFileUrl) {
+		fmt.Println("copy objects of the local repository")
+
+		if err := cloneLocalObjects(repo, localPath, options.shared); err != nil {
+			return err
+		}
+	} else if len(wants) > 0 {
+		// 4. request pack file
+		fmt.Println("initialise request pack file")
+		request := packRequest{wants: wants, capabilities: cloneCapabilities, shallow: options.shallow, filter: options.filter}
+		response, err := fetchPack(repo, repoUrl, advertisement, request)
+
+		if err != nil {
+			return err
+		}
+
+		defer response.Close()
+
+		// 5. process pack file, build .git objects
+		fmt.Println("process pack file")
+		packName, err := processPacketFile(repo, response.Pack, os.Stdout, options.jobs)
+
+		if err != nil {
+			return fmt.Errorf("error while processing pack file: %w", err)
+		}
+
+		// the objects the filter left out are promised by origin
+		if options.filter != "" {
+			if err := repo.MarkPromisorPack(packName); err != nil {
+				return err
+			}
+		}
+
+		// .git/shallow, where the history we got ends
+		if err := repo.UpdateShallow(response.Shallow, response.Unshallow); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: You appear to have cloned an empty repository.")
+	}
+
+	// 6. remote-tracking branches, tags, the local branch and HEAD
+	headSHA, err := writeCloneRefs(repo, repoUrl, advertisement.Refs, advertisement.Symrefs["HEAD"])
 
 	if err != nil {
-		fmt.Println("error while processing pack file ", err)
+		return err
 	}
 
-	// 6. create files and dirs from objects with the hash from ref discovery,
-	//    note that the hash from ref discovery step == the hash of the first object in the pack file
-	err = checkoutCommit(hash)
+	if options.filter != "" {
+		if err := writePromisorConfig(repo, "origin", options.filter); err != nil {
+			return err
+		}
 
-	return err
+		if err := enablePromisor(repo); err != nil {
+			return err
+		}
+	}
+
+	if headSHA == "" {
+		return nil
+	}
+
+	// 7. create files and dirs of the commit HEAD points to
+	return checkoutCommit(repo, headSHA)
 }
 
-// this first request is to get the hash which
-// is needed for later api request to get the packFile
+// this first request is to get the refs of the remote and the hashes
+// they point to, which are needed for later api request to get the packFile
 // 2. Get repository info using Smart HTTP protocol -> C: GET $GIT_URL/info/refs?service=git-upload-pack HTTP/1.0
 // response is like below
 // S: 200 OK
@@ -86,304 +259,605 @@ func CloneRepo(repoUrl string, path string) error {
 // S: 0000
 // remember that the first 4 bytes are the length of the response
 // eg, 001e# service=git-upload-pack\n, meaning length is 001e, (30 in decimal) including itself
-func refDiscovery(repoUrl string) (string, error) {
-	infoUrl := repoUrl + "/info/refs?service=git-upload-pack"
-	res, err := http.Get(infoUrl)
+//
+// push asks for service=git-receive-pack the same way, only the capabilities differ.
+// for upload-pack we offer protocol v2 with the Git-Protocol header, a server
+// that speaks it only answers with its capabilities and the refs starting
+// with refPrefixes come from ls-refs. older servers ignore the header.
+// over ssh the service is run on the other side and says the same right away
+func refDiscovery(repoUrl string, service string, refPrefixes []string) (*helper.Advertisement, error) {
+	if path, isLocal := localRepositoryPath(repoUrl); isLocal {
+		return localRefDiscovery(path, service)
+	}
+
+	transport, err := newTransport(repoUrl)
 
 	if err != nil {
-		return "", fmt.Errorf("error getting repository info: %w", err)
+		return nil, err
 	}
 
-	defer res.Body.Close()
+	gitProtocol := ""
 
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("server returned status %d", res.StatusCode)
+	if service == "git-upload-pack" {
+		gitProtocol = gitProtocolV2
 	}
+
 	// response:
 	// 001e# service=git-upload-pack
-	// 0000015547b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEADmulti_ack thin-pack side-band side-band-64k ofs-delta shallow deepen-since deepen-not deepen-relative no-progress include-tag multi_ack_detailed allow-tip-sha1-in-want allow-reachable-sha1-in-want no-done symref=HEAD:refs/heads/master filter object-format=sha1 agent=git/github-50ee4bdaf298
-	// we want the hash
-	body, err := io.ReadAll(res.Body)
+	// 0000015547b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEAD\0multi_ack thin-pack side-band side-band-64k ofs-delta shallow deepen-since deepen-not deepen-relative no-progress include-tag multi_ack_detailed allow-tip-sha1-in-want allow-reachable-sha1-in-want no-done symref=HEAD:refs/heads/master filter object-format=sha1 agent=git/github-50ee4bdaf298
+	// we want every ref, and the branch HEAD points to from symref=HEAD:
+	body, err := transport.Advertise(service, gitProtocol)
 
 	if err != nil {
-		return "", fmt.Errorf("error reading response: %w", err)
+		return nil, err
 	}
 
 	// helper that separate the length and the response into string array
 	// arr[0] -> # service=git-upload-pack
-	// arr[1] -> 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEADmulti_ack thin-pack side-band side-band-64k ofs-delta shallow deepen-since deepen-not deepen-relative no-progress include-tag multi_ack_detailed allow-tip-sha1-in-want allow-reachable-sha1-in-want no-done symref=HEAD:refs/heads/master filter object-format=sha1 agent=git/github-50ee4bdaf298
+	// arr[1] -> 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEAD\0multi_ack thin-pack ... symref=HEAD:refs/heads/master ...
 	// arr[2] -> 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd refs/heads/master
-	lines := helper.ParsePacketLines(body)
+	advertisement, err := helper.ParseAdvertisement(helper.ParsePacketLines(body))
 
-	// retrieve the SHA
-	hash, error := helper.RetrieveMainSHA(lines)
+	if err != nil {
+		return nil, err
+	}
 
-	if error != nil {
-		return "", error
+	if advertisement.ObjectFormat != "sha1" {
+		return nil, fmt.Errorf("unsupported object format %s", advertisement.ObjectFormat)
 	}
 
-	return hash, nil
+	if advertisement.Version == 2 {
+		if err := lsRefs(repoUrl, advertisement, refPrefixes); err != nil {
+			return nil, err
+		}
+	}
+
+	return advertisement, nil
 }
 
 // the request protocol is based on https://git-scm.com/docs/http-protocol
-// 	Smart Service git-receive-pack
+// 	Smart Service git-upload-pack
 // This service reads from the repository pointed to by $GIT_URL.
 
-// Clients MUST first perform ref discovery with $GIT_URL/info/refs?service=git-receive-pack.
+// Clients MUST first perform ref discovery with $GIT_URL/info/refs?service=git-upload-pack.
 
-// C: POST $GIT_URL/git-receive-pack HTTP/1.0
-// C: Content-Type: application/x-git-receive-pack-request
+// C: POST $GIT_URL/git-upload-pack HTTP/1.0
+// C: Content-Type: application/x-git-upload-pack-request
 // C:
-// C: ....0a53e9ddeaddad63ad106860237bbf53411d11a7 441b40d833fdfa93eb2908e52742248faf0ee993 refs/heads/maint\0 report-status
+// C: 0032want 0a53e9ddeaddad63ad106860237bbf53411d11a7\n
 // C: 0000
-// C: PACK....
+// C: 0032have 441b40d833fdfa93eb2908e52742248faf0ee993\n
+// C: 0009done\n
 // The response will start with a NAK or ACK, followed by the packfile
 // Find where the packfile starts (it starts with "PACK")
 // packStart := bytes.Index(packData, []byte("PACK"))
-func requestPackFile(repoUrl string, hash string) ([]byte, error) {
-	uploadPackUrl := repoUrl + "/git-upload-pack"
+//
+// git-receive-pack, the other direction, is in push.go
+func requestPackFile(repoUrl string, wants []string, haves []string, capabilities []string, requestLines []string) (*helper.FetchResponse, error) {
+	// one "0032want <hash>\n" line per hash, the length prefix 0032 is 50 bytes:
+	// 4 bytes for length + "want " (5 bytes) + hash (40 bytes) + "\n" (1 byte)
+	// then the shallow, deepen and filter lines, a flush packet, the commits
+	// we already have and "0009done\n"
+	requestBody := wantLines(wants, capabilities, requestLines) + "0000"
+
+	for _, hash := range haves {
+		requestBody += helper.FormatPacketLine(fmt.Sprintf("have %s\n", hash))
+	}
 
-	// The length prefix "0032" represents 50 bytes (32 in hex): 4 bytes for length + "want " (5 bytes) + hash (40 bytes) + "\n" (1 byte)
-	wantLine := fmt.Sprintf("0032want %s\n", hash)
+	requestBody += helper.FormatPacketLine("done\n")
 
-	// Add a flush packet after the want line
-	flushPkt := "0000"
+	// the response is read as it arrives, the pack is left in it for
+	// processPacketFile
+	body, err := serviceStream(repoUrl, "git-upload-pack", []byte(requestBody), "")
 
-	// Add the done line - "0009" represents 9 bytes: 4 bytes for length + "done\n" (5 bytes)
-	doneLine := "0009done\n"
+	if err != nil {
+		return nil, err
+	}
 
-	// Combine all parts of the request
-	requestBody := wantLine + flushPkt + doneLine
+	return helper.ParseUploadPackResponse(body, helper.ArrayContains(capabilities, "side-band-64k"), os.Stderr)
+}
 
-	req, err := http.NewRequest("POST", uploadPackUrl, strings.NewReader(requestBody))
+// the ones of ours the server advertises, an agent=<value> is kept when
+// the server advertises any agent
+func supportedCapabilities(advertisement *helper.Advertisement, capabilities []string) []string {
+	supported := []string{}
 
-	if err != nil {
-		return []byte{}, fmt.Errorf("error creating upload-pack request: %w", err)
+	for _, capability := range capabilities {
+		name, _, _ := strings.Cut(capability, "=")
+
+		if advertisement.HasCapability(name) {
+			supported = append(supported, capability)
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	return supported
+}
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+// the capabilities we want go after the first want, separated by a space,
+// the shallow, deepen and filter lines after the last one
+//
+//	0045want 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd multi_ack_detailed ofs-delta\n
+//	000ddeepen 1\n
+//	0016filter blob:none\n
+func wantLines(wants []string, capabilities []string, requestLines []string) string {
+	lines := ""
+
+	for i, hash := range wants {
+		if i == 0 && len(capabilities) > 0 {
+			lines += helper.FormatPacketLine(fmt.Sprintf("want %s %s\n", hash, strings.Join(capabilities, " ")))
+			continue
+		}
 
-	if err != nil {
-		return []byte{}, fmt.Errorf("error during upload-pack request: %w", err)
+		lines += helper.FormatPacketLine(fmt.Sprintf("want %s\n", hash))
 	}
 
-	defer res.Body.Close()
+	for _, line := range requestLines {
+		lines += helper.FormatPacketLine(line + "\n")
+	}
 
-	if res.StatusCode != http.StatusOK {
-		return []byte{}, fmt.Errorf("upload-pack request failed with status: %d", res.StatusCode)
+	return lines
+}
+
+// one stateless request to the upload-pack service, the whole response is returned
+func uploadPack(repoUrl string, requestBody string) ([]byte, error) {
+	return serviceRequest(repoUrl, "git-upload-pack", []byte(requestBody), "")
+}
+
+// one request to a service of the remote, over http a POST $GIT_URL/<service>.
+// gitProtocol is the Git-Protocol header, "version=2" for protocol v2 commands
+func serviceRequest(repoUrl string, service string, requestBody []byte, gitProtocol string) ([]byte, error) {
+	body, err := serviceStream(repoUrl, service, requestBody, gitProtocol)
+
+	if err != nil {
+		return []byte{}, err
 	}
 
-	// s: 0008NAK
-	// s: PACK<header><objects>
-	packData, err := io.ReadAll(res.Body)
+	defer body.Close()
+
+	responseBody, err := io.ReadAll(body)
 
 	if err != nil {
-		return []byte{}, fmt.Errorf("error reading upload-pack response: %w", err)
+		return []byte{}, fmt.Errorf("error reading %s response: %w", service, err)
 	}
 
-	// remove 0008NAK\n header and get the rest
-	// so we need the offset after the header
-	packetLength := packData[:4] // 0008
-	offset, err := strconv.ParseInt(string(packetLength), 16, 32)
+	return responseBody, nil
+}
+
+// serviceRequest without reading the response, the caller reads it as
+// it arrives and closes it
+func serviceStream(repoUrl string, service string, requestBody []byte, gitProtocol string) (io.ReadCloser, error) {
+	transport, err := newTransport(repoUrl)
+
 	if err != nil {
 		return nil, err
 	}
 
-	return packData[offset:], nil
+	return transport.Request(service, requestBody, gitProtocol)
 }
 
 // https://github.com/git/git/blob/795ea8776befc95ea2becd8020c7a284677b4161/Documentation/gitformat-pack.txt
-func processPacketFile(packFile []byte) error {
-	// 4-byte signature:
-	// The signature is: {'P', 'A', 'C', 'K'}
-	if !bytes.HasPrefix(packFile, []byte("PACK")) {
-		return fmt.Errorf("invalid packfile: missing PACK signature")
+//
+// the pack is read as it arrives and goes straight to a temporary file in
+// objects/pack, only the entries are kept in memory and never their data.
+// a multi-gigabyte clone needs about as much memory as a small one
+//
+// the deltas are resolved on up to jobs goroutines, one per CPU when 0
+func processPacketFile(repo *helper.Repository, packStream io.Reader, output io.Writer, jobs int) (string, error) {
+	spill, err := repo.CreateTempPack()
+
+	if err != nil {
+		return "", err
 	}
 
-	fmt.Println("PACK signature found")
+	// gone unless it became the pack
+	defer os.Remove(spill.Name())
+	defer spill.Close()
 
-	// followed by 4-byte version number (network byte order):
-	// Git currently accepts version number 2 or 3 but
-	// generates version 2 only.
-	version := binary.BigEndian.Uint32(packFile[4:8])
+	// 4-byte signature {'P', 'A', 'C', 'K'}, 4-byte version number and
+	// 4-byte number of objects, all in network byte order
+	pack, err := helper.NewPackReader(packStream, spill)
 
-	if version != 2 {
-		return fmt.Errorf("unsupported packfile version: %d", version)
+	if err != nil {
+		return "", err
 	}
 
-	// followerd by 4-byte which represents number of objects contained in the pack (network byte order)
-	// Observation: we cannot have more than 4G versions ;-) and
-	//  more than 4G objects in a pack.
-	numObjects := binary.BigEndian.Uint32(packFile[8:12])
-	fmt.Printf("Packfile contains %d objects\n", numObjects)
+	fmt.Fprintln(output, "PACK signature found")
+	fmt.Fprintf(output, "Packfile contains %d objects\n", pack.NumObjects)
 
-	offset := 12
-	var processedObject uint32
-	deltaObjects := []DeltifiedObject{}
+	entries := make([]*helper.PackEntry, 0, pack.NumObjects)
 
-	for offset < len(packFile) {
-		objectType, size, headerOffset, err := helper.ReadObjectHeader(packFile[offset:])
+	for {
+		entry, err := pack.Next()
 
 		if err != nil {
-			return fmt.Errorf("error reading object header: %w", err)
+			return "", err
 		}
 
-		offset += headerOffset
+		if entry == nil {
+			break
+		}
 
-		if helper.ArrayContains([]string{"commit", "tree", "blob", "tag"}, objectType) {
-			processedObjectOffset, blob, err := helper.ProcessObject(packFile[offset:])
+		entries = append(entries, entry)
+	}
 
-			if err != nil {
-				// todo
-				// for some reason
-				// the last object cannot be processed by zlib
-				break
-			}
+	// the pack ends with a SHA-1 checksum of everything before it
+	packChecksum, err := pack.Finish()
 
-			offset += int(processedObjectOffset)
+	if err != nil {
+		return "", err
+	}
 
-			objectSha, objectContent := helper.GetObjectSHA(blob, objectType)
+	externalBases, err := resolveDeltaObjects(repo, spill, entries, output, jobs)
 
-			err = helper.SaveBlob(objectSha, objectContent)
+	if err != nil {
+		return "", err
+	}
+
+	// every object now has a name, keep the pack as it is
+	// instead of exploding it into loose objects
+	indexEntries := make([]helper.PackIndexEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		indexEntry := helper.PackIndexEntry{Offset: uint64(entry.Offset), CRC32: entry.CRC32}
+		hex.Decode(indexEntry.SHA[:], []byte(entry.SHA))
+
+		indexEntries = append(indexEntries, indexEntry)
+	}
+
+	// a thin pack from a fetch, the bases we already had go into the pack
+	if len(externalBases) > 0 {
+		var baseEntries []helper.PackIndexEntry
+
+		packChecksum, baseEntries, err = repo.CompleteThinPack(spill, externalBases, jobs)
+
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(output, "completed thin pack with %d local objects\n", len(externalBases))
+
+		indexEntries = append(indexEntries, baseEntries...)
+	}
+
+	if err := spill.Close(); err != nil {
+		return "", fmt.Errorf("error writing pack file: %w", err)
+	}
+
+	packName, err := repo.SavePack(spill.Name(), packChecksum, indexEntries)
+
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintln(output, "pack file saved as", packName)
+
+	return packName, nil
+}
+
+// some object is based on other delta object, and the pack gives no
+// guarantee that a ref-delta comes after its base. so instead of walking
+// the deltas in pack order we build a graph of base -> dependent deltas
+// and walk it depth first, starting from every object we already have.
+//
+//	blob a (offset 12)
+//	  └── ofs-delta b (base offset 12)
+//	        └── ref-delta c (base sha of b)
+//	              └── ofs-delta d (base offset of c)
+//
+// ofs-deltas hang off the offset of their base, ref-deltas off its sha.
+// a ref-delta base may also be outside the pack (thin pack), in which case
+// it must already be in the object database. the names of those bases are
+// returned.
+//
+// the delta data is read back from the spilled pack when its base is
+// ready, only the bases on the way down from the root are in memory.
+// the trees below different roots are independent and are walked on up
+// to jobs goroutines, each with its own chain in memory
+func resolveDeltaObjects(repo *helper.Repository, pack io.ReaderAt, entries []*helper.PackEntry, output io.Writer, jobs int) ([]string, error) {
+	resolver := &deltaResolver{
+		pack:               pack,
+		dependentsBySHA:    map[string][]*helper.PackEntry{},
+		dependentsByOffset: map[int64][]*helper.PackEntry{},
+	}
+
+	roots := []*helper.PackEntry{}
+	deltas := 0
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case "ref-delta":
+			resolver.dependentsBySHA[entry.BaseSHA] = append(resolver.dependentsBySHA[entry.BaseSHA], entry)
+			deltas++
+		case "ofs-delta":
+			resolver.dependentsByOffset[entry.BaseOffset] = append(resolver.dependentsByOffset[entry.BaseOffset], entry)
+			deltas++
+		default:
+			roots = append(roots, entry)
+		}
+	}
+
+	fmt.Fprintln(output, "delta object length are ", deltas)
+
+	// start from the undeltified objects in pack order, the ones nothing
+	// is based on are not read again
+	tasks := []func() error{}
+
+	for _, entry := range roots {
+		if !resolver.hasDependents(entry.SHA, entry.Offset) {
+			continue
+		}
+
+		tasks = append(tasks, func() error {
+			content, err := helper.ReadPackEntryData(pack, entry)
 
 			if err != nil {
 				return err
 			}
-		} else if objectType == "ofs-delta" {
-			fmt.Println("type of object is ofs-delta")
-			// n-byte offset (see below) interpreted as a negative
-			// offset from the type-byte of the header of the
-			// ofs-delta entry (the size above is the size of
-			// the delta data that follows).
-			// tldr: it tells us where the base object is
-			return fmt.Errorf("todo: ofs-delta")
-		} else if objectType == "ref-delta" {
-			// base object name (the size above is the
-			// 	size of the delta data that follows).
-			// 	  delta data, deflated.
-			// structure <header><base object sha><intruction to build>
-			// eli5 verson:
-			// For a REF_DELTA, Git gives:
-			// The SHA-1 hash of the base object (the one you need to start from).
-			// A delta recipe (compressed!) for transforming that base object into the current object.
-			// So instead of saying "Go backward 180 steps," it says something like this:
-			// "Hey, go find the object with the name abc123... in the Git database. Once you find it, apply this recipe (delta) to it."
-			hash := packFile[offset : offset+20]
-			offset += 20
-
-			processedOffset, intruction, err := helper.ProcessObject(packFile[offset:])
+
+			return resolver.resolve(PackedObject{offset: entry.Offset, sha: entry.SHA, objectType: entry.Type, content: content})
+		})
+	}
+
+	if err := helper.RunJobs(jobs, tasks); err != nil {
+		return nil, err
+	}
+
+	// bases that are not in the pack have to come from the object database
+	externalBases := []string{}
+
+	for baseSHA := range resolver.dependentsBySHA {
+		if repo.Objects.Has(baseSHA) {
+			externalBases = append(externalBases, baseSHA)
+		}
+	}
+
+	sort.Strings(externalBases)
+	tasks = []func() error{}
+
+	for _, baseSHA := range externalBases {
+		tasks = append(tasks, func() error {
+			baseObject, objectType, err := repo.Objects.Read(baseSHA)
 
 			if err != nil {
-				fmt.Println("here here error is ", err)
 				return err
 			}
 
-			if int(size) != len(intruction) {
-				return fmt.Errorf("object length doesnt match with header")
-			}
+			return resolver.resolve(PackedObject{offset: -1, sha: baseSHA, objectType: objectType, content: baseObject})
+		})
+	}
 
-			offset += processedOffset
+	if err := helper.RunJobs(jobs, tasks); err != nil {
+		return nil, err
+	}
+
+	// whatever is left never had its base show up
+	unresolved := []string{}
 
-			deltaObjects = append(deltaObjects, DeltifiedObject{instruction: intruction, baseObjectSHA: hex.EncodeToString(hash)})
-		} else {
-			fmt.Println("error unknown object type ", objectType)
-			return fmt.Errorf("unknown object type: %s", objectType)
+	for baseSHA, dependents := range resolver.dependentsBySHA {
+		for _, delta := range dependents {
+			unresolved = append(unresolved, fmt.Sprintf("ref-delta at offset %d (base %s)", delta.Offset, baseSHA))
 		}
+	}
 
-		processedObject++
+	for baseOffset, dependents := range resolver.dependentsByOffset {
+		for _, delta := range dependents {
+			unresolved = append(unresolved, fmt.Sprintf("ofs-delta at offset %d (base offset %d)", delta.Offset, baseOffset))
+		}
 	}
 
-	if numObjects != processedObject {
-		return fmt.Errorf("object count in the headers doesnt match actual object count")
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return nil, fmt.Errorf("%d delta objects have missing bases: %s", len(unresolved), strings.Join(unresolved, ", "))
 	}
 
-	fmt.Println("delta object length are ", len(deltaObjects))
+	return externalBases, nil
+}
 
-	// assume that delta object are in order
-	for _, delta := range deltaObjects {
-		baseObject, objectType, err := helper.OpenObject(delta.baseObjectSHA)
+// the deltas of a pack by what they are based on, shared by the
+// goroutines that resolve them
+type deltaResolver struct {
+	pack io.ReaderAt
 
-		if err != nil {
-			return err
-		}
+	mu                 sync.Mutex
+	dependentsBySHA    map[string][]*helper.PackEntry
+	dependentsByOffset map[int64][]*helper.PackEntry
+}
 
-		// err = helper.WriteDeltaObject(baseObject, delta.instruction, objectType)
-		// if err != nil {
-		// 	return err
-		// }
-		undeltifiedObject, err := helper.BuildDeltaObject(baseObject, delta.instruction)
+func (d *deltaResolver) hasDependents(sha string, offset int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-		if err != nil {
-			return err
-		}
+	return len(d.dependentsBySHA[sha]) > 0 || len(d.dependentsByOffset[offset]) > 0
+}
 
-		objectSha, objectContent := helper.GetObjectSHA(undeltifiedObject, objectType)
+// the deltas on top of baseObject. a delta may be reachable both by sha
+// and by offset of its base, removing the entries makes sure every
+// dependent is resolved once, by one goroutine
+func (d *deltaResolver) takeDependents(baseObject PackedObject) []*helper.PackEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-		err = helper.SaveBlob(objectSha, objectContent)
+	dependents := d.dependentsBySHA[baseObject.sha]
+	delete(d.dependentsBySHA, baseObject.sha)
 
-		if err != nil {
-			return err
+	if baseObject.offset >= 0 {
+		dependents = append(dependents, d.dependentsByOffset[baseObject.offset]...)
+		delete(d.dependentsByOffset, baseObject.offset)
+	}
+
+	return dependents
+}
+
+// names every delta on top of baseObject, and the ones on top of those.
+// the last dependent takes the place of its base instead of recursing,
+// a long chain of deltas has two objects in memory at a time, not all of them
+func (d *deltaResolver) resolve(baseObject PackedObject) error {
+	for {
+		dependents := d.takeDependents(baseObject)
+
+		var next *PackedObject
+
+		for i, delta := range dependents {
+			instruction, err := helper.ReadPackEntryData(d.pack, delta)
+
+			if err != nil {
+				return err
+			}
+
+			undeltifiedObject, err := resolveDeltifiedObject(baseObject, delta.Offset, instruction)
+
+			if err != nil {
+				return fmt.Errorf("error resolving delta at offset %d: %w", delta.Offset, err)
+			}
+
+			delta.SHA = undeltifiedObject.sha
+
+			if !d.hasDependents(undeltifiedObject.sha, undeltifiedObject.offset) {
+				continue
+			}
+
+			if i == len(dependents)-1 {
+				next = &undeltifiedObject
+				break
+			}
+
+			if err := d.resolve(undeltifiedObject); err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
 		}
+
+		baseObject = *next
 	}
+}
 
-	// some object is based on other delta object
-	// for len(deltaObjects) > 0 {
-	// 	unaddedDeltaObjects := []DeltaObject{}
-	// 	added := false
-	// 	for _, delta := range deltaObjects {
-	// 		if helper.ObjectExists(delta.baseObjectSHA) {
-	// 			added = true
-	// 			baseObject, objectType, err := helper.OpenObject(delta.baseObjectSHA)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 			err = helper.WriteDeltaObject(baseObject, delta.instruction, objectType)
-	// 			if err != nil {
-	// 				return err
-	// 			}
-	// 		} else {
-	// 			unaddedDeltaObjects = append(unaddedDeltaObjects, delta)
-	// 		}
-	// 	}
-	// 	if !added {
-	// 		return errors.New("bad delta objects")
-	// 	}
-	// 	deltaObjects = unaddedDeltaObjects
-	// }
-
-	return nil
+// apply the delta instruction on top of the base object,
+// the undeltified object has the same type as its base
+func resolveDeltifiedObject(baseObject PackedObject, offset int64, instruction []byte) (PackedObject, error) {
+	undeltifiedObject, err := helper.BuildDeltaObject(baseObject.content, instruction)
 
+	if err != nil {
+		return PackedObject{}, err
+	}
+
+	// hashed without copying it behind its header like GetObjectSHA
+	objectHash := sha1.New()
+	fmt.Fprintf(objectHash, "%s %d\000", baseObject.objectType, len(undeltifiedObject))
+	objectHash.Write(undeltifiedObject)
+
+	return PackedObject{
+		offset:     offset,
+		sha:        hex.EncodeToString(objectHash.Sum(nil)),
+		objectType: baseObject.objectType,
+		content:    undeltifiedObject,
+	}, nil
 }
 
-func checkoutCommit(commitHash string) error {
-	commit, objectType, err := helper.OpenObject(commitHash)
+func checkoutCommit(repo *helper.Repository, commitHash string) error {
+	commit, err := repo.ReadCommit(commitHash)
+
 	if err != nil {
 		return err
 	}
-	if objectType != "commit" {
-		return errors.New("object not a commit")
+
+	// nothing is checked out yet, every file of the tree gets written
+	return repo.SwitchTree("", commit.Tree)
+}
+
+// what git clone leaves behind for the remote "origin":
+//
+//	refs/remotes/origin/<branch>  for every branch of the remote
+//	refs/tags/<tag>               for every tag
+//	refs/heads/<default>          the branch the remote's HEAD points to
+//	refs/remotes/origin/HEAD      -> refs/remotes/origin/<default>
+//	HEAD                          -> refs/heads/<default>, detached when the
+//	                                 remote's HEAD is not a branch
+//
+// plus remote.origin.* and branch.<default>.* in .git/config.
+// returns the commit to check out, empty for an empty repository
+func writeCloneRefs(repo *helper.Repository, repoUrl string, refs []helper.Ref, headTarget string) (string, error) {
+	headSHA := ""
+	branches := map[string]string{}
+
+	for _, ref := range refs {
+		var err error
+
+		switch {
+		case ref.Name == "HEAD":
+			headSHA = ref.SHA
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			branches[ref.Name] = ref.SHA
+			err = repo.UpdateRef("refs/remotes/origin/"+strings.TrimPrefix(ref.Name, "refs/heads/"), ref.SHA, "")
+		// a shallow clone only has the tags include-tag sent
+		case strings.HasPrefix(ref.Name, "refs/tags/") && repo.Objects.Has(ref.SHA):
+			err = repo.UpdateRef(ref.Name, ref.SHA, "")
+		}
+
+		if err != nil {
+			return "", err
+		}
 	}
 
-	// tree 0f99f9c5b83b010cfbd67870502df7b293ec0e37
-	// parent 40c614ba65a7faf2c97a52a2fa74568dabc49ebb
-	// author Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530
-	// committer Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530
+	// servers without the symref capability, guess like git does:
+	// the branch HEAD matches, master or main first
+	if headTarget == "" && headSHA != "" {
+		for _, name := range []string{"refs/heads/master", "refs/heads/main"} {
+			if branches[name] == headSHA {
+				headTarget = name
+				break
+			}
+		}
 
-	// we need to extract the tree 40 characters hash
-	spaceIndex := strings.Index(string(commit), " ")
+		for _, ref := range refs {
+			if headTarget == "" && strings.HasPrefix(ref.Name, "refs/heads/") && ref.SHA == headSHA {
+				headTarget = ref.Name
+			}
+		}
+	}
 
-	if spaceIndex == -1 {
-		return errors.New("no space found")
+	if err := repo.SetConfig("remote.origin.url", repoUrl); err != nil {
+		return "", err
 	}
 
-	startIndex := spaceIndex + 1
+	if err := repo.SetConfig("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		return "", err
+	}
+
+	branch, isBranch := strings.CutPrefix(headTarget, "refs/heads/")
+
+	if !isBranch {
+		if headSHA == "" {
+			return "", nil
+		}
+
+		return headSHA, repo.UpdateRef("HEAD", headSHA, "")
+	}
+
+	// an empty repository still tells us its unborn default branch
+	if sha, ok := branches[headTarget]; ok {
+		headSHA = sha
 
-	treeHash := commit[startIndex : startIndex+40]
+		if err := repo.UpdateRef(headTarget, sha, helper.ZeroSHA); err != nil {
+			return "", err
+		}
+
+		if err := repo.WriteSymbolicRef("refs/remotes/origin/HEAD", "refs/remotes/origin/"+branch); err != nil {
+			return "", err
+		}
 
-	err = helper.CheckoutTree(string(treeHash), ".")
+		if err := repo.SetConfig("branch."+branch+".remote", "origin"); err != nil {
+			return "", err
+		}
+
+		if err := repo.SetConfig("branch."+branch+".merge", headTarget); err != nil {
+			return "", err
+		}
+	} else {
+		headSHA = ""
+	}
 
-	return err
+	return headSHA, repo.WriteSymbolicRef("HEAD", headTarget)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestWriteCloneRefs(t *testing.T) {
+	master := strings.Repeat("a", 40)
+	feature := strings.Repeat("b", 40)
+	tagName, _ := helper.GetObjectSHA([]byte("v1.0"), "blob")
+	tag := hex.EncodeToString(tagName[:])
+
+	tests := []struct {
+		name       string
+		refs       []helper.Ref
+		headTarget string
+		headSHA    string
+		head       string
+		files      map[string]string
+	}{
+		{
+			"symref",
+			[]helper.Ref{{Name: "HEAD", SHA: feature}, {Name: "refs/heads/master", SHA: master}, {Name: "refs/heads/feature", SHA: feature}, {Name: "refs/tags/v1.0", SHA: tag}, {Name: "refs/tags/missing", SHA: strings.Repeat("d", 40)}},
+			"refs/heads/feature",
+			feature,
+			"ref: refs/heads/feature",
+			map[string]string{
+				"refs/heads/feature":         feature,
+				"refs/remotes/origin/master": master,
+				"refs/remotes/origin/HEAD":   "ref: refs/remotes/origin/feature",
+				"refs/tags/v1.0":             tag,
+			},
+		},
+		{
+			"guessed from HEAD",
+			[]helper.Ref{{Name: "HEAD", SHA: master}, {Name: "refs/heads/a", SHA: master}, {Name: "refs/heads/master", SHA: master}},
+			"",
+			master,
+			"ref: refs/heads/master",
+			map[string]string{"refs/heads/master": master, "refs/remotes/origin/a": master},
+		},
+		{
+			"empty repository",
+			nil,
+			"refs/heads/main",
+			"",
+			"ref: refs/heads/main",
+			map[string]string{},
+		},
+		{
+			"detached",
+			[]helper.Ref{{Name: "HEAD", SHA: tag}, {Name: "refs/heads/master", SHA: master}},
+			"",
+			tag,
+			tag,
+			map[string]string{"refs/remotes/origin/master": master},
+		},
+	}
+
+	for _, test := range tests {
+		repo, err := helper.InitRepository(t.TempDir())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only tags whose objects came with the pack are written
+		if _, err := repo.Objects.Write("blob", []byte("v1.0")); err != nil {
+			t.Fatal(err)
+		}
+
+		headSHA, err := writeCloneRefs(repo, "https://example.com/repo.git", test.refs, test.headTarget)
+
+		if err != nil || headSHA != test.headSHA {
+			t.Errorf("%s: head %s, %v, want %s", test.name, headSHA, err, test.headSHA)
+			continue
+		}
+
+		test.files["HEAD"] = test.head
+
+		for name, want := range test.files {
+			content, err := os.ReadFile(filepath.Join(repo.GitDir, filepath.FromSlash(name)))
+
+			if err != nil || strings.TrimSpace(string(content)) != want {
+				t.Errorf("%s: %s is %q (%v), want %q", test.name, name, content, err, want)
+			}
+		}
+
+		if _, err := repo.ResolveRef("refs/tags/missing"); err == nil {
+			t.Errorf("%s: wrote a tag whose object is missing", test.name)
+		}
+
+		config, err := repo.Config()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if url, _ := config.Get("remote.origin.url"); url != "https://example.com/repo.git" {
+			t.Errorf("%s: remote.origin.url = %q", test.name, url)
+		}
+
+		if branch, isBranch := strings.CutPrefix(strings.TrimPrefix(test.head, "ref: "), "refs/heads/"); isBranch && test.headSHA != "" {
+			if merge, _ := config.Get("branch." + branch + ".merge"); merge != "refs/heads/"+branch {
+				t.Errorf("%s: branch.%s.merge = %q", test.name, branch, merge)
+			}
+		}
+	}
+}
+
+func TestParseCloneArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		jobs int
+		err  string
+	}{
+		{[]string{"url", "dir"}, 0, ""},
+		{[]string{"-j", "4", "url", "dir"}, 4, ""},
+		{[]string{"url", "--jobs", "2", "dir"}, 2, ""},
+		{[]string{"--jobs=8", "url", "dir"}, 8, ""},
+		{[]string{"-j", "0", "url", "dir"}, 0, "not a positive number"},
+		{[]string{"--jobs=many", "url", "dir"}, 0, "not a positive number"},
+		{[]string{"url", "dir", "-j"}, 0, "usage"},
+		{[]string{"url"}, 0, "usage"},
+	}
+
+	for _, test := range tests {
+		url, dir, options, err := parseCloneArgs(test.args)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseCloneArgs(%q): got %v, want %q", test.args, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || url != "url" || dir != "dir" || options.jobs != test.jobs {
+			t.Errorf("parseCloneArgs(%q) = %s, %s, %+v, %v, want %d jobs", test.args, url, dir, options, err, test.jobs)
+		}
+	}
+}
+
+// a pack entry whose data is already deflated
+func packEntry(t *testing.T, objectType string, size int, base []byte, data []byte) []byte {
+	t.Helper()
+
+	header, err := helper.EncodeObjectHeader(objectType, size)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(data)
+	writer.Close()
+
+	return append(append(header, base...), compressed.Bytes()...)
+}
+
+func TestProcessPacketFile(t *testing.T) {
+	// two roots, each with a chain of ofs-deltas that append one line
+	pack := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 8}
+	want := []string{}
+
+	for _, root := range []string{"first root\n", "second root\n"} {
+		content := root
+		baseOffset := len(pack)
+		pack = append(pack, packEntry(t, "blob", len(content), nil, []byte(content))...)
+		want = append(want, content)
+
+		for i := 0; i < 3; i++ {
+			line := fmt.Sprintf("line %d\n", i)
+			// copy the whole base, then insert the line
+			deltaData := []byte{byte(len(content)), byte(len(content) + len(line)), 0x90, byte(len(content)), byte(len(line))}
+			deltaData = append(deltaData, line...)
+
+			offset := len(pack)
+			pack = append(pack, packEntry(t, "ofs-delta", len(deltaData), []byte{byte(offset - baseOffset)}, deltaData)...)
+
+			content += line
+			baseOffset = offset
+			want = append(want, content)
+		}
+	}
+
+	checksum := sha1.Sum(pack)
+	pack = append(pack, checksum[:]...)
+
+	indexes := []string{}
+
+	for _, jobs := range []int{1, 8} {
+		repo, err := helper.InitRepository(t.TempDir())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		packName, err := processPacketFile(repo, bytes.NewReader(pack), io.Discard, jobs)
+
+		if err != nil {
+			t.Fatalf("jobs %d: %v", jobs, err)
+		}
+
+		for _, content := range want {
+			name, _ := helper.GetObjectSHA([]byte(content), "blob")
+
+			if got, objectType, err := repo.Objects.Read(hex.EncodeToString(name[:])); err != nil || string(got) != content || objectType != "blob" {
+				t.Errorf("jobs %d: object %x = %q, %s, %v, want %q", jobs, name, got, objectType, err, content)
+			}
+		}
+
+		index, err := os.ReadFile(filepath.Join(repo.GitDir, "objects", "pack", packName+".idx"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		indexes = append(indexes, string(index))
+	}
+
+	if indexes[0] != indexes[1] {
+		t.Errorf("-j 1 and -j 8 wrote different .idx files")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git commit -m <message> [-m <message>]... [--allow-empty] [--author=<author>] [--date=<date>]
+//
+//  1. build the tree of what is staged
+//  2. the parent is whatever HEAD resolves to, nothing on an unborn branch
+//  3. write the commit object
+//  4. move the branch HEAD points to (or HEAD itself when detached),
+//     refusing if someone else moved it in the meantime
+func commit(repo *helper.Repository, args []string) error {
+	messages := []string{}
+	allowEmpty := false
+	author := ""
+	date := ""
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-m" && i+1 < len(args):
+			messages = append(messages, args[i+1])
+			i++
+		case strings.HasPrefix(args[i], "--message="):
+			messages = append(messages, strings.TrimPrefix(args[i], "--message="))
+		case args[i] == "--allow-empty":
+			allowEmpty = true
+		case strings.HasPrefix(args[i], "--author="):
+			author = strings.TrimPrefix(args[i], "--author=")
+		case strings.HasPrefix(args[i], "--date="):
+			date = strings.TrimPrefix(args[i], "--date=")
+		default:
+			return errors.New("usage: mygit commit -m <message> [--allow-empty] [--author=<author>] [--date=<date>]")
+		}
+	}
+
+	if len(messages) == 0 {
+		return errors.New("aborting commit due to empty commit message, use -m <message>")
+	}
+
+	authorSignature, committerSignature, err := repo.CommitSignatures(author, date)
+
+	if err != nil {
+		return err
+	}
+
+	headRef, err := repo.HeadRef()
+
+	if err != nil {
+		return err
+	}
+
+	parentSHAs := []string{}
+	oldSHA := helper.ZeroSHA
+
+	parentSHA, err := repo.ResolveRef(headRef)
+
+	if err == nil {
+		parentSHAs = append(parentSHAs, parentSHA)
+		oldSHA = parentSHA
+	} else if !errors.Is(err, helper.ErrRefNotFound) {
+		return err
+	}
+
+	treeSHA, err := repo.WriteCurrentTree()
+
+	if err != nil {
+		return err
+	}
+
+	if len(parentSHAs) > 0 && !allowEmpty {
+		parent, err := repo.ReadCommit(parentSHAs[0])
+
+		if err != nil {
+			return err
+		}
+
+		if parent.Tree == treeSHA {
+			return errors.New("nothing to commit, working tree clean")
+		}
+	}
+
+	commitSHA, err := repo.CommitTree(treeSHA, parentSHAs, strings.Join(messages, "\n\n"), authorSignature, committerSignature)
+
+	if err != nil {
+		return err
+	}
+
+	err = repo.UpdateRef(headRef, commitSHA, oldSHA)
+
+	if err != nil {
+		return err
+	}
+
+	// [main (root-commit) 1a2b3c4] first line of the message
+	branch := strings.TrimPrefix(headRef, "refs/heads/")
+
+	if headRef == "HEAD" {
+		branch = "detached HEAD"
+	}
+
+	if len(parentSHAs) == 0 {
+		branch += " (root-commit)"
+	}
+
+	summary, _, _ := strings.Cut(messages[0], "\n")
+	fmt.Printf("[%s %s] %s\n", branch, commitSHA[:7], summary)
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestCommit(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeAndAdd := func(name string, content string) {
+		t.Helper()
+
+		path := filepath.Join(repo.WorkTree, name)
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.Add([]string{path}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeAndAdd("a.txt", "a\n")
+
+	tests := []struct {
+		name   string
+		before func()
+		args   []string
+		output string
+		err    string
+	}{
+		{"root commit", nil, []string{"-m", "first\n\nbody"}, `^\[main \(root-commit\) [0-9a-f]{7}\] first\n$`, ""},
+		{"nothing staged", nil, []string{"-m", "again"}, "", "nothing to commit, working tree clean"},
+		{"allow empty", nil, []string{"--allow-empty", "--message=empty"}, `^\[main [0-9a-f]{7}\] empty\n$`, ""},
+		{"no message", nil, []string{}, "", "aborting commit due to empty commit message, use -m <message>"},
+		{"second", func() { writeAndAdd("b.txt", "b\n") }, []string{"-m", "second"}, `^\[main [0-9a-f]{7}\] second\n$`, ""},
+		{"detached", func() {
+			sha, _ := repo.ResolveRef("HEAD")
+			os.WriteFile(filepath.Join(repo.GitDir, "HEAD"), []byte(sha+"\n"), 0644)
+			writeAndAdd("c.txt", "c\n")
+		}, []string{"-m", "detached"}, `^\[detached HEAD [0-9a-f]{7}\] detached\n$`, ""},
+	}
+
+	for _, test := range tests {
+		if test.before != nil {
+			test.before()
+		}
+
+		oldHead, _ := repo.ResolveRef("HEAD")
+
+		output, err := captureStdout(t, func() error {
+			return commit(repo, test.args)
+		})
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || !regexp.MustCompile(test.output).MatchString(output) {
+			t.Errorf("%s: printed %q (%v), want %s", test.name, output, err, test.output)
+			continue
+		}
+
+		head, _ := repo.ResolveRef("HEAD")
+		commitObject, err := repo.ReadCommit(head)
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		wantParents := []string{}
+
+		if oldHead != "" {
+			wantParents = append(wantParents, oldHead)
+		}
+
+		if len(commitObject.Parents) != len(wantParents) || len(wantParents) == 1 && commitObject.Parents[0] != wantParents[0] {
+			t.Errorf("%s: parents %v, want %v", test.name, commitObject.Parents, wantParents)
+		}
+	}
+
+	// the detached commit moved HEAD, not main
+	mainSHA, _ := repo.ResolveRef("refs/heads/main")
+	head, _ := repo.ResolveRef("HEAD")
+
+	if mainSHA == head {
+		t.Errorf("detached commit moved refs/heads/main")
+	}
+}
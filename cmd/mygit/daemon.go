@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git daemon listens here unless the url says otherwise
+const gitDaemonPort = "9418"
+
+// where a service is asked for from git daemon, git://host[:port]/path.
+// the path goes as it is, the daemon itself expands /~user
+type daemonAddress struct {
+	// host or host:port as in the url, for the host= parameter
+	host string
+	// what is dialed, with the default port
+	address string
+	path    string
+}
+
+func parseDaemonUrl(repoUrl string) (daemonAddress, bool) {
+	if !strings.HasPrefix(repoUrl, "git://") {
+		return daemonAddress{}, false
+	}
+
+	parsed, err := url.Parse(repoUrl)
+
+	if err != nil || parsed.Hostname() == "" || parsed.Path == "" {
+		return daemonAddress{}, false
+	}
+
+	port := parsed.Port()
+
+	if port == "" {
+		port = gitDaemonPort
+	}
+
+	return daemonAddress{host: parsed.Host, address: net.JoinHostPort(parsed.Hostname(), port), path: parsed.Path}, true
+}
+
+// https://git-scm.com/docs/pack-protocol#_git_transport, a plain tcp
+// connection that starts with the service and the repository we want.
+// extra parameters after a second NUL ask for protocol v2
+//
+//	0033git-upload-pack /project.git\0host=myserver.com\0
+//	003egit-upload-pack /project.git\0host=myserver.com\0\0version=2\0
+//
+// there is nothing like stderr, a daemon that refuses says "ERR <reason>"
+// or just hangs up
+func (a daemonAddress) connect(service string, gitProtocol string) (io.WriteCloser, io.ReadCloser, error) {
+	conn, err := net.Dial("tcp", a.address)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to %s: %w", a.host, err)
+	}
+
+	request := fmt.Sprintf("%s %s\x00host=%s\x00", service, a.path, a.host)
+
+	if gitProtocol != "" {
+		request += "\x00" + gitProtocol + "\x00"
+	}
+
+	if _, err := io.WriteString(conn, helper.FormatPacketLine(request)); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("error writing to %s: %w", a.host, err)
+	}
+
+	return &connectionInput{conn: conn.(*net.TCPConn)}, conn, nil
+}
+
+// our half of the connection, closing it tells the service there is no
+// more to read while its answer can still come back
+type connectionInput struct {
+	conn *net.TCPConn
+}
+
+func (i *connectionInput) Write(b []byte) (int, error) {
+	return i.conn.Write(b)
+}
+
+func (i *connectionInput) Close() error {
+	return i.conn.CloseWrite()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestParseDaemonUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want daemonAddress
+		ok   bool
+	}{
+		{"git://example.com/repo.git", daemonAddress{host: "example.com", address: "example.com:9418", path: "/repo.git"}, true},
+		{"git://example.com:1234/repo.git", daemonAddress{host: "example.com:1234", address: "example.com:1234", path: "/repo.git"}, true},
+		{"git://[::1]/repo.git", daemonAddress{host: "[::1]", address: "[::1]:9418", path: "/repo.git"}, true},
+		// the daemon expands /~user itself
+		{"git://example.com/~paul/repo.git", daemonAddress{host: "example.com", address: "example.com:9418", path: "/~paul/repo.git"}, true},
+		{"git://example.com", daemonAddress{}, false},
+		{"https://example.com/repo.git", daemonAddress{}, false},
+		{"example.com:repo.git", daemonAddress{}, false},
+	}
+
+	for _, test := range tests {
+		got, ok := parseDaemonUrl(test.url)
+
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("parseDaemonUrl(%q) = %+v, %v, want %+v, %v", test.url, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// what a daemon saw on its connection: the request pkt-line and what the
+// client wrote after the advertisement
+type daemonExchange struct {
+	request string
+	rest    string
+}
+
+// one pkt-line as it came, the length included
+func readRawPacket(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+
+	var length int
+
+	if _, err := fmt.Sscanf(string(header), "%04x", &length); err != nil || length < 4 {
+		return "", fmt.Errorf("bad pkt-line length %q", header)
+	}
+
+	payload := make([]byte, length-4)
+
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", err
+	}
+
+	return string(header) + string(payload), nil
+}
+
+// a daemon at the returned address that answers one connection with advertisement
+func serveOnce(t *testing.T, advertisement string) (string, <-chan daemonExchange) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	exchanges := make(chan daemonExchange, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		request, err := readRawPacket(conn)
+
+		if err != nil {
+			t.Errorf("reading the request line: %v", err)
+			close(exchanges)
+			return
+		}
+
+		io.WriteString(conn, advertisement)
+		rest, _ := io.ReadAll(conn)
+		exchanges <- daemonExchange{request: request, rest: string(rest)}
+	}()
+
+	return listener.Addr().String(), exchanges
+}
+
+func TestDaemonTransport(t *testing.T) {
+	v0Advertisement := helper.FormatPacketLine("47b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEAD\x00multi_ack ofs-delta symref=HEAD:refs/heads/master\n") +
+		helper.FormatPacketLine("47b37f1a82bfe85f6d8df52b6258b75e4343b7fd refs/heads/master\n") +
+		"0000"
+	v2Advertisement := helper.FormatPacketLine("version 2\n") +
+		helper.FormatPacketLine("agent=git/2.43.0\n") +
+		helper.FormatPacketLine("ls-refs=unborn\n") +
+		helper.FormatPacketLine("fetch=shallow wait-for-done filter\n") +
+		"0000"
+
+	tests := []struct {
+		name          string
+		gitProtocol   string
+		advertisement string
+		// the request after the "host=" parameter
+		extra string
+		// v0 waits for wants, a flush tells it there are none
+		rest string
+	}{
+		{"v0", "", v0Advertisement, "", "0000"},
+		{"v2", "version=2", v2Advertisement, "\x00version=2\x00", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, exchanges := serveOnce(t, test.advertisement)
+			daemon, ok := parseDaemonUrl("git://" + address + "/project.git")
+
+			if !ok {
+				t.Fatalf("parseDaemonUrl refused %s", address)
+			}
+
+			transport := &connectionTransport{connect: daemon.connect}
+			advertisement, err := transport.Advertise("git-upload-pack", test.gitProtocol)
+
+			if err != nil {
+				t.Fatalf("Advertise failed: %v", err)
+			}
+
+			if string(advertisement) != test.advertisement {
+				t.Errorf("advertisement %q, want %q", advertisement, test.advertisement)
+			}
+
+			exchange := <-exchanges
+			payload := "git-upload-pack /project.git\x00host=" + address + "\x00" + test.extra
+			want := fmt.Sprintf("%04x", len(payload)+4) + payload
+
+			if exchange.request != want {
+				t.Errorf("request line %q, want %q", exchange.request, want)
+			}
+
+			if exchange.rest != test.rest {
+				t.Errorf("after the advertisement the client wrote %q, want %q", exchange.rest, test.rest)
+			}
+		})
+	}
+}
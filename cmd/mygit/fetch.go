@@ -0,0 +1,596 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// what fetch asks upload-pack for: ACK/NAK per have instead of a single NAK,
+// ref-deltas against objects we already have, annotated tags that point
+// into what is being sent and the pack multiplexed with progress
+var fetchCapabilities = []string{"multi_ack_detailed", "thin-pack", "ofs-delta", "include-tag", "side-band-64k", "agent=mygit/0.1"}
+
+// a remote ref and where it goes, from a refspec like
+// +refs/heads/*:refs/remotes/origin/*
+type refUpdate struct {
+	remoteName string
+	localName  string
+	newSHA     string
+	force      bool
+}
+
+// git fetch [<remote>]
+//
+//  1. ref discovery, the refspecs of remote.<remote>.fetch say which
+//     remote refs we want and which local refs they update
+//  2. negotiation, tell the server which commits we have so it only
+//     sends what is missing
+//  3. the pack, possibly thin, is stored like in clone
+//  4. the remote-tracking refs move, non fast-forward updates only
+//     when the refspec starts with +
+func fetch(repo *helper.Repository, args []string) error {
+	remote := "origin"
+
+	if len(args) > 1 {
+		return errors.New("usage: mygit fetch [<remote>]")
+	}
+
+	if len(args) == 1 {
+		remote = args[0]
+	}
+
+	config, err := repo.Config()
+
+	if err != nil {
+		return err
+	}
+
+	repoUrl, ok := config.Get("remote." + remote + ".url")
+
+	if !ok {
+		return fmt.Errorf("'%s' does not appear to be a git repository", remote)
+	}
+
+	refspecs := config.GetAll("remote." + remote + ".fetch")
+
+	if len(refspecs) == 0 {
+		refspecs = []string{"+refs/heads/*:refs/remotes/" + remote + "/*"}
+	}
+
+	// the tags are there for the auto-follow below
+	advertisement, err := refDiscovery(repoUrl, "git-upload-pack", append(refspecPrefixes(refspecs), "refs/tags/"))
+
+	if err != nil {
+		return err
+	}
+
+	updates := []refUpdate{}
+
+	for _, ref := range advertisement.Refs {
+		for _, refspec := range refspecs {
+			if localName, force, ok := mapRefspec(refspec, ref.Name); ok {
+				updates = append(updates, refUpdate{remoteName: ref.Name, localName: localName, newSHA: ref.SHA, force: force})
+				break
+			}
+		}
+	}
+
+	wants := []string{}
+
+	for _, update := range updates {
+		if !repo.Objects.Has(update.newSHA) && !helper.ArrayContains(wants, update.newSHA) {
+			wants = append(wants, update.newSHA)
+		}
+	}
+
+	if len(wants) > 0 {
+		// a partial clone keeps leaving out what its filter says
+		filter, _ := config.Get("remote." + remote + ".partialclonefilter")
+		request := packRequest{wants: wants, capabilities: fetchCapabilities, filter: filter}
+		response, err := fetchPack(repo, repoUrl, advertisement, request)
+
+		if err != nil {
+			return err
+		}
+
+		defer response.Close()
+
+		packName, err := processPacketFile(repo, response.Pack, os.Stdout, 0)
+
+		if err != nil {
+			return fmt.Errorf("error while processing pack file: %w", err)
+		}
+
+		if promisor, _ := config.Get("remote." + remote + ".promisor"); promisor == "true" {
+			if err := repo.MarkPromisorPack(packName); err != nil {
+				return err
+			}
+		}
+
+		if err := repo.UpdateShallow(response.Shallow, response.Unshallow); err != nil {
+			return err
+		}
+	}
+
+	// tags are followed when they point at something we now have
+	for _, ref := range advertisement.Refs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || strings.HasSuffix(ref.Name, "^{}") {
+			continue
+		}
+
+		if _, err := repo.ResolveRef(ref.Name); err == nil || !repo.Objects.Has(ref.SHA) {
+			continue
+		}
+
+		updates = append(updates, refUpdate{remoteName: ref.Name, localName: ref.Name, newSHA: ref.SHA})
+	}
+
+	fmt.Fprintf(os.Stderr, "From %s\n", repoUrl)
+
+	rejected := false
+
+	for _, update := range updates {
+		ok, err := applyRefUpdate(repo, update)
+
+		if err != nil {
+			return err
+		}
+
+		rejected = rejected || !ok
+	}
+
+	if rejected {
+		return fmt.Errorf("some local refs could not be updated")
+	}
+
+	return nil
+}
+
+// the local ref a remote ref maps to, refspecs are <src>:<dst> with at
+// most one * on each side and an optional leading + to allow non fast-forwards
+func mapRefspec(refspec string, remoteName string) (string, bool, bool) {
+	force := strings.HasPrefix(refspec, "+")
+	source, destination, found := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+
+	if !found || destination == "" {
+		return "", false, false
+	}
+
+	prefix, suffix, glob := strings.Cut(source, "*")
+
+	if !glob {
+		return destination, force, source == remoteName
+	}
+
+	if !strings.HasPrefix(remoteName, prefix) || !strings.HasSuffix(remoteName, suffix) || len(remoteName) < len(prefix)+len(suffix) {
+		return "", false, false
+	}
+
+	match := remoteName[len(prefix) : len(remoteName)-len(suffix)]
+
+	return strings.Replace(destination, "*", match, 1), force, true
+}
+
+// move one local ref and print a line like git fetch does
+//
+//	From http://example.com/repo.git
+//	 * [new branch]      feature    -> origin/feature
+//	   6e0d425..18b8bf0  master     -> origin/master
+//	 + 6e0d425...904b3d3 rewritten  -> origin/rewritten  (forced update)
+//	 ! [rejected]        rewritten  -> origin/rewritten  (non-fast-forward)
+func applyRefUpdate(repo *helper.Repository, update refUpdate) (bool, error) {
+	remoteShort := shortRefName(update.remoteName)
+	localShort := shortRefName(update.localName)
+
+	oldSHA, err := repo.ResolveRef(update.localName)
+
+	if errors.Is(err, helper.ErrRefNotFound) {
+		kind := "[new branch]"
+
+		if strings.HasPrefix(update.remoteName, "refs/tags/") {
+			kind = "[new tag]"
+		} else if !strings.HasPrefix(update.remoteName, "refs/heads/") {
+			kind = "[new ref]"
+		}
+
+		fmt.Fprintf(os.Stderr, " * %-17s %-10s -> %s\n", kind, remoteShort, localShort)
+
+		return true, repo.UpdateRef(update.localName, update.newSHA, helper.ZeroSHA)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if oldSHA == update.newSHA {
+		return true, nil
+	}
+
+	fastForward, err := repo.IsAncestor(oldSHA, update.newSHA)
+
+	// tags and other refs that do not point at commits are never fast-forwards
+	if err != nil {
+		fastForward = false
+	}
+
+	if !fastForward && !update.force {
+		fmt.Fprintf(os.Stderr, " ! %-17s %-10s -> %s  (non-fast-forward)\n", "[rejected]", remoteShort, localShort)
+		return false, nil
+	}
+
+	if fastForward {
+		fmt.Fprintf(os.Stderr, "   %-17s %-10s -> %s\n", oldSHA[:7]+".."+update.newSHA[:7], remoteShort, localShort)
+	} else {
+		fmt.Fprintf(os.Stderr, " + %-17s %-10s -> %s  (forced update)\n", oldSHA[:7]+"..."+update.newSHA[:7], remoteShort, localShort)
+	}
+
+	return true, repo.UpdateRef(update.localName, update.newSHA, oldSHA)
+}
+
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+		if short, found := strings.CutPrefix(name, prefix); found {
+			return short
+		}
+	}
+
+	return name
+}
+
+// what fetchPack asks the server for
+type packRequest struct {
+	wants []string
+	// the v0 capabilities we would like, only the ones the server has are sent
+	capabilities []string
+	shallow      shallowOptions
+	// a filter-spec like blob:none for a partial clone
+	filter string
+	// the objects a partial clone is missing are asked for without haves,
+	// the server would leave out whatever our commits reach
+	noHaves bool
+}
+
+// the pack with the wants, after telling the server what we have. v2 has
+// its own fetch command. the response also says which commits became shallow,
+// the pack is read from it as it arrives and the caller closes it
+func fetchPack(repo *helper.Repository, repoUrl string, advertisement *helper.Advertisement, request packRequest) (*helper.FetchResponse, error) {
+	if path, isLocal := localRepositoryPath(repoUrl); isLocal {
+		return localFetchPack(repo, path, request)
+	}
+
+	requestLines, capabilities, err := shallowRequest(repo, advertisement, request.shallow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if request.filter != "" {
+		supported := advertisement.HasCapability("filter")
+
+		if advertisement.Version == 2 {
+			supported = advertisement.HasCommandFeature("fetch", "filter")
+		}
+
+		if !supported {
+			return nil, errors.New("the server does not support filters")
+		}
+
+		requestLines = append(requestLines, "filter "+request.filter)
+		capabilities = append(capabilities, "filter")
+	}
+
+	walker, err := negotiationWalker(repo)
+
+	if request.noHaves {
+		walker, err = repo.NewCommitWalker(nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if advertisement.Version == 2 {
+		return fetchPackV2(repoUrl, advertisement, request.wants, walker, requestLines)
+	}
+
+	capabilities = supportedCapabilities(advertisement, append(append([]string{}, request.capabilities...), capabilities...))
+	transport, err := newTransport(repoUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a server on a connection of its own remembers the rounds, the
+	// negotiation and the pack all go through that connection
+	if connection, ok := transport.(*connectionTransport); ok && helper.ArrayContains(capabilities, "multi_ack_detailed") {
+		return negotiateOnConnection(connection, request.wants, capabilities, walker, requestLines)
+	}
+
+	common, err := negotiate(repoUrl, request.wants, capabilities, walker, requestLines)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return requestPackFile(repoUrl, request.wants, common, capabilities, requestLines)
+}
+
+// the have/want exchange of https://git-scm.com/docs/pack-protocol#_packfile_negotiation
+// over stateless http, where every round repeats the wants and the haves
+// the server already acknowledged
+//
+//	C: want <sha> multi_ack_detailed ...   S: ACK <sha> common
+//	C: want <sha>                          S: ACK <sha> common
+//	C: 0000                                S: NAK
+//	C: have <sha>
+//	C: ... 32 haves
+//	C: 0000
+//
+// our history is walked newest first, once the server says a commit is
+// common its ancestors are not sent anymore. the rounds end when the server
+// is ready to send the pack or we run out of commits. returns the common commits
+func negotiate(repoUrl string, wants []string, capabilities []string, walker *helper.CommitWalker, requestLines []string) ([]string, error) {
+	common := []string{}
+
+	// without multi_ack_detailed the server only answers once, after done
+	if !helper.ArrayContains(capabilities, "multi_ack_detailed") {
+		for len(common) < 256 {
+			sha, err := walker.Next()
+
+			if err != nil || sha == "" {
+				return common, err
+			}
+
+			common = append(common, sha)
+		}
+
+		return common, nil
+	}
+
+	// git gives up after this many haves without a new ACK
+	const maxInVain = 256
+	inVain := 0
+
+	for inVain < maxInVain {
+		haves, err := nextHaves(walker)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		requestBody := wantLines(wants, capabilities, requestLines) + "0000"
+
+		for _, sha := range append(append([]string{}, common...), haves...) {
+			requestBody += helper.FormatPacketLine(fmt.Sprintf("have %s\n", sha))
+		}
+
+		requestBody += "0000"
+
+		response, err := uploadPack(repoUrl, requestBody)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ready := false
+		inVain += len(haves)
+
+		for _, line := range helper.ParsePacketLines(response) {
+			fields := strings.Fields(line)
+
+			if len(fields) < 2 || fields[0] != "ACK" {
+				continue
+			}
+
+			if !helper.ArrayContains(common, fields[1]) {
+				common = append(common, fields[1])
+				walker.Hide(fields[1])
+				inVain = 0
+			}
+
+			ready = ready || (len(fields) > 2 && fields[2] == "ready")
+		}
+
+		if ready {
+			break
+		}
+	}
+
+	return common, nil
+}
+
+// the same negotiation with a v0 upload-pack on a connection of its own
+// (ssh, git://), which remembers the wants and every have it was sent. a
+// round is only the next haves and the pack comes in the same connection
+//
+//	C: want <sha> multi_ack_detailed ...
+//	C: 0000                               S: shallow lines and 0000 when deepening
+//	C: have <sha>
+//	C: ... 32 haves
+//	C: 0000                               S: ACK <sha> common ... NAK
+//	C: done                               S: ACK <sha> or NAK, the pack
+func negotiateOnConnection(connection *connectionTransport, wants []string, capabilities []string, walker *helper.CommitWalker, requestLines []string) (*helper.FetchResponse, error) {
+	input, packets, output, err := connection.open("git-upload-pack", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the shallow lines come before the first answer, they are kept for
+	// the response with the pack
+	shallow, unshallow := []string{}, []string{}
+
+	send := func(request string) error {
+		if _, err := io.WriteString(input, request); err != nil {
+			return fmt.Errorf("error writing to git-upload-pack: %w", err)
+		}
+
+		return nil
+	}
+
+	// the answer to a round, up to its NAK. ready means the server has
+	// enough to make a pack
+	readAnswer := func() (common []string, ready bool, err error) {
+		for {
+			payload, kind, err := packets.ReadPacket()
+
+			if errors.Is(err, io.EOF) {
+				return nil, false, errors.New("invalid upload-pack response: the connection ended during negotiation")
+			}
+
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid upload-pack response: %w", err)
+			}
+
+			if kind != -1 {
+				continue
+			}
+
+			fields := strings.Fields(string(payload))
+
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "NAK":
+				return common, ready, nil
+			case fields[0] == "ERR":
+				return nil, false, fmt.Errorf("remote error: %s", strings.TrimSpace(strings.TrimPrefix(string(payload), "ERR ")))
+			case fields[0] == "shallow" && len(fields) == 2:
+				shallow = append(shallow, fields[1])
+			case fields[0] == "unshallow" && len(fields) == 2:
+				unshallow = append(unshallow, fields[1])
+			case fields[0] == "ACK" && len(fields) > 2:
+				common = append(common, fields[1])
+				ready = ready || fields[2] == "ready"
+			}
+		}
+	}
+
+	fail := func(err error) (*helper.FetchResponse, error) {
+		input.Close()
+		output.Close()
+
+		return nil, err
+	}
+
+	if err := send(wantLines(wants, capabilities, requestLines) + "0000"); err != nil {
+		return fail(err)
+	}
+
+	// git gives up after this many haves without a new ACK
+	const maxInVain = 256
+	inVain := 0
+	knownCommon := map[string]bool{}
+
+	for inVain < maxInVain {
+		haves, err := nextHaves(walker)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if len(haves) == 0 {
+			break
+		}
+
+		request := ""
+
+		for _, sha := range haves {
+			request += helper.FormatPacketLine(fmt.Sprintf("have %s\n", sha))
+		}
+
+		if err := send(request + "0000"); err != nil {
+			return fail(err)
+		}
+
+		common, ready, err := readAnswer()
+
+		if err != nil {
+			return fail(err)
+		}
+
+		inVain += len(haves)
+
+		for _, sha := range common {
+			if !knownCommon[sha] {
+				knownCommon[sha] = true
+				walker.Hide(sha)
+				inVain = 0
+			}
+		}
+
+		if ready {
+			break
+		}
+	}
+
+	if err := send(helper.FormatPacketLine("done\n")); err != nil {
+		return fail(err)
+	}
+
+	if err := input.Close(); err != nil {
+		output.Close()
+		return nil, err
+	}
+
+	// everything is written by now
+	body := &connectionResponse{reader: packets, output: output, writeDone: true}
+	response, err := helper.ParseUploadPackResponse(body, helper.ArrayContains(capabilities, "side-band-64k"), os.Stderr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response.Shallow = append(shallow, response.Shallow...)
+	response.Unshallow = append(unshallow, response.Unshallow...)
+
+	return response, nil
+}
+
+// the next commits to tell the server about, 32 at a time like git
+func nextHaves(walker *helper.CommitWalker) ([]string, error) {
+	haves := []string{}
+
+	for len(haves) < 32 {
+		sha, err := walker.Next()
+
+		if err != nil || sha == "" {
+			return haves, err
+		}
+
+		haves = append(haves, sha)
+	}
+
+	return haves, nil
+}
+
+// the haves come from every local ref and HEAD, newest commits first
+func negotiationWalker(repo *helper.Repository) (*helper.CommitWalker, error) {
+	tips := []string{}
+	refs, err := repo.ListRefs("refs/")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if headSHA, err := repo.ResolveRef("HEAD"); err == nil {
+		refs = append(refs, helper.Ref{Name: "HEAD", SHA: headSHA})
+	}
+
+	for _, ref := range refs {
+		// tags of trees or blobs have no history
+		if commitSHA, err := repo.ResolveRevision(ref.SHA); err == nil {
+			tips = append(tips, commitSHA)
+		}
+	}
+
+	return repo.NewCommitWalker(tips)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// a chain of count commits of the empty tree, oldest first
+func commitChain(t *testing.T, repo *helper.Repository, parent string, count int) []string {
+	t.Helper()
+
+	tree, err := repo.Objects.Write("tree", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits := []string{}
+
+	for i := 0; i < count; i++ {
+		parents := []string{}
+
+		if parent != "" {
+			parents = append(parents, parent)
+		}
+
+		signature := helper.Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(int64(1000000+len(commits)*60), 0).UTC()}
+		sha, err := repo.CommitTree(tree, parents, fmt.Sprintf("%s %d", parent, i), signature, signature)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		commits = append(commits, sha)
+		parent = sha
+	}
+
+	return commits
+}
+
+func TestMapRefspec(t *testing.T) {
+	tests := []struct {
+		refspec    string
+		remoteName string
+		localName  string
+		force      bool
+		ok         bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/main", "refs/remotes/origin/main", true, true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/feature/x", "refs/remotes/origin/feature/x", true, true},
+		{"refs/heads/*:refs/remotes/origin/*", "refs/tags/v1.0", "", false, false},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/main", "refs/remotes/origin/main", false, true},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/mainline", "", false, false},
+		{"refs/heads/release-*-rc:refs/rc/*", "refs/heads/release-1.0-rc", "refs/rc/1.0", false, true},
+		{"refs/heads/release-*-rc:refs/rc/*", "refs/heads/release-rc", "", false, false},
+		{"refs/heads/main", "refs/heads/main", "", false, false},
+		{"refs/heads/main:", "refs/heads/main", "", false, false},
+	}
+
+	for _, test := range tests {
+		localName, force, ok := mapRefspec(test.refspec, test.remoteName)
+
+		if ok != test.ok || ok && (localName != test.localName || force != test.force) {
+			t.Errorf("mapRefspec(%s, %s) = %s, %v, %v, want %s, %v, %v", test.refspec, test.remoteName, localName, force, ok, test.localName, test.force, test.ok)
+		}
+	}
+}
+
+func TestApplyRefUpdate(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := commitChain(t, repo, "", 3)
+	rewritten := commitChain(t, repo, history[0], 2)
+
+	tests := []struct {
+		name   string
+		update refUpdate
+		ok     bool
+		want   string
+	}{
+		{"new branch", refUpdate{"refs/heads/main", "refs/remotes/origin/main", history[1], false}, true, history[1]},
+		{"fast-forward", refUpdate{"refs/heads/main", "refs/remotes/origin/main", history[2], false}, true, history[2]},
+		{"up to date", refUpdate{"refs/heads/main", "refs/remotes/origin/main", history[2], false}, true, history[2]},
+		{"non-fast-forward", refUpdate{"refs/heads/main", "refs/remotes/origin/main", rewritten[1], false}, false, history[2]},
+		{"forced", refUpdate{"refs/heads/main", "refs/remotes/origin/main", rewritten[1], true}, true, rewritten[1]},
+		{"new tag", refUpdate{"refs/tags/v1.0", "refs/tags/v1.0", history[0], false}, true, history[0]},
+	}
+
+	for _, test := range tests {
+		ok, err := applyRefUpdate(repo, test.update)
+
+		if err != nil || ok != test.ok {
+			t.Errorf("%s: got %v, %v, want %v", test.name, ok, err, test.ok)
+		}
+
+		if sha, _ := repo.ResolveRef(test.update.localName); sha != test.want {
+			t.Errorf("%s: %s is at %s, want %s", test.name, test.update.localName, sha, test.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more commits than fit in one round of 32 haves
+	history := commitChain(t, repo, "", 40)
+
+	if err := repo.UpdateRef("refs/heads/main", history[39], helper.ZeroSHA); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Repeat("f", 40)
+	common := history[4]
+	requests := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+
+		// the server has the oldest commits only, it is ready once it sees one
+		if strings.Contains(string(body), "have "+common) {
+			io.WriteString(w, helper.FormatPacketLine("ACK "+common+" common\n"))
+			io.WriteString(w, helper.FormatPacketLine("ACK "+common+" ready\n"))
+		}
+
+		io.WriteString(w, helper.FormatPacketLine("NAK\n"))
+	}))
+
+	defer server.Close()
+
+	capabilities := []string{"multi_ack_detailed", "ofs-delta"}
+	walker, err := negotiationWalker(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := negotiate(server.URL, []string{want}, capabilities, walker, nil)
+
+	if err != nil || len(got) != 1 || got[0] != common {
+		t.Errorf("negotiate = %v, %v, want [%s]", got, err, common)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("%d rounds, want 2", len(requests))
+	}
+
+	for i, request := range requests {
+		lines := helper.ParsePacketLines([]byte(request))
+
+		if len(lines) == 0 || lines[0] != "want "+want+" multi_ack_detailed ofs-delta\n" {
+			t.Errorf("round %d does not start with the wants: %q", i, lines)
+		}
+
+		// newest first, 32 haves per round
+		haves := strings.Count(request, "have ")
+		wantHaves := []int{32, 8}[i]
+
+		if haves != wantHaves || !strings.Contains(request, "have "+history[39-32*i]+"\n") {
+			t.Errorf("round %d sent %d haves, want %d from %s", i, haves, wantHaves, history[39-32*i])
+		}
+	}
+
+	// without multi_ack_detailed every have goes with the final request
+	requests = nil
+	walker, _ = negotiationWalker(repo)
+	got, err = negotiate(server.URL, []string{want}, []string{"ofs-delta"}, walker, nil)
+
+	if err != nil || len(got) != 40 || got[0] != history[39] || len(requests) != 0 {
+		t.Errorf("negotiate without multi_ack_detailed = %d haves, %v, %d requests", len(got), err, len(requests))
+	}
+}
+
+func TestNegotiateOnConnection(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := commitChain(t, repo, "", 40)
+
+	if err := repo.UpdateRef("refs/heads/main", history[39], helper.ZeroSHA); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Repeat("f", 40)
+	common := history[4]
+	shallow := strings.Repeat("e", 40)
+
+	// the server reads a round before it answers, so its side can be
+	// written out in advance: the advertisement (skipped), the shallow
+	// lines for the deepen, a NAK for the first round, the ACKs for the
+	// second and the pack after done
+	answers := "0000" +
+		helper.FormatPacketLine("shallow "+shallow+"\n") + "0000" +
+		helper.FormatPacketLine("NAK\n") +
+		helper.FormatPacketLine("ACK "+common+" common\n") +
+		helper.FormatPacketLine("ACK "+common+" ready\n") +
+		helper.FormatPacketLine("NAK\n") +
+		helper.FormatPacketLine("ACK "+common+"\n") +
+		"PACK the pack"
+
+	input := &requestRecorder{}
+	connection := &connectionTransport{connect: func(service string, gitProtocol string) (io.WriteCloser, io.ReadCloser, error) {
+		return input, io.NopCloser(strings.NewReader(answers)), nil
+	}}
+
+	walker, err := negotiationWalker(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := negotiateOnConnection(connection, []string{want}, []string{"multi_ack_detailed"}, walker, []string{"deepen 1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := io.ReadAll(response.Pack)
+	response.Close()
+
+	if err != nil || string(pack) != "PACK the pack" {
+		t.Errorf("pack %q, %v", pack, err)
+	}
+
+	if len(response.Shallow) != 1 || response.Shallow[0] != shallow {
+		t.Errorf("shallow %v, want [%s]", response.Shallow, shallow)
+	}
+
+	// the wants once, then only the haves of each round, split at the flushes
+	written := input.String()
+	rounds := [][]string{{}}
+	packets := helper.NewPacketReader(strings.NewReader(written))
+
+	for {
+		payload, kind, err := packets.ReadPacket()
+
+		if err != nil {
+			break
+		}
+
+		if kind == 0 {
+			rounds = append(rounds, []string{})
+			continue
+		}
+
+		rounds[len(rounds)-1] = append(rounds[len(rounds)-1], string(payload))
+	}
+
+	if len(rounds) != 4 || !input.closed {
+		t.Fatalf("wrote %q, want the wants, two rounds and done", written)
+	}
+
+	if lines := rounds[0]; len(lines) != 2 || lines[0] != "want "+want+" multi_ack_detailed\n" || lines[1] != "deepen 1\n" {
+		t.Errorf("the wants are %q", lines)
+	}
+
+	for i, round := range rounds[1:3] {
+		wantHaves := []int{32, 8}[i]
+
+		if len(round) != wantHaves || round[0] != "have "+history[39-32*i]+"\n" {
+			t.Errorf("round %d is %q, want %d haves from %s", i, round, wantHaves, history[39-32*i])
+		}
+	}
+
+	if len(rounds[3]) != 1 || rounds[3][0] != "done\n" {
+		t.Errorf("the rounds end with %q, want done", rounds[3])
+	}
+}
+
+func TestSupportedCapabilities(t *testing.T) {
+	advertisement, err := helper.ParseAdvertisement([]string{
+		strings.Repeat("a", 40) + " HEAD\x00multi_ack_detailed ofs-delta side-band-64k agent=git/2.43.0\n",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := supportedCapabilities(advertisement, []string{"multi_ack_detailed", "thin-pack", "ofs-delta", "side-band-64k", "agent=mygit/0.1"})
+	want := "multi_ack_detailed ofs-delta side-band-64k agent=mygit/0.1"
+
+	if strings.Join(got, " ") != want {
+		t.Errorf("supportedCapabilities = %q, want %q", got, want)
+	}
+}
+
+func TestRequestPackFile(t *testing.T) {
+	band := func(number byte, payload string) string {
+		return helper.FormatPacketLine(string(number) + payload)
+	}
+
+	tests := []struct {
+		name         string
+		response     string
+		capabilities []string
+		pack         string
+		shallow      string
+		err          string
+	}{
+		{"plain", helper.FormatPacketLine("NAK\n") + "PACKdata", nil, "PACKdata", "", ""},
+		{"acks before the pack", helper.FormatPacketLine("ACK "+strings.Repeat("a", 40)+"\n") + "PACKdata", nil, "PACKdata", "", ""},
+		{"side-band", helper.FormatPacketLine("NAK\n") + band(2, "Counting\n") + band(1, "PACK") + band(1, "data") + "0000", []string{"side-band-64k"}, "PACKdata", "", ""},
+		{"shallow", helper.FormatPacketLine("shallow "+strings.Repeat("c", 40)+"\n") + "0000" + helper.FormatPacketLine("NAK\n") + band(1, "PACK") + "0000", []string{"side-band-64k"}, "PACK", strings.Repeat("c", 40), ""},
+		{"side-band error", helper.FormatPacketLine("NAK\n") + band(3, "repository corrupt\n"), []string{"side-band-64k"}, "", "", "remote error: repository corrupt"},
+		{"side-band without pack", helper.FormatPacketLine("NAK\n") + "0000", []string{"side-band-64k"}, "", "", "no pack data"},
+		{"ERR line", helper.FormatPacketLine("ERR upload-pack: not our ref\n"), nil, "", "", "remote error: upload-pack: not our ref"},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, test.response)
+		}))
+
+		response, err := requestPackFile(server.URL, []string{strings.Repeat("b", 40)}, nil, test.capabilities, nil)
+
+		// the pack is streamed from the response, errors after its start come with it
+		var pack []byte
+
+		if err == nil {
+			pack, err = io.ReadAll(response.Pack)
+			response.Close()
+		}
+
+		server.Close()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || string(pack) != test.pack || strings.Join(response.Shallow, " ") != test.shallow {
+			t.Errorf("%s: got %+v, %v, want %q", test.name, response, err, test.pack)
+		}
+	}
+}
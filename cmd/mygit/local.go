@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// a remote on this machine, /srv/git/project.git or file:///srv/git/project.git.
+// there is no upload-pack on the other side, its refs and objects are read
+// directly. host:path is an ssh url
+func localRepositoryPath(repoUrl string) (string, bool) {
+	if path, found := strings.CutPrefix(repoUrl, "file://"); found {
+		return path, true
+	}
+
+	return repoUrl, !strings.Contains(repoUrl, "://") && !isScpLikeUrl(repoUrl)
+}
+
+func openLocalRepository(path string) (*helper.Repository, error) {
+	source, err := helper.OpenRepositoryAt(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("'%s' does not appear to be a git repository", path)
+	}
+
+	return source, nil
+}
+
+// the refs of a local repository, all of them like a v0 server
+func localRefDiscovery(path string, service string) (*helper.Advertisement, error) {
+	if service != "git-upload-pack" {
+		return nil, errors.New("pushing to a local repository is not supported")
+	}
+
+	source, err := openLocalRepository(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return helper.NewLocalAdvertisement(source)
+}
+
+// the objects of the wants we are missing, as a pack written from the local
+// repository while it is read. there is no negotiation, our refs are what
+// we have
+func localFetchPack(repo *helper.Repository, path string, request packRequest) (*helper.FetchResponse, error) {
+	if request.shallow.deepens() {
+		return nil, errors.New("shallow fetches from a local repository are not supported")
+	}
+
+	source, err := openLocalRepository(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := repo.ListRefs("refs/")
+
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]string, 0, len(refs))
+
+	for _, ref := range refs {
+		known = append(known, ref.SHA)
+	}
+
+	objects, err := source.ReachableObjects(request.wants, known)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the history of a shallow source ends where it does, so does ours
+	shallowCommits, err := source.ShallowCommits()
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := helper.NewPackResponse(source.StreamPack(objects))
+	response.Shallow = shallowCommits
+
+	return response, nil
+}
+
+// git clone <path>, the objects are copied instead of fetched. with
+// --shared nothing is copied, objects/info/alternates points at the
+// objects of the source, which must then stay where it is
+func cloneLocalObjects(repo *helper.Repository, path string, shared bool) error {
+	source, err := openLocalRepository(path)
+
+	if err != nil {
+		return err
+	}
+
+	if shared {
+		err = repo.AddAlternates([]string{source.GitDir + "/objects"})
+	} else {
+		err = repo.CopyObjects(source)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// a shallow source makes a shallow clone
+	shallowCommits, err := source.ShallowCommits()
+
+	if err != nil {
+		return err
+	}
+
+	return repo.UpdateShallow(shallowCommits, nil)
+}
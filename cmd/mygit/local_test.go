@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestLocalRepositoryPath(t *testing.T) {
+	tests := []struct {
+		url     string
+		path    string
+		isLocal bool
+	}{
+		{"/srv/git/project.git", "/srv/git/project.git", true},
+		{"../project", "../project", true},
+		{"file:///srv/git/project.git", "/srv/git/project.git", true},
+		{"https://github.com/codecrafters-io/git-sample-1", "", false},
+		{"ssh://git@example.com/project.git", "", false},
+	}
+
+	for _, test := range tests {
+		path, isLocal := localRepositoryPath(test.url)
+
+		if isLocal != test.isLocal || isLocal && path != test.path {
+			t.Errorf("localRepositoryPath(%s) = %s, %v, want %s, %v", test.url, path, isLocal, test.path, test.isLocal)
+		}
+	}
+}
+
+func TestLocalClone(t *testing.T) {
+	requireGit(t)
+
+	bareDir, _ := newSourceRepository(t)
+	head := runGit(t, bareDir, "rev-parse", "HEAD")
+
+	tests := []struct {
+		name    string
+		url     string
+		options cloneOptions
+		// only --shared borrows the objects of the source
+		alternates bool
+	}{
+		{"path", bareDir, cloneOptions{}, false},
+		{"file url", "file://" + bareDir, cloneOptions{}, false},
+		{"shared", bareDir, cloneOptions{shared: true}, true},
+	}
+
+	for _, test := range tests {
+		cloneDir := filepath.Join(t.TempDir(), "clone")
+
+		_, err := captureStdout(t, func() error {
+			return CloneRepo(test.url, cloneDir, test.options)
+		})
+
+		if err != nil {
+			t.Errorf("%s: clone failed: %v", test.name, err)
+			continue
+		}
+
+		if got := runGit(t, cloneDir, "rev-parse", "HEAD"); got != head {
+			t.Errorf("%s: HEAD is %s, want %s", test.name, got, head)
+		}
+
+		runGit(t, cloneDir, "fsck", "--strict")
+
+		if content, err := os.ReadFile(filepath.Join(cloneDir, "src", "main.go")); err != nil || string(content) != "package main\n" {
+			t.Errorf("%s: src/main.go = %q, %v", test.name, content, err)
+		}
+
+		_, err = os.Stat(filepath.Join(cloneDir, ".git", "objects", "info", "alternates"))
+
+		if (err == nil) != test.alternates {
+			t.Errorf("%s: objects/info/alternates exists: %v, want %v", test.name, err == nil, test.alternates)
+		}
+	}
+}
+
+func TestLocalFetch(t *testing.T) {
+	requireGit(t)
+
+	bareDir, _ := newSourceRepository(t)
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+
+	if _, err := captureStdout(t, func() error { return CloneRepo(bareDir, cloneDir, cloneOptions{}) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new commit on the source after the clone
+	workDir := t.TempDir()
+	runGit(t, workDir, "clone", "-q", bareDir, ".")
+	writeFile(t, filepath.Join(workDir, "new.txt"), "new\n")
+	runGit(t, workDir, "add", ".")
+	runGit(t, workDir, "commit", "-q", "-m", "third")
+	runGit(t, workDir, "push", "-q", "origin", "master")
+	head := runGit(t, bareDir, "rev-parse", "HEAD")
+
+	repo, err := helper.OpenRepositoryAt(cloneDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := captureStdout(t, func() error { return fetch(repo, nil) }); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+
+	if sha, err := repo.ResolveRef("refs/remotes/origin/master"); err != nil || sha != head {
+		t.Errorf("origin/master is at %s, %v, want %s", sha, err, head)
+	}
+
+	runGit(t, cloneDir, "fsck", "--strict")
+
+	if _, err := localRefDiscovery(bareDir, "git-receive-pack"); err == nil {
+		t.Errorf("pushing to a local repository did not fail")
+	}
+}
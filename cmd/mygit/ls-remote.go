@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git ls-remote [--heads] [--tags] [--symref] <repository> [<pattern>...]
+//
+//	ref: refs/heads/master	HEAD                   (--symref)
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd	HEAD
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd	refs/heads/master
+//	2cb58b79488a98d2721cea644875a8dd0026b115	refs/tags/v1.0
+//	a3c2e2402b99163d1d59756e5f207ae21cccba4c	refs/tags/v1.0^{}
+//
+// a pattern matches the end of the ref name at a / boundary,
+// "master" matches refs/heads/master and refs/remotes/origin/master
+func lsRemote(args []string) error {
+	heads := false
+	tags := false
+	symref := false
+	positional := []string{}
+
+	for _, arg := range args {
+		switch arg {
+		case "--heads", "-h":
+			heads = true
+		case "--tags", "-t":
+			tags = true
+		case "--symref":
+			symref = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return errors.New("usage: mygit ls-remote [--heads] [--tags] [--symref] <repository> [<pattern>...]")
+			}
+
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 {
+		return errors.New("usage: mygit ls-remote [--heads] [--tags] [--symref] <repository> [<pattern>...]")
+	}
+
+	repoUrl, err := remoteUrl(positional[0])
+
+	if err != nil {
+		return err
+	}
+
+	patterns := positional[1:]
+
+	// with protocol v2 the server leaves out the other refs itself
+	refPrefixes := []string{}
+
+	if heads {
+		refPrefixes = append(refPrefixes, "refs/heads/")
+	}
+
+	if tags {
+		refPrefixes = append(refPrefixes, "refs/tags/")
+	}
+
+	advertisement, err := refDiscovery(repoUrl, "git-upload-pack", refPrefixes)
+
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range advertisement.Refs {
+		if heads || tags {
+			if !(heads && strings.HasPrefix(ref.Name, "refs/heads/")) && !(tags && strings.HasPrefix(ref.Name, "refs/tags/")) {
+				continue
+			}
+		}
+
+		if matchesRefPattern(ref.Name, patterns) {
+			if target, ok := advertisement.Symrefs[ref.Name]; ok && symref {
+				fmt.Printf("ref: %s\t%s\n", target, ref.Name)
+			}
+
+			fmt.Printf("%s\t%s\n", ref.SHA, ref.Name)
+		}
+
+		// like git, the pattern is matched against "refs/tags/v1.0^{}" too
+		if peeled, ok := advertisement.Peeled[ref.Name]; ok && matchesRefPattern(ref.Name+"^{}", patterns) {
+			fmt.Printf("%s\t%s^{}\n", peeled, ref.Name)
+		}
+	}
+
+	return nil
+}
+
+func matchesRefPattern(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a url as it is, or the name of a remote of the current repository
+func remoteUrl(remote string) (string, error) {
+	if strings.Contains(remote, "://") || isScpLikeUrl(remote) {
+		return remote, nil
+	}
+
+	repo, err := helper.OpenRepository(".")
+
+	if err != nil {
+		return remote, nil
+	}
+
+	config, err := repo.Config()
+
+	if err != nil {
+		return "", err
+	}
+
+	if url, ok := config.Get("remote." + remote + ".url"); ok {
+		return url, nil
+	}
+
+	return remote, nil
+}
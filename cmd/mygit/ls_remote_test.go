@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+const (
+	masterSHA = "47b37f1a82bfe85f6d8df52b6258b75e4343b7fd"
+	devSHA    = "9d3c5a1e2f6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d"
+	tagSHA    = "2cb58b79488a98d2721cea644875a8dd0026b115"
+	peeledSHA = "a3c2e2402b99163d1d59756e5f207ae21cccba4c"
+)
+
+// a v0 server, it ignores the Git-Protocol header like servers that only know v0
+func newAdvertisingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repo.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		io.WriteString(w, helper.FormatPacketLine("# service=git-upload-pack\n")+"0000")
+		io.WriteString(w, helper.FormatPacketLine(masterSHA+" HEAD\x00multi_ack ofs-delta symref=HEAD:refs/heads/master agent=git/2.43.0\n"))
+		io.WriteString(w, helper.FormatPacketLine(devSHA+" refs/heads/dev\n"))
+		io.WriteString(w, helper.FormatPacketLine(masterSHA+" refs/heads/master\n"))
+		io.WriteString(w, helper.FormatPacketLine(tagSHA+" refs/tags/v1.0\n"))
+		io.WriteString(w, helper.FormatPacketLine(peeledSHA+" refs/tags/v1.0^{}\n"))
+		io.WriteString(w, "0000")
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestLsRemote(t *testing.T) {
+	server := newAdvertisingServer(t)
+	repoUrl := server.URL + "/repo.git"
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "every ref",
+			args: []string{repoUrl},
+			want: []string{
+				masterSHA + "\tHEAD",
+				devSHA + "\trefs/heads/dev",
+				masterSHA + "\trefs/heads/master",
+				tagSHA + "\trefs/tags/v1.0",
+				peeledSHA + "\trefs/tags/v1.0^{}",
+			},
+		},
+		{
+			name: "heads",
+			args: []string{"--heads", repoUrl},
+			want: []string{
+				devSHA + "\trefs/heads/dev",
+				masterSHA + "\trefs/heads/master",
+			},
+		},
+		{
+			name: "tags",
+			args: []string{"--tags", repoUrl},
+			want: []string{
+				tagSHA + "\trefs/tags/v1.0",
+				peeledSHA + "\trefs/tags/v1.0^{}",
+			},
+		},
+		{
+			name: "symref",
+			args: []string{"--symref", repoUrl, "HEAD"},
+			want: []string{
+				"ref: refs/heads/master\tHEAD",
+				masterSHA + "\tHEAD",
+			},
+		},
+		{
+			name: "pattern",
+			args: []string{repoUrl, "master"},
+			want: []string{
+				masterSHA + "\trefs/heads/master",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := captureStdout(t, func() error {
+				return lsRemote(test.args)
+			})
+
+			if err != nil {
+				t.Fatalf("lsRemote(%q) failed: %v", test.args, err)
+			}
+
+			want := strings.Join(test.want, "\n") + "\n"
+
+			if output != want {
+				t.Errorf("lsRemote(%q) printed\n%s\nwant\n%s", test.args, output, want)
+			}
+		})
+	}
+}
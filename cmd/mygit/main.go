@@ -4,7 +4,6 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/codecrafters-io/git-starter-go/helper"
@@ -19,39 +18,28 @@ func main() {
 
 	switch command := os.Args[1]; command {
 	case "init":
-		helper.InitialiseGitDirectory()
-
-	case "cat-file":
-		sha := os.Args[3]
-
-		// first 2 of sha is dir
-		// 2: is the file
-
-		fullPath := filepath.Join(".git/objects", sha[:2], sha[2:])
-
-		file, err := os.Open(fullPath)
+		_, err := helper.InitRepository(".")
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error reading file: %v\n", err)
-
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
 
-		defer file.Close()
+		fmt.Println("Initialized git directory")
+
+	case "cat-file":
+		sha := os.Args[3]
 
-		decompressedFile, err := helper.DecompressFile(file)
+		// the object may be loose (.git/objects/xx/yyyy) or in a pack,
+		// the object store strips the "<type> <size>\0" header for us
+		content, _, err := openRepository().Objects.Read(sha)
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
 
-		// fmt.Println(string(r))
-
-		res := strings.Split(string(decompressedFile), "\x00")
-
-		// for index, line := range res {
-		// 	fmt.Println(index, line)
-		// }
-		fmt.Print(res[1])
+		fmt.Print(string(content))
 
 	case "hash-object":
 		writeFlag := false
@@ -82,10 +70,7 @@ func main() {
 		fmt.Println(hashStr)
 
 		if writeFlag {
-			fullPath := filepath.Join(".git/objects", hashStr[:2])
-
-			// Create zlib writer
-			err := helper.WriteIntoPath(fullPath, hashStr[2:], blobObject)
+			_, err := openRepository().Objects.Write("blob", content)
 
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -96,25 +81,20 @@ func main() {
 
 	case "ls-tree":
 		tree_sha := os.Args[3]
-		fullPath := filepath.Join(".git/objects", tree_sha[:2], tree_sha[2:])
 
-		// Read file
-		file, err := os.Open(fullPath) // <-- the file is compressed
+		tree, objectType, err := openRepository().Objects.Read(tree_sha)
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		defer file.Close()
-
-		decompressedFile, err := helper.DecompressFile(file)
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
+		if objectType != "tree" {
+			fmt.Fprintf(os.Stderr, "fatal: not a tree object\n")
 			os.Exit(1)
 		}
 
-		res, err := helper.ParseTreeEntries(decompressedFile)
+		res, err := helper.ParseTreeEntries(tree)
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -125,16 +105,53 @@ func main() {
 			fmt.Println(entry.Name)
 		}
 
-	case "write-tree":
-		currentDir, err := os.Getwd()
+	case "add":
+		force := false
+		paths := []string{}
+
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "-f", "--force":
+				force = true
+			default:
+				paths = append(paths, arg)
+			}
+		}
+
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Nothing specified, nothing added.\n")
+			os.Exit(1)
+		}
+
+		err := openRepository().Add(paths, force)
 
 		if err != nil {
-			fmt.Println("Error getting current directory:", err)
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
 			os.Exit(1)
+		}
+
+	case "status":
+		porcelain := false
 
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--porcelain", "--porcelain=v1":
+				porcelain = true
+			default:
+				fmt.Fprintf(os.Stderr, "error: unknown option `%s'\n", arg)
+				os.Exit(1)
+			}
+		}
+
+		err := printStatus(openRepository(), porcelain)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
 		}
 
-		hash, err := helper.WriteTree(currentDir)
+	case "write-tree":
+		hash, err := openRepository().WriteCurrentTree()
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -144,11 +161,38 @@ func main() {
 		fmt.Println(hash)
 
 	case "commit-tree":
+		// commit-tree <tree> [-p <parent>]... [--author "Name <email>"] [--date <date>] -m <message>
 		treeSHA := os.Args[2]
-		parentCommitSHA := os.Args[4]
-		commitMessage := os.Args[6]
+		parentCommitSHAs := []string{}
+		commitMessages := []string{}
+		author := ""
+		date := ""
+
+		for i := 3; i+1 < len(os.Args); i += 2 {
+			switch os.Args[i] {
+			case "-p":
+				parentCommitSHAs = append(parentCommitSHAs, os.Args[i+1])
+			case "-m":
+				commitMessages = append(commitMessages, os.Args[i+1])
+			case "--author":
+				author = os.Args[i+1]
+			case "--date":
+				date = os.Args[i+1]
+			default:
+				fmt.Fprintf(os.Stderr, "usage: mygit commit-tree <tree> [-p <parent>]... [--author <author>] [--date <date>] -m <message>\n")
+				os.Exit(1)
+			}
+		}
+
+		repo := openRepository()
+		authorSignature, committerSignature, err := repo.CommitSignatures(author, date)
 
-		hash, err := helper.CommitTree(treeSHA, parentCommitSHA, commitMessage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		hash, err := repo.CommitTree(treeSHA, parentCommitSHAs, strings.Join(commitMessages, "\n\n"), authorSignature, committerSignature)
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -157,17 +201,91 @@ func main() {
 
 		fmt.Println(hash)
 
+	case "commit":
+		err := commit(openRepository(), os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+	case "branch":
+		err := branch(openRepository(), os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "switch", "checkout":
+		err := switchBranch(openRepository(), command, os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "fetch":
+		err := fetch(openRepository(), os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "push":
+		err := push(openRepository(), os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
+		}
+
+	case "ls-remote":
+		err := lsRemote(os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+			os.Exit(1)
+		}
+
 	case "clone":
-		repoUrl := os.Args[2]
-		dir := os.Args[3]
+		repoUrl, dir, options, err := parseCloneArgs(os.Args[2:])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 
 		fmt.Println("repo url", repoUrl)
 		fmt.Println("dir", dir)
 
-		CloneRepo(repoUrl, dir)
+		err = CloneRepo(repoUrl, dir, options)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command %s\n", command)
 		os.Exit(1)
 	}
 }
+
+// the repository the current directory belongs to, every command
+// except init and clone needs one
+func openRepository() *helper.Repository {
+	repo, err := helper.OpenRepository(".")
+
+	if err == nil {
+		err = enablePromisor(repo)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
+		os.Exit(1)
+	}
+
+	return repo
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// the filter-specs of git clone --filter, https://git-scm.com/docs/git-rev-list#Documentation/git-rev-list.txt---filterltfilter-specgt
+//
+//	blob:none          no blobs at all, they come when checkout needs them
+//	blob:limit=<n>     only blobs smaller than n bytes, n may end in k, m or g
+//	tree:<depth>       no trees or blobs deeper than depth, tree:0 is commits only
+func checkFilterSpec(filter string) error {
+	switch {
+	case filter == "blob:none":
+		return nil
+	case strings.HasPrefix(filter, "blob:limit="):
+		limit := strings.TrimPrefix(filter, "blob:limit=")
+
+		if unit := len(limit) - 1; unit > 0 && strings.ContainsAny(limit[unit:], "kKmMgG") {
+			limit = limit[:unit]
+		}
+
+		if _, err := strconv.ParseUint(limit, 10, 64); err == nil {
+			return nil
+		}
+	case strings.HasPrefix(filter, "tree:"):
+		if _, err := strconv.ParseUint(strings.TrimPrefix(filter, "tree:"), 10, 64); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid filter-spec '%s'", filter)
+}
+
+// what git clone --filter writes to .git/config, the extension makes
+// older gits refuse the repository instead of failing on missing objects
+//
+//	[core]
+//		repositoryformatversion = 1
+//	[remote "origin"]
+//		promisor = true
+//		partialclonefilter = blob:none
+//	[extensions]
+//		partialclone = origin
+func writePromisorConfig(repo *helper.Repository, remote string, filter string) error {
+	settings := [][2]string{
+		{"core.repositoryformatversion", "1"},
+		{"remote." + remote + ".promisor", "true"},
+		{"remote." + remote + ".partialclonefilter", filter},
+		{"extensions.partialclone", remote},
+	}
+
+	for _, setting := range settings {
+		if err := repo.SetConfig(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the promisor remote of a partial clone, empty otherwise
+func promisorRemote(repo *helper.Repository) (string, error) {
+	config, err := repo.Config()
+
+	if err != nil {
+		return "", err
+	}
+
+	remote, _ := config.Get("extensions.partialclone")
+
+	return remote, nil
+}
+
+// in a partial clone objects that are not here are fetched from the
+// promisor remote the first time they are read
+func enablePromisor(repo *helper.Repository) error {
+	remote, err := promisorRemote(repo)
+
+	if err != nil || remote == "" {
+		return err
+	}
+
+	config, err := repo.Config()
+
+	if err != nil {
+		return err
+	}
+
+	repoUrl, ok := config.Get("remote." + remote + ".url")
+
+	if !ok {
+		return fmt.Errorf("promisor remote '%s' has no url", remote)
+	}
+
+	repo.Objects = helper.NewPromisorObjectStore(repo.Objects, func(objectNames []string) error {
+		return fetchPromisedObjects(repo, repoUrl, objectNames)
+	})
+
+	return nil
+}
+
+// the objects by name, without filter and without haves: the server would
+// leave out whatever our commits reach, which is exactly what is missing.
+// the server has to allow wants that are not ref tips for this,
+// uploadpack.allowAnySHA1InWant or the v2 default of allowing reachable ones
+func fetchPromisedObjects(repo *helper.Repository, repoUrl string, objectNames []string) error {
+	advertisement, err := refDiscovery(repoUrl, "git-upload-pack", []string{"HEAD"})
+
+	if err != nil {
+		return err
+	}
+
+	request := packRequest{wants: objectNames, capabilities: []string{"side-band-64k", "agent=mygit/0.1"}, noHaves: true}
+	response, err := fetchPack(repo, repoUrl, advertisement, request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Close()
+
+	// the command that needed the object has its own output
+	packName, err := processPacketFile(repo, response.Pack, io.Discard, 0)
+
+	if err != nil {
+		return fmt.Errorf("error while processing pack file: %w", err)
+	}
+
+	return repo.MarkPromisorPack(packName)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// the tests use git itself to make repositories and to stand in for the server
+func requireGit(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Paul Kuruvilla")
+	t.Setenv("GIT_AUTHOR_EMAIL", "rohitpaulk@gmail.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Paul Kuruvilla")
+	t.Setenv("GIT_COMMITTER_EMAIL", "rohitpaulk@gmail.com")
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a bare repository with two commits on master: the first has old.txt,
+// the second removes it, so a partial clone of master never needs its blob
+func newSourceRepository(t *testing.T) (bareDir string, oldBlob string) {
+	t.Helper()
+
+	workDir := t.TempDir()
+	runGit(t, workDir, "init", "-q", "-b", "master")
+	writeFile(t, filepath.Join(workDir, "old.txt"), "gone in the second commit\n")
+	writeFile(t, filepath.Join(workDir, "README.md"), "hello\n")
+	runGit(t, workDir, "add", ".")
+	runGit(t, workDir, "commit", "-q", "-m", "first")
+	oldBlob = runGit(t, workDir, "rev-parse", "HEAD:old.txt")
+
+	runGit(t, workDir, "rm", "-q", "old.txt")
+	writeFile(t, filepath.Join(workDir, "src", "main.go"), "package main\n")
+	runGit(t, workDir, "add", ".")
+	runGit(t, workDir, "commit", "-q", "-m", "second")
+
+	bareDir = filepath.Join(t.TempDir(), "repo.git")
+	runGit(t, workDir, "clone", "-q", "--bare", workDir, bareDir)
+	runGit(t, bareDir, "config", "uploadpack.allowFilter", "true")
+	runGit(t, bareDir, "config", "uploadpack.allowAnySHA1InWant", "true")
+
+	return bareDir, oldBlob
+}
+
+// smart http in front of git upload-pack --stateless-rpc, the way git
+// http-backend runs it. requests keeps the body of every POST
+type uploadPackServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []string
+}
+
+func newUploadPackServer(t *testing.T, bareDir string) *uploadPackServer {
+	t.Helper()
+
+	server := &uploadPackServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gitProtocol := r.Header.Get("Git-Protocol")
+		args := []string{"upload-pack", "--stateless-rpc"}
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repo.git/info/refs" && r.URL.Query().Get("service") == "git-upload-pack":
+			args = append(args, "--advertise-refs")
+
+			// only v0 starts with the service line
+			if !strings.Contains(gitProtocol, "version=2") {
+				io.WriteString(w, helper.FormatPacketLine("# service=git-upload-pack\n")+"0000")
+			}
+		case r.Method == "POST" && r.URL.Path == "/repo.git/git-upload-pack":
+			body, _ := io.ReadAll(r.Body)
+
+			server.mutex.Lock()
+			server.requests = append(server.requests, string(body))
+			server.mutex.Unlock()
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		cmd := exec.Command("git", append(args, bareDir)...)
+		cmd.Env = append(os.Environ(), "GIT_PROTOCOL="+gitProtocol)
+		cmd.Stdin = r.Body
+		cmd.Stdout = w
+
+		if err := cmd.Run(); err != nil {
+			t.Errorf("git upload-pack: %v", err)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *uploadPackServer) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.requests)
+}
+
+func TestPartialClone(t *testing.T) {
+	requireGit(t)
+
+	bareDir, oldBlob := newSourceRepository(t)
+	server := newUploadPackServer(t, bareDir)
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+
+	_, err := captureStdout(t, func() error {
+		return CloneRepo(server.URL+"/repo.git", cloneDir, cloneOptions{filter: "blob:none"})
+	})
+
+	if err != nil {
+		t.Fatalf("clone --filter=blob:none failed: %v", err)
+	}
+
+	filtered := false
+
+	for _, request := range server.requests {
+		filtered = filtered || strings.Contains(request, "filter blob:none\n")
+	}
+
+	if !filtered {
+		t.Errorf("no request asked for filter blob:none:\n%q", server.requests)
+	}
+
+	// the blobs of the checkout are fetched when it needs them
+	if content, err := os.ReadFile(filepath.Join(cloneDir, "src", "main.go")); err != nil || string(content) != "package main\n" {
+		t.Errorf("src/main.go = %q, %v", content, err)
+	}
+
+	repo, err := helper.OpenRepository(cloneDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := repo.Config()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"remote.origin.promisor":           "true",
+		"remote.origin.partialclonefilter": "blob:none",
+		"extensions.partialclone":          "origin",
+		"core.repositoryformatversion":     "1",
+	} {
+		if value, _ := config.Get(name); value != want {
+			t.Errorf("%s = %q, want %q", name, value, want)
+		}
+	}
+
+	if repo.Objects.Has(oldBlob) {
+		t.Fatalf("blob %s of the first commit was cloned, the filter was not applied", oldBlob)
+	}
+
+	if err := enablePromisor(repo); err != nil {
+		t.Fatal(err)
+	}
+
+	requestsBefore := server.requestCount()
+	content, objectType, err := repo.Objects.Read(oldBlob)
+
+	if err != nil {
+		t.Fatalf("reading the missing blob failed: %v", err)
+	}
+
+	if objectType != "blob" || string(content) != "gone in the second commit\n" {
+		t.Errorf("missing blob read as %s %q", objectType, content)
+	}
+
+	if server.requestCount() == requestsBefore {
+		t.Error("reading the missing blob did not fetch it from the promisor remote")
+	}
+
+	if !repo.Objects.Has(oldBlob) {
+		t.Error("the fetched blob was not kept")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// protocol v2, https://git-scm.com/docs/protocol-v2
+// every request is one command to git-upload-pack with the header
+// Git-Protocol: version=2, the refs and the pack are asked for separately
+const gitProtocolV2 = "version=2"
+
+// the arguments of every v2 fetch, unlike v0 there is nothing to negotiate
+var fetchArgumentsV2 = []string{"thin-pack", "ofs-delta", "include-tag"}
+
+// the capabilities sent with a command, the server must know them
+func commandCapabilities(advertisement *helper.Advertisement) []string {
+	capabilities := []string{}
+
+	if advertisement.HasCapability("agent") {
+		capabilities = append(capabilities, "agent=mygit/0.1")
+	}
+
+	if advertisement.HasCapability("object-format") {
+		capabilities = append(capabilities, "object-format="+advertisement.ObjectFormat)
+	}
+
+	return capabilities
+}
+
+// the refs of a v2 server, only the ones starting with a prefix
+// when there are prefixes
+//
+//	C: command=ls-refs             S: 47b37f... HEAD symref-target:refs/heads/master
+//	C: 0001                        S: 47b37f... refs/heads/master
+//	C: peel                        S: 2cb58b... refs/tags/v1.0 peeled:a3c2e2...
+//	C: symrefs                     S: 0000
+//	C: ref-prefix refs/heads/
+//	C: 0000
+func lsRefs(repoUrl string, advertisement *helper.Advertisement, refPrefixes []string) error {
+	arguments := []string{"peel", "symrefs"}
+
+	// the default branch of an empty repository
+	if advertisement.HasCommandFeature("ls-refs", "unborn") {
+		arguments = append(arguments, "unborn")
+	}
+
+	for _, prefix := range refPrefixes {
+		arguments = append(arguments, "ref-prefix "+prefix)
+	}
+
+	request := helper.FormatCommandRequest("ls-refs", commandCapabilities(advertisement), arguments)
+	response, err := serviceRequest(repoUrl, "git-upload-pack", []byte(request), gitProtocolV2)
+
+	if err != nil {
+		return err
+	}
+
+	return advertisement.AddLsRefs(helper.ParsePacketLines(response))
+}
+
+// the v2 fetch command, the rounds of negotiate in one command: without
+// done only the acknowledgments come back, unless the server is ready and
+// sends the pack right away
+//
+//	C: command=fetch               S: acknowledgments
+//	C: 0001                        S: ACK <sha>
+//	C: thin-pack                   S: ready
+//	C: want <sha>                  S: 0001
+//	C: have <sha>                  S: packfile
+//	C: done                        S: \x01PACK...
+//	C: 0000                        S: 0000
+func fetchPackV2(repoUrl string, advertisement *helper.Advertisement, wants []string, walker *helper.CommitWalker, requestLines []string) (*helper.FetchResponse, error) {
+	common := []string{}
+
+	// git gives up after this many haves without a new ACK
+	const maxInVain = 256
+	inVain := 0
+
+	for {
+		haves := []string{}
+
+		for len(haves) < 32 && inVain < maxInVain {
+			sha, err := walker.Next()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if sha == "" {
+				break
+			}
+
+			haves = append(haves, sha)
+		}
+
+		done := len(haves) == 0
+		arguments := append([]string{}, fetchArgumentsV2...)
+
+		for _, sha := range wants {
+			arguments = append(arguments, "want "+sha)
+		}
+
+		arguments = append(arguments, requestLines...)
+
+		for _, sha := range append(append([]string{}, common...), haves...) {
+			arguments = append(arguments, "have "+sha)
+		}
+
+		if done {
+			arguments = append(arguments, "done")
+		}
+
+		request := helper.FormatCommandRequest("fetch", commandCapabilities(advertisement), arguments)
+		body, err := serviceStream(repoUrl, "git-upload-pack", []byte(request), gitProtocolV2)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// the pack, when it comes, is left in the response
+		result, err := helper.ParseFetchResponse(body, os.Stderr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Pack != nil {
+			return result, nil
+		}
+
+		if done || result.Ready {
+			return nil, fmt.Errorf("invalid fetch response: no packfile section")
+		}
+
+		inVain += len(haves)
+
+		for _, sha := range result.Acknowledgments {
+			if !helper.ArrayContains(common, sha) {
+				common = append(common, sha)
+				walker.Hide(sha)
+				inVain = 0
+			}
+		}
+	}
+}
+
+// the ref prefixes ls-refs is asked for so the server leaves out the rest,
+// a refspec source up to its *
+func refspecPrefixes(refspecs []string) []string {
+	prefixes := []string{}
+
+	for _, refspec := range refspecs {
+		source, _, _ := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+		prefix, _, _ := strings.Cut(source, "*")
+
+		if !helper.ArrayContains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestRefspecPrefixes(t *testing.T) {
+	got := refspecPrefixes([]string{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1.0:refs/tags/v1.0", "refs/heads/*:refs/other/*"})
+	want := []string{"refs/heads/", "refs/tags/v1.0"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("refspecPrefixes = %q, want %q", got, want)
+	}
+}
+
+func TestLsRefs(t *testing.T) {
+	master := strings.Repeat("a", 40)
+	var request string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/git-upload-pack" || r.Header.Get("Git-Protocol") != "version=2" {
+			http.Error(w, "not a v2 request", http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		request = string(body)
+
+		io.WriteString(w, helper.FormatPacketLine(master+" HEAD symref-target:refs/heads/master\n"))
+		io.WriteString(w, helper.FormatPacketLine(master+" refs/heads/master\n"))
+		io.WriteString(w, "0000")
+	}))
+
+	defer server.Close()
+
+	advertisement, err := helper.ParseAdvertisement([]string{"version 2\n", "agent=git/2.43.0\n", "ls-refs=unborn\n", "object-format=sha1\n"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lsRefs(server.URL, advertisement, []string{"HEAD", "refs/heads/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRequest := helper.FormatCommandRequest("ls-refs", []string{"agent=mygit/0.1", "object-format=sha1"},
+		[]string{"peel", "symrefs", "unborn", "ref-prefix HEAD", "ref-prefix refs/heads/"})
+
+	if request != wantRequest {
+		t.Errorf("request %q, want %q", request, wantRequest)
+	}
+
+	if sha, ok := advertisement.Ref("refs/heads/master"); !ok || sha != master || advertisement.Symrefs["HEAD"] != "refs/heads/master" {
+		t.Errorf("refs %+v, symrefs %v", advertisement.Refs, advertisement.Symrefs)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// report-status makes receive-pack answer with one ok/ng line per ref,
+// with side-band-64k that answer comes on band 1 and the output of the
+// remote hooks on band 2
+var pushCapabilities = []string{"report-status", "side-band-64k", "agent=mygit/0.1"}
+
+// one "<old> <new> <ref>" command for receive-pack, newSHA is ZeroSHA for
+// a delete. status is why the update is not sent or what the remote said
+type pushUpdate struct {
+	source      string
+	destination string
+	oldSHA      string
+	newSHA      string
+	force       bool
+	forced      bool
+	status      string
+}
+
+// git push [--force] [<remote> [<refspec>...]]
+//
+//	C: 0000000000000000000000000000000000000000 <new> refs/heads/feature\0report-status
+//	C: 0000
+//	C: PACK...
+//	S: unpack ok
+//	S: ok refs/heads/feature
+//	S: 0000
+//
+// the steps:
+//  1. ref discovery on git-receive-pack, where the remote refs are now
+//  2. every <src>:<dst> refspec becomes a command, non fast-forwards are
+//     refused here unless --force is given or the refspec starts with +
+//  3. the commands and a pack of what the remote is missing in one request
+//  4. report-status tells per ref whether the remote took it
+func push(repo *helper.Repository, args []string) error {
+	usage := errors.New("usage: mygit push [--force] [<remote> [<refspec>...]]")
+	force := false
+	positional := []string{}
+
+	for _, arg := range args {
+		switch {
+		case arg == "--force" || arg == "-f":
+			force = true
+		case strings.HasPrefix(arg, "-"):
+			return usage
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	config, err := repo.Config()
+
+	if err != nil {
+		return err
+	}
+
+	currentBranch, err := repo.CurrentBranch()
+
+	if err != nil {
+		return err
+	}
+
+	remote, ok := config.Get("branch." + currentBranch + ".remote")
+
+	if !ok {
+		remote = "origin"
+	}
+
+	if len(positional) > 0 {
+		remote = positional[0]
+	}
+
+	refspecs := positional[min(len(positional), 1):]
+
+	// without a refspec the current branch goes to the branch of the same name
+	if len(refspecs) == 0 {
+		if currentBranch == "" {
+			return errors.New("you are not currently on a branch")
+		}
+
+		refspecs = []string{"refs/heads/" + currentBranch}
+	}
+
+	repoUrl, ok := config.Get("remote." + remote + ".url")
+
+	if !ok {
+		if !strings.Contains(remote, "://") && !isScpLikeUrl(remote) {
+			return fmt.Errorf("'%s' does not appear to be a git repository", remote)
+		}
+
+		repoUrl = remote
+	}
+
+	advertisement, err := refDiscovery(repoUrl, "git-receive-pack", nil)
+
+	if err != nil {
+		return err
+	}
+
+	if !advertisement.HasCapability("report-status") {
+		return errors.New("the remote does not support report-status")
+	}
+
+	updates := []*pushUpdate{}
+
+	for _, refspec := range refspecs {
+		update, err := parsePushRefspec(repo, refspec, advertisement)
+
+		if err != nil {
+			return err
+		}
+
+		update.force = update.force || force
+		update.status = checkPushUpdate(repo, update, advertisement)
+		updates = append(updates, update)
+	}
+
+	commands := []*pushUpdate{}
+
+	for _, update := range updates {
+		if update.status == "" {
+			commands = append(commands, update)
+		}
+	}
+
+	if len(commands) > 0 {
+		err := sendPushCommands(repo, repoUrl, commands, advertisement)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "To %s\n", repoUrl)
+
+	failed := false
+	upToDate := true
+
+	for _, update := range updates {
+		failed = failed || (update.status != "" && update.status != "up to date")
+		upToDate = upToDate && update.status == "up to date"
+
+		printPushUpdate(update)
+
+		if update.status == "" {
+			if err := updateTrackingRef(repo, config, remote, update); err != nil {
+				return err
+			}
+		}
+	}
+
+	if upToDate {
+		fmt.Fprintln(os.Stderr, "Everything up-to-date")
+	}
+
+	if failed {
+		return fmt.Errorf("failed to push some refs to '%s'", repoUrl)
+	}
+
+	return nil
+}
+
+// [+]<src>[:<dst>], the source is a local ref or a sha, an empty source
+// deletes the destination. without a destination the ref keeps its name
+func parsePushRefspec(repo *helper.Repository, refspec string, advertisement *helper.Advertisement) (*pushUpdate, error) {
+	update := &pushUpdate{force: strings.HasPrefix(refspec, "+"), newSHA: helper.ZeroSHA}
+	source, destination, _ := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+	sourceRef := ""
+
+	if source != "" {
+		if helper.IsObjectName(source) && repo.Objects.Has(source) {
+			update.newSHA = source
+		} else {
+			name, sha, err := repo.ExpandRef(source)
+
+			if err != nil {
+				return nil, fmt.Errorf("src refspec %s does not match any", source)
+			}
+
+			// git push origin HEAD pushes the current branch
+			if name == "HEAD" {
+				if branch, err := repo.CurrentBranch(); err == nil && branch != "" {
+					name = "refs/heads/" + branch
+				}
+			}
+
+			sourceRef = name
+			update.newSHA = sha
+		}
+
+		update.source = source
+	}
+
+	if destination == "" {
+		if sourceRef == "" || sourceRef == "HEAD" {
+			return nil, fmt.Errorf("cannot push %s without a destination, use <src>:<dst>", refspec)
+		}
+
+		destination = sourceRef
+	}
+
+	if !strings.HasPrefix(destination, "refs/") {
+		qualified, err := qualifyPushDestination(repo, destination, sourceRef, update.newSHA, advertisement)
+
+		if err != nil {
+			return nil, err
+		}
+
+		destination = qualified
+	}
+
+	update.destination = destination
+	update.oldSHA = helper.ZeroSHA
+
+	if sha, ok := advertisement.Ref(destination); ok {
+		update.oldSHA = sha
+	}
+
+	return update, nil
+}
+
+// a short destination is the remote branch or tag of that name, or a new
+// one of the same kind as the source
+func qualifyPushDestination(repo *helper.Repository, destination string, sourceRef string, sourceSHA string, advertisement *helper.Advertisement) (string, error) {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if _, ok := advertisement.Ref(prefix + destination); ok {
+			return prefix + destination, nil
+		}
+	}
+
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if strings.HasPrefix(sourceRef, prefix) {
+			return prefix + destination, nil
+		}
+	}
+
+	if sourceSHA != helper.ZeroSHA {
+		if _, objectType, err := repo.Objects.Read(sourceSHA); err == nil && objectType == "commit" {
+			return "refs/heads/" + destination, nil
+		} else if objectType == "tag" {
+			return "refs/tags/" + destination, nil
+		}
+	}
+
+	return "", fmt.Errorf("the destination you provided is not a full refname (i.e., starting with \"refs/\")")
+}
+
+// why an update is not sent, like git push the remote ref must be an
+// ancestor of the new commit. a remote commit we do not have means someone
+// else pushed first, and tags are never moved without force
+func checkPushUpdate(repo *helper.Repository, update *pushUpdate, advertisement *helper.Advertisement) string {
+	if update.oldSHA == update.newSHA {
+		if update.newSHA == helper.ZeroSHA {
+			return "remote ref does not exist"
+		}
+
+		return "up to date"
+	}
+
+	if update.newSHA == helper.ZeroSHA {
+		if !advertisement.HasCapability("delete-refs") {
+			return "remote does not support deleting refs"
+		}
+
+		return ""
+	}
+
+	if update.oldSHA == helper.ZeroSHA {
+		return ""
+	}
+
+	fastForward := false
+
+	if repo.Objects.Has(update.oldSHA) && !strings.HasPrefix(update.destination, "refs/tags/") {
+		// tags and other refs that do not point at commits are never fast-forwards
+		fastForward, _ = repo.IsAncestor(update.oldSHA, update.newSHA)
+	}
+
+	switch {
+	case fastForward:
+		return ""
+	case update.force:
+		update.forced = true
+		return ""
+	case !repo.Objects.Has(update.oldSHA):
+		return "fetch first"
+	case strings.HasPrefix(update.destination, "refs/tags/"):
+		return "already exists"
+	}
+
+	return "non-fast-forward"
+}
+
+// the commands, a flush and the pack in one POST to git-receive-pack.
+// a push of only deletes has no pack. the report-status answer sets
+// the status of the refs the remote refused or left out
+func sendPushCommands(repo *helper.Repository, repoUrl string, commands []*pushUpdate, advertisement *helper.Advertisement) error {
+	capabilities := supportedCapabilities(advertisement, pushCapabilities)
+
+	requestBody := []byte{}
+	tips := []string{}
+
+	for i, command := range commands {
+		line := fmt.Sprintf("%s %s %s", command.oldSHA, command.newSHA, command.destination)
+
+		if i == 0 {
+			line += "\x00" + strings.Join(capabilities, " ")
+		}
+
+		requestBody = append(requestBody, helper.FormatPacketLine(line+"\n")...)
+
+		if command.newSHA != helper.ZeroSHA {
+			tips = append(tips, command.newSHA)
+		}
+	}
+
+	requestBody = append(requestBody, "0000"...)
+
+	if len(tips) > 0 {
+		known := []string{}
+
+		for _, ref := range advertisement.Refs {
+			known = append(known, ref.SHA)
+		}
+
+		objectNames, err := repo.ReachableObjects(tips, known)
+
+		if err != nil {
+			return err
+		}
+
+		objects := make([]helper.PackObject, 0, len(objectNames))
+
+		for _, sha := range objectNames {
+			content, objectType, err := repo.Objects.Read(sha)
+
+			if err != nil {
+				return err
+			}
+
+			objects = append(objects, helper.PackObject{Type: objectType, Content: content})
+		}
+
+		packFile, err := helper.BuildPack(objects)
+
+		if err != nil {
+			return err
+		}
+
+		requestBody = append(requestBody, packFile...)
+	}
+
+	response, err := serviceRequest(repoUrl, "git-receive-pack", requestBody, "")
+
+	if err != nil {
+		return err
+	}
+
+	if helper.ArrayContains(capabilities, "side-band-64k") {
+		response, err = helper.DemuxSideBand(response, os.Stderr)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// unpack ok
+	// ok refs/heads/master
+	// ng refs/heads/feature <reason>
+	lines := helper.ParsePacketLines(response)
+
+	if len(lines) == 0 {
+		return errors.New("no report-status from the remote")
+	}
+
+	if unpack := strings.TrimSpace(lines[0]); unpack != "unpack ok" {
+		return fmt.Errorf("remote unpack failed: %s", strings.TrimPrefix(unpack, "unpack "))
+	}
+
+	reported := map[string]bool{}
+
+	for _, line := range lines[1:] {
+		status, rest, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		refName, reason, _ := strings.Cut(rest, " ")
+
+		if status != "ok" && status != "ng" {
+			continue
+		}
+
+		reported[refName] = true
+
+		if status != "ng" {
+			continue
+		}
+
+		for _, command := range commands {
+			if command.destination == refName {
+				command.status = "remote rejected: " + reason
+			}
+		}
+	}
+
+	// a ref the remote says nothing about may or may not have been
+	// updated, it is not taken as pushed
+	for _, command := range commands {
+		if !reported[command.destination] {
+			command.status = "remote failure: remote did not report status"
+		}
+	}
+
+	return nil
+}
+
+// one line per ref like git push prints them
+//
+//	To http://example.com/repo.git
+//	 * [new branch]      feature -> feature
+//	   6e0d425..18b8bf0  master -> master
+//	 + 6e0d425...904b3d3 rewritten -> rewritten (forced update)
+//	 - [deleted]         old
+//	 ! [rejected]        stale -> stale (non-fast-forward)
+//	 ! [remote rejected] locked -> locked (pre-receive hook declined)
+//	 ! [remote failure]  lost -> lost (remote did not report status)
+func printPushUpdate(update *pushUpdate) {
+	source := shortRefName(update.source)
+	destination := shortRefName(update.destination)
+
+	switch reason, remote := strings.CutPrefix(update.status, "remote rejected: "); {
+	case update.status == "up to date":
+		return
+	case remote:
+		fmt.Fprintf(os.Stderr, " ! %-17s %s -> %s (%s)\n", "[remote rejected]", source, destination, reason)
+	case strings.HasPrefix(update.status, "remote failure: "):
+		fmt.Fprintf(os.Stderr, " ! %-17s %s -> %s (%s)\n", "[remote failure]", source, destination, strings.TrimPrefix(update.status, "remote failure: "))
+	case update.status != "":
+		fmt.Fprintf(os.Stderr, " ! %-17s %s -> %s (%s)\n", "[rejected]", source, destination, update.status)
+	case update.newSHA == helper.ZeroSHA:
+		fmt.Fprintf(os.Stderr, " - %-17s %s\n", "[deleted]", destination)
+	case update.oldSHA == helper.ZeroSHA:
+		kind := "[new reference]"
+
+		if strings.HasPrefix(update.destination, "refs/heads/") {
+			kind = "[new branch]"
+		} else if strings.HasPrefix(update.destination, "refs/tags/") {
+			kind = "[new tag]"
+		}
+
+		fmt.Fprintf(os.Stderr, " * %-17s %s -> %s\n", kind, source, destination)
+	case update.forced:
+		fmt.Fprintf(os.Stderr, " + %-17s %s -> %s (forced update)\n", update.oldSHA[:7]+"..."+update.newSHA[:7], source, destination)
+	default:
+		fmt.Fprintf(os.Stderr, "   %-17s %s -> %s\n", update.oldSHA[:7]+".."+update.newSHA[:7], source, destination)
+	}
+}
+
+// the remote-tracking ref follows what was pushed, so refs/remotes/origin/master
+// is where the remote master is now without fetching again
+func updateTrackingRef(repo *helper.Repository, config *helper.Config, remote string, update *pushUpdate) error {
+	for _, refspec := range config.GetAll("remote." + remote + ".fetch") {
+		localName, _, ok := mapRefspec(refspec, update.destination)
+
+		if !ok {
+			continue
+		}
+
+		if update.newSHA == helper.ZeroSHA {
+			_, err := repo.ResolveRef(localName)
+
+			if errors.Is(err, helper.ErrRefNotFound) {
+				return nil
+			}
+
+			return repo.DeleteRef(localName, "")
+		}
+
+		return repo.UpdateRef(localName, update.newSHA, "")
+	}
+
+	return nil
+}
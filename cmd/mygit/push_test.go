@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestSendPushCommands(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	advertisement, err := helper.ParseAdvertisement([]string{
+		strings.Repeat("a", 40) + " refs/heads/ok\x00report-status delete-refs\n",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		io.WriteString(w, helper.FormatPacketLine("unpack ok\n")+
+			helper.FormatPacketLine("ok refs/heads/ok\n")+
+			helper.FormatPacketLine("ng refs/heads/locked pre-receive hook declined\n")+
+			"0000")
+	}))
+
+	defer server.Close()
+
+	// deletes only, there is no pack to send
+	commands := []*pushUpdate{}
+
+	for _, name := range []string{"ok", "locked", "lost"} {
+		commands = append(commands, &pushUpdate{source: "refs/heads/" + name, destination: "refs/heads/" + name, oldSHA: strings.Repeat("a", 40), newSHA: helper.ZeroSHA})
+	}
+
+	if err := sendPushCommands(repo, server.URL, commands, advertisement); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"", "remote rejected: pre-receive hook declined", "remote failure: remote did not report status"}
+
+	for i, command := range commands {
+		if command.status != want[i] {
+			t.Errorf("%s: status %q, want %q", command.destination, command.status, want[i])
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// how much history a shallow clone asks for, the zero value asks for all of it
+//
+//	--depth <n>              deepen <n>           the tips and n-1 commits below them
+//	--shallow-since <date>   deepen-since <unix>  the commits made after date
+//	--shallow-exclude <ref>  deepen-not <ref>     not what is reachable from ref
+type shallowOptions struct {
+	depth   int
+	since   time.Time
+	exclude []string
+}
+
+// --depth 1, --depth=1 and the others the same way. returns how many
+// arguments were used, 0 when arg is not a shallow option
+func (o *shallowOptions) parseArg(args []string, i int) (int, error) {
+	name, value, hasValue := strings.Cut(args[i], "=")
+	used := 1
+
+	if name != "--depth" && name != "--shallow-since" && name != "--shallow-exclude" {
+		return 0, nil
+	}
+
+	if !hasValue {
+		if i+1 >= len(args) {
+			return 0, fmt.Errorf("option '%s' requires a value", name)
+		}
+
+		value = args[i+1]
+		used = 2
+	}
+
+	switch name {
+	case "--depth":
+		depth, err := strconv.Atoi(value)
+
+		if err != nil || depth <= 0 {
+			return 0, fmt.Errorf("depth %s is not a positive number", value)
+		}
+
+		o.depth = depth
+	case "--shallow-since":
+		since, err := helper.ParseDate(value)
+
+		if err != nil {
+			return 0, err
+		}
+
+		o.since = since
+	case "--shallow-exclude":
+		o.exclude = append(o.exclude, value)
+	}
+
+	return used, nil
+}
+
+func (o shallowOptions) deepens() bool {
+	return o.depth > 0 || !o.since.IsZero() || len(o.exclude) > 0
+}
+
+// the lines that go after the wants, in v0 and v2 alike: the shallow
+// commits we already have, so the server does not send deltas against
+// their missing parents, then the deepen requests. for v0 also the
+// capabilities they need, an error when the server lacks one
+func shallowRequest(repo *helper.Repository, advertisement *helper.Advertisement, options shallowOptions) ([]string, []string, error) {
+	lines := []string{}
+	capabilities := []string{}
+
+	shallowCommits, err := repo.ShallowCommits()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, sha := range shallowCommits {
+		lines = append(lines, "shallow "+sha)
+	}
+
+	if options.depth > 0 {
+		lines = append(lines, fmt.Sprintf("deepen %d", options.depth))
+	}
+
+	if !options.since.IsZero() {
+		lines = append(lines, fmt.Sprintf("deepen-since %d", options.since.Unix()))
+		capabilities = append(capabilities, "deepen-since")
+	}
+
+	for _, ref := range options.exclude {
+		lines = append(lines, "deepen-not "+ref)
+	}
+
+	if len(options.exclude) > 0 {
+		capabilities = append(capabilities, "deepen-not")
+	}
+
+	if len(lines) == 0 {
+		return nil, nil, nil
+	}
+
+	if advertisement.Version == 2 {
+		if !advertisement.HasCommandFeature("fetch", "shallow") {
+			return nil, nil, errors.New("the server does not support shallow clients")
+		}
+
+		return lines, nil, nil
+	}
+
+	capabilities = append([]string{"shallow"}, capabilities...)
+
+	for _, capability := range capabilities {
+		if !advertisement.HasCapability(capability) {
+			return nil, nil, fmt.Errorf("the server does not support %s", capability)
+		}
+	}
+
+	return lines, capabilities, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestShallowOptionsParseArg(t *testing.T) {
+	tests := []struct {
+		args []string
+		used int
+		want shallowOptions
+		err  string
+	}{
+		{[]string{"--depth", "3"}, 2, shallowOptions{depth: 3}, ""},
+		{[]string{"--depth=1"}, 1, shallowOptions{depth: 1}, ""},
+		{[]string{"--shallow-since=1587572148 +0000"}, 1, shallowOptions{since: time.Unix(1587572148, 0)}, ""},
+		{[]string{"--shallow-exclude", "v1.0"}, 2, shallowOptions{exclude: []string{"v1.0"}}, ""},
+		{[]string{"--bare"}, 0, shallowOptions{}, ""},
+		{[]string{"--depth"}, 0, shallowOptions{}, "option '--depth' requires a value"},
+		{[]string{"--depth=0"}, 0, shallowOptions{}, "depth 0 is not a positive number"},
+		{[]string{"--depth=x"}, 0, shallowOptions{}, "depth x is not a positive number"},
+		{[]string{"--shallow-since=yesterday"}, 0, shallowOptions{}, "unsupported date format"},
+	}
+
+	for _, test := range tests {
+		options := shallowOptions{}
+		used, err := options.parseArg(test.args, 0)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseArg(%q): got %v, want %q", test.args, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || used != test.used || options.depth != test.want.depth || !options.since.Equal(test.want.since) || !reflect.DeepEqual(options.exclude, test.want.exclude) {
+			t.Errorf("parseArg(%q) = %d, %+v, %v, want %d, %+v", test.args, used, options, err, test.used, test.want)
+		}
+
+		if options.deepens() != (test.used > 0) {
+			t.Errorf("parseArg(%q): deepens() = %v", test.args, options.deepens())
+		}
+	}
+}
+
+func TestShallowRequest(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boundary := strings.Repeat("a", 40)
+
+	if err := repo.UpdateShallow([]string{boundary}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v0, _ := helper.ParseAdvertisement([]string{strings.Repeat("b", 40) + " HEAD\x00shallow deepen-since ofs-delta\n"})
+	v0WithoutShallow, _ := helper.ParseAdvertisement([]string{strings.Repeat("b", 40) + " HEAD\x00ofs-delta\n"})
+	v2, _ := helper.ParseAdvertisement([]string{"version 2\n", "fetch=shallow filter\n"})
+	v2WithoutShallow, _ := helper.ParseAdvertisement([]string{"version 2\n", "fetch=filter\n"})
+	since := time.Unix(1587572148, 0)
+
+	tests := []struct {
+		name          string
+		advertisement *helper.Advertisement
+		options       shallowOptions
+		lines         []string
+		capabilities  []string
+		err           string
+	}{
+		{"v0 depth", v0, shallowOptions{depth: 1}, []string{"shallow " + boundary, "deepen 1"}, []string{"shallow"}, ""},
+		{"v0 since", v0, shallowOptions{since: since}, []string{"shallow " + boundary, "deepen-since 1587572148"}, []string{"shallow", "deepen-since"}, ""},
+		{"v0 only the boundary", v0, shallowOptions{}, []string{"shallow " + boundary}, []string{"shallow"}, ""},
+		{"v0 without deepen-not", v0, shallowOptions{exclude: []string{"v1.0"}}, nil, nil, "the server does not support deepen-not"},
+		{"v0 without shallow", v0WithoutShallow, shallowOptions{depth: 1}, nil, nil, "the server does not support shallow"},
+		{"v2", v2, shallowOptions{depth: 2, exclude: []string{"v1.0", "v2.0"}}, []string{"shallow " + boundary, "deepen 2", "deepen-not v1.0", "deepen-not v2.0"}, nil, ""},
+		{"v2 without shallow", v2WithoutShallow, shallowOptions{depth: 1}, nil, nil, "the server does not support shallow clients"},
+	}
+
+	for _, test := range tests {
+		lines, capabilities, err := shallowRequest(repo, test.advertisement, test.options)
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || !reflect.DeepEqual(lines, test.lines) || !reflect.DeepEqual(capabilities, test.capabilities) {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q", test.name, lines, capabilities, err, test.lines, test.capabilities)
+		}
+	}
+
+	// a complete repository that does not deepen sends nothing
+	complete, _ := helper.InitRepository(t.TempDir())
+
+	if lines, capabilities, err := shallowRequest(complete, v0WithoutShallow, shallowOptions{}); lines != nil || capabilities != nil || err != nil {
+		t.Errorf("complete repository: got %q, %q, %v", lines, capabilities, err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// where a service is run over ssh, host may have the user in it
+type sshAddress struct {
+	host string
+	port string
+	path string
+}
+
+// the two ways of writing an ssh url
+//
+//	ssh://[user@]host[:port]/path   path is absolute, /~user/path is in a home directory
+//	[user@]host:path                scp-like, path is relative to the home directory
+//
+// a colon after a slash is part of a local path, ./repo:1 is not a host
+func parseSSHUrl(repoUrl string) (sshAddress, bool) {
+	for _, scheme := range []string{"ssh://", "git+ssh://", "ssh+git://"} {
+		if !strings.HasPrefix(repoUrl, scheme) {
+			continue
+		}
+
+		parsed, err := url.Parse("ssh://" + strings.TrimPrefix(repoUrl, scheme))
+
+		if err != nil || parsed.Hostname() == "" {
+			return sshAddress{}, false
+		}
+
+		address := sshAddress{host: parsed.Hostname(), port: parsed.Port(), path: parsed.Path}
+
+		if parsed.User != nil {
+			address.host = parsed.User.Username() + "@" + address.host
+		}
+
+		if strings.HasPrefix(address.path, "/~") {
+			address.path = address.path[1:]
+		}
+
+		return address, address.path != ""
+	}
+
+	if !isScpLikeUrl(repoUrl) {
+		return sshAddress{}, false
+	}
+
+	host, path, _ := strings.Cut(repoUrl, ":")
+
+	return sshAddress{host: host, path: path}, path != ""
+}
+
+func isScpLikeUrl(repoUrl string) bool {
+	colon := strings.Index(repoUrl, ":")
+
+	return !strings.Contains(repoUrl, "://") && colon > 0 && !strings.Contains(repoUrl[:colon], "/")
+}
+
+// ssh [-o SendEnv=GIT_PROTOCOL] [-p <port>] -- <host> "git-upload-pack '<path>'"
+//
+// the command is $GIT_SSH_COMMAND, run by the shell so it may have
+// arguments of its own, then $GIT_SSH and then plain ssh. GIT_PROTOCOL
+// only reaches the server when its sshd accepts the variable, a fake
+// command standing in for ssh gets it in its environment
+//
+// a host like -oProxyCommand=... would be an option to ssh and run a
+// command of the url's choosing (CVE-2017-1000117), it is refused and
+// -- ends the options in any case
+func (a sshAddress) connect(service string, gitProtocol string) (io.WriteCloser, io.ReadCloser, error) {
+	if strings.HasPrefix(a.host, "-") {
+		return nil, nil, fmt.Errorf("strange hostname '%s' blocked", a.host)
+	}
+
+	args := []string{}
+
+	if gitProtocol != "" {
+		args = append(args, "-o", "SendEnv=GIT_PROTOCOL")
+	}
+
+	if a.port != "" {
+		args = append(args, "-p", a.port)
+	}
+
+	args = append(args, "--", a.host, service+" "+shellQuote(a.path))
+
+	var cmd *exec.Cmd
+
+	if sshCommand := os.Getenv("GIT_SSH_COMMAND"); sshCommand != "" {
+		cmd = exec.Command("sh", append([]string{"-c", sshCommand + ` "$@"`, sshCommand}, args...)...)
+	} else if sshProgram := os.Getenv("GIT_SSH"); sshProgram != "" {
+		cmd = exec.Command(sshProgram, args...)
+	} else {
+		cmd = exec.Command("ssh", args...)
+	}
+
+	cmd.Env = os.Environ()
+
+	if gitProtocol != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+gitProtocol)
+	}
+
+	// what ssh and the service have to say, a password prompt goes to the terminal
+	cmd.Stderr = os.Stderr
+
+	input, err := cmd.StdinPipe()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error running ssh: %w", err)
+	}
+
+	return input, &commandOutput{ReadCloser: output, cmd: cmd}, nil
+}
+
+// the output of a command, closing it waits for the command to end
+type commandOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (o *commandOutput) Close() error {
+	o.ReadCloser.Close()
+
+	return o.cmd.Wait()
+}
+
+// the path as one shell word for the remote shell, like git's sq_quote:
+// inside single quotes only ' and ! need care
+//
+//	/srv/it's.git -> '/srv/it'\''s.git'
+func shellQuote(path string) string {
+	quoted := strings.ReplaceAll(path, "'", `'\''`)
+	quoted = strings.ReplaceAll(quoted, "!", `'\!'`)
+
+	return "'" + quoted + "'"
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+func TestParseSSHUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want sshAddress
+		ok   bool
+	}{
+		{"git@github.com:org/repo.git", sshAddress{host: "git@github.com", path: "org/repo.git"}, true},
+		{"example.com:repo.git", sshAddress{host: "example.com", path: "repo.git"}, true},
+		{"example.com:/srv/repo.git", sshAddress{host: "example.com", path: "/srv/repo.git"}, true},
+		{"ssh://git@example.com:2222/srv/repo.git", sshAddress{host: "git@example.com", port: "2222", path: "/srv/repo.git"}, true},
+		{"ssh://example.com/srv/repo.git", sshAddress{host: "example.com", path: "/srv/repo.git"}, true},
+		{"ssh://example.com/~paul/repo.git", sshAddress{host: "example.com", path: "~paul/repo.git"}, true},
+		{"git+ssh://example.com/repo.git", sshAddress{host: "example.com", path: "/repo.git"}, true},
+		{"ssh+git://example.com/repo.git", sshAddress{host: "example.com", path: "/repo.git"}, true},
+		// a colon after a slash is part of a local path
+		{"./repo:1", sshAddress{}, false},
+		{"/srv/repo:1", sshAddress{}, false},
+		{"repo", sshAddress{}, false},
+		{"example.com:", sshAddress{}, false},
+		{"ssh://example.com", sshAddress{}, false},
+		{"https://example.com/repo.git", sshAddress{}, false},
+		{"git://example.com/repo.git", sshAddress{}, false},
+	}
+
+	for _, test := range tests {
+		got, ok := parseSSHUrl(test.url)
+
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("parseSSHUrl(%q) = %+v, %v, want %+v, %v", test.url, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// stands in for ssh: it logs what it was asked for and runs the command
+// here instead of on the host. FAKE_SSH_V0 drops GIT_PROTOCOL like an sshd
+// that does not accept the variable
+const fakeSSH = `#!/bin/sh
+while [ $# -gt 2 ]; do shift; done
+echo "$1|$2|$GIT_PROTOCOL" >> "$FAKE_SSH_LOG"
+if [ -n "$FAKE_SSH_V0" ]; then unset GIT_PROTOCOL; fi
+exec sh -c "$2"
+`
+
+func TestSSHTransport(t *testing.T) {
+	requireGit(t)
+
+	bareDir, _ := newSourceRepository(t)
+	scriptDir := t.TempDir()
+	script := filepath.Join(scriptDir, "fake-ssh")
+	log := filepath.Join(scriptDir, "ssh.log")
+
+	if err := os.WriteFile(script, []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GIT_SSH_COMMAND", script)
+	t.Setenv("FAKE_SSH_LOG", log)
+
+	// what the fake ssh was asked for since the last call
+	sshCalls := func() []string {
+		content, _ := os.ReadFile(log)
+		os.Remove(log)
+
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	// a commit on master of the source, made in a clone of its own
+	pushToSource := func(file string) string {
+		workDir := t.TempDir()
+		runGit(t, workDir, "clone", "-q", bareDir, ".")
+		writeFile(t, filepath.Join(workDir, file), file+"\n")
+		runGit(t, workDir, "add", file)
+		runGit(t, workDir, "commit", "-q", "-m", file)
+		runGit(t, workDir, "push", "-q", "origin", "master")
+
+		return runGit(t, workDir, "rev-parse", "HEAD")
+	}
+
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+
+	if _, err := captureStdout(t, func() error {
+		return CloneRepo("ssh://git@example.com"+bareDir, cloneDir, cloneOptions{})
+	}); err != nil {
+		t.Fatalf("clone over ssh failed: %v", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(cloneDir, "src", "main.go")); err != nil || string(content) != "package main\n" {
+		t.Errorf("src/main.go = %q, %v", content, err)
+	}
+
+	for _, call := range sshCalls() {
+		want := "git@example.com|git-upload-pack '" + bareDir + "'|version=2"
+
+		if call != want {
+			t.Errorf("ssh was run with %q, want %q", call, want)
+		}
+	}
+
+	repo, err := helper.OpenRepositoryAt(cloneDir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []string{"v2", "v0"} {
+		if version == "v0" {
+			t.Setenv("FAKE_SSH_V0", "1")
+		}
+
+		want := pushToSource(version + ".txt")
+
+		if _, err := captureStdout(t, func() error {
+			return fetch(repo, []string{"origin"})
+		}); err != nil {
+			t.Fatalf("fetch over ssh with protocol %s failed: %v", version, err)
+		}
+
+		if got, err := repo.ResolveRef("refs/remotes/origin/master"); err != nil || got != want {
+			t.Errorf("after a %s fetch origin/master = %s, %v, want %s", version, got, err, want)
+		}
+
+		sshCalls()
+	}
+
+	runGit(t, cloneDir, "commit", "-q", "--allow-empty", "-m", "pushed over ssh")
+	want := runGit(t, cloneDir, "rev-parse", "HEAD")
+
+	if _, err := captureStdout(t, func() error {
+		return push(repo, []string{"origin", "HEAD:refs/heads/feature"})
+	}); err != nil {
+		t.Fatalf("push over ssh failed: %v", err)
+	}
+
+	if got := runGit(t, bareDir, "rev-parse", "refs/heads/feature"); got != want {
+		t.Errorf("after the push feature = %s, want %s", got, want)
+	}
+
+	if calls := sshCalls(); !strings.HasPrefix(calls[0], "git@example.com|git-receive-pack '"+bareDir+"'|") {
+		t.Errorf("push ran ssh with %q", calls)
+	}
+}
+
+func TestSSHConnectArguments(t *testing.T) {
+	scriptDir := t.TempDir()
+	script := filepath.Join(scriptDir, "fake-ssh")
+	log := filepath.Join(scriptDir, "ssh.log")
+
+	// only logs its arguments, one per line
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > \"$FAKE_SSH_LOG\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GIT_SSH_COMMAND", "")
+	t.Setenv("GIT_SSH", script)
+	t.Setenv("FAKE_SSH_LOG", log)
+
+	tests := []struct {
+		url  string
+		args string
+		err  string
+	}{
+		{"ssh://git@example.com:2222/repo.git", "-p\n2222\n--\ngit@example.com\ngit-upload-pack '/repo.git'\n", ""},
+		{"example.com:repo.git", "--\nexample.com\ngit-upload-pack 'repo.git'\n", ""},
+		{"-oProxyCommand=touch pwned:repo.git", "", "strange hostname '-oProxyCommand=touch pwned' blocked"},
+		{"ssh://-oProxyCommand=x@example.com/repo.git", "", "strange hostname"},
+	}
+
+	for _, test := range tests {
+		os.Remove(log)
+		address, ok := parseSSHUrl(test.url)
+
+		if !ok {
+			t.Errorf("parseSSHUrl(%q) failed", test.url)
+			continue
+		}
+
+		input, output, err := address.connect("git-upload-pack", "")
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.url, err, test.err)
+			}
+
+			if _, err := os.Stat(log); err == nil {
+				t.Errorf("%s: ssh was run", test.url)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+			continue
+		}
+
+		input.Close()
+		output.Close()
+
+		if args, _ := os.ReadFile(log); string(args) != test.args {
+			t.Errorf("%s: ssh was run with %q, want %q", test.url, args, test.args)
+		}
+	}
+}
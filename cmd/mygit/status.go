@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git status, either the long human readable format or --porcelain=v1
+//
+//	XY <path>
+//
+// X is the index compared to HEAD, Y the working tree compared to the index,
+// untracked files are "?? <path>". Paths are relative to the top of the work tree
+func printStatus(repo *helper.Repository, porcelain bool) error {
+	statuses, err := repo.Status()
+
+	if err != nil {
+		return err
+	}
+
+	if porcelain {
+		config, err := repo.Config()
+
+		if err != nil {
+			return err
+		}
+
+		quoteHighBytes := config.GetBool("core.quotePath", true)
+
+		for _, status := range statuses {
+			fmt.Printf("%c%c %s\n", status.Staged, status.Unstaged, quotePath(status.Path, quoteHighBytes))
+		}
+
+		return nil
+	}
+
+	target, symbolic, err := repo.ReadSymbolicRef("HEAD")
+
+	if err != nil {
+		return err
+	}
+
+	if symbolic {
+		fmt.Printf("On branch %s\n", strings.TrimPrefix(target, "refs/heads/"))
+	} else {
+		fmt.Printf("HEAD detached at %s\n", target[:7])
+	}
+
+	if _, err := repo.ResolveRef("HEAD"); errors.Is(err, helper.ErrRefNotFound) {
+		fmt.Printf("\nNo commits yet\n")
+	}
+
+	staged := []string{}
+	unstaged := []string{}
+	untracked := []string{}
+	unmerged := []string{}
+
+	for _, status := range statuses {
+		path := displayPath(repo, status.Path)
+
+		switch {
+		case status.Staged == '?':
+			untracked = append(untracked, path)
+		case status.Staged == 'U':
+			unmerged = append(unmerged, fmt.Sprintf("both modified:   %s", path))
+		default:
+			if status.Staged != ' ' {
+				staged = append(staged, statusLabel(status.Staged)+path)
+			}
+
+			if status.Unstaged != ' ' {
+				unstaged = append(unstaged, statusLabel(status.Unstaged)+path)
+			}
+		}
+	}
+
+	printStatusSection("Changes to be committed", "use \"git restore --staged <file>...\" to unstage", staged)
+	printStatusSection("Unmerged paths", "use \"git add <file>...\" to mark resolution", unmerged)
+	printStatusSection("Changes not staged for commit", "use \"git add <file>...\" to update what will be committed", unstaged)
+	printStatusSection("Untracked files", "use \"git add <file>...\" to include in what will be committed", untracked)
+
+	switch {
+	case len(staged) > 0:
+	case len(unstaged) > 0:
+		fmt.Printf("\nno changes added to commit (use \"git add\")\n")
+	case len(untracked) > 0:
+		fmt.Printf("\nnothing added to commit but untracked files present (use \"git add\" to track)\n")
+	default:
+		fmt.Printf("nothing to commit, working tree clean\n")
+	}
+
+	return nil
+}
+
+func printStatusSection(title string, hint string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%s:\n  (%s)\n", title, hint)
+
+	for _, line := range lines {
+		fmt.Printf("\t%s\n", line)
+	}
+}
+
+func statusLabel(change byte) string {
+	switch change {
+	case 'A':
+		return "new file:   "
+	case 'D':
+		return "deleted:    "
+	case 'T':
+		return "typechange: "
+	default:
+		return "modified:   "
+	}
+}
+
+// the long format shows paths relative to the current directory
+func displayPath(repo *helper.Repository, path string) string {
+	cwd, err := os.Getwd()
+
+	if err != nil {
+		return path
+	}
+
+	relativePath, err := filepath.Rel(cwd, filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+
+	if err != nil {
+		return path
+	}
+
+	if strings.HasSuffix(path, "/") {
+		relativePath += "/"
+	}
+
+	return filepath.ToSlash(relativePath)
+}
+
+// porcelain output quotes paths like git's quote_c_style: a path with a
+// space, a quote, a backslash or a control character goes in double quotes
+// with C escapes, and with core.quotePath, the default, so does one with
+// bytes of 0x80 and above, which are written in octal
+//
+//	my file.txt -> "my file.txt"
+//	café.txt    -> "caf\303\251.txt"
+func quotePath(path string, quoteHighBytes bool) string {
+	needsQuotes := false
+
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c == ' ' || c == '"' || c == '\\' || c < 0x20 || c == 0x7f || (c >= 0x80 && quoteHighBytes) {
+			needsQuotes = true
+			break
+		}
+	}
+
+	if !needsQuotes {
+		return path
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '"' || c == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case c == '\a':
+			quoted.WriteString(`\a`)
+		case c == '\b':
+			quoted.WriteString(`\b`)
+		case c == '\t':
+			quoted.WriteString(`\t`)
+		case c == '\n':
+			quoted.WriteString(`\n`)
+		case c == '\v':
+			quoted.WriteString(`\v`)
+		case c == '\f':
+			quoted.WriteString(`\f`)
+		case c == '\r':
+			quoted.WriteString(`\r`)
+		case c < 0x20 || c == 0x7f || (c >= 0x80 && quoteHighBytes):
+			fmt.Fprintf(&quoted, "\\%03o", c)
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
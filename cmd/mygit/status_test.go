@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// what fn prints, the commands write straight to stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	output := make(chan string)
+
+	go func() {
+		content, _ := io.ReadAll(reader)
+		output <- string(content)
+	}()
+
+	fnErr := fn()
+
+	os.Stdout = stdout
+	writer.Close()
+
+	return <-output, fnErr
+}
+
+func TestQuotePath(t *testing.T) {
+	tests := []struct {
+		path           string
+		quoteHighBytes bool
+		want           string
+	}{
+		{"plain.txt", true, "plain.txt"},
+		{"dir/file.txt", true, "dir/file.txt"},
+		{"with space", true, `"with space"`},
+		{"tab\there", true, `"tab\there"`},
+		{"new\nline", true, `"new\nline"`},
+		{"bell\a", true, `"bell\a"`},
+		{"escape\x1b", true, `"escape\033"`},
+		{"del\x7f", true, `"del\177"`},
+		{`q"uote`, true, `"q\"uote"`},
+		{`back\slash`, true, `"back\\slash"`},
+		{"caf\u00e9", true, `"caf\303\251"`},
+		{"caf\u00e9", false, "caf\u00e9"},
+		{"caf\u00e9 au lait", false, "\"caf\u00e9 au lait\""},
+	}
+
+	for _, test := range tests {
+		if got := quotePath(test.path, test.quoteHighBytes); got != test.want {
+			t.Errorf("quotePath(%q, %v) = %s, want %s", test.path, test.quoteHighBytes, got, test.want)
+		}
+	}
+}
+
+func TestPrintStatusPorcelain(t *testing.T) {
+	repo, err := helper.InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"staged.txt", "with space", "untracked.txt"} {
+		if err := os.WriteFile(filepath.Join(repo.WorkTree, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "staged.txt")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := captureStdout(t, func() error {
+		return printStatus(repo, true)
+	})
+
+	want := "A  staged.txt\n" +
+		"?? untracked.txt\n" +
+		"?? \"with space\"\n"
+
+	if err != nil || output != want {
+		t.Errorf("printStatus printed\n%s(%v)\nwant\n%s", output, err, want)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// git switch <branch>                  git checkout <branch>
+// git switch -c|-C <new> [<start>]     git checkout -b|-B <new> [<start>]
+// git switch --detach <commit>         git checkout [--detach] <commit>
+//
+// checkout detaches HEAD when given something that is not a branch,
+// switch only does that with --detach
+func switchBranch(repo *helper.Repository, command string, args []string) error {
+	usage := fmt.Errorf("usage: mygit %s [-c|-C <new-branch>] [--detach] <branch>", command)
+	createFlag, forceCreateFlag := "-c", "-C"
+
+	if command == "checkout" {
+		usage = fmt.Errorf("usage: mygit %s [-b|-B <new-branch>] [--detach] <branch>", command)
+		createFlag, forceCreateFlag = "-b", "-B"
+	}
+
+	newBranch := ""
+	forceCreate := false
+	detach := false
+	positional := []string{}
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case (args[i] == createFlag || args[i] == forceCreateFlag) && i+1 < len(args):
+			newBranch = args[i+1]
+			forceCreate = args[i] == forceCreateFlag
+			i++
+		case args[i] == "--detach" || (args[i] == "-d" && command == "switch"):
+			detach = true
+		case strings.HasPrefix(args[i], "-"):
+			return usage
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) > 1 || (newBranch == "" && len(positional) == 0) || (newBranch != "" && detach) {
+		return usage
+	}
+
+	target := "HEAD"
+
+	if len(positional) == 1 {
+		target = positional[0]
+	}
+
+	currentBranch, err := repo.CurrentBranch()
+
+	if err != nil {
+		return err
+	}
+
+	fromTree, err := headTree(repo)
+
+	if err != nil {
+		return err
+	}
+
+	isBranch := newBranch == "" && !detach && repo.BranchExists(target)
+	targetSHA := ""
+
+	if isBranch {
+		targetSHA, err = repo.ResolveRef("refs/heads/" + target)
+	} else {
+		targetSHA, err = repo.ResolveRevision(target)
+	}
+
+	// git switch -c on an unborn branch only moves HEAD
+	if err != nil && !(newBranch != "" && target == "HEAD" && fromTree == "") {
+		return err
+	}
+
+	if newBranch == "" && !detach && !isBranch && command == "switch" {
+		return fmt.Errorf("a branch is expected, got '%s'", target)
+	}
+
+	// checked before touching the working tree
+	if newBranch != "" {
+		if err := helper.CheckBranchName(newBranch); err != nil {
+			return err
+		}
+
+		if !forceCreate && repo.BranchExists(newBranch) {
+			return fmt.Errorf("a branch named '%s' already exists", newBranch)
+		}
+	}
+
+	if targetSHA != "" {
+		commit, err := repo.ReadCommit(targetSHA)
+
+		if err != nil {
+			return err
+		}
+
+		if err := repo.SwitchTree(fromTree, commit.Tree); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case newBranch != "":
+		if targetSHA != "" {
+			if err := repo.CreateBranch(newBranch, targetSHA, forceCreate); err != nil {
+				return err
+			}
+		}
+
+		if err := repo.WriteSymbolicRef("HEAD", "refs/heads/"+newBranch); err != nil {
+			return err
+		}
+
+		fmt.Printf("Switched to a new branch '%s'\n", newBranch)
+
+	case isBranch:
+		if target == currentBranch {
+			fmt.Printf("Already on '%s'\n", target)
+			return nil
+		}
+
+		if err := repo.WriteSymbolicRef("HEAD", "refs/heads/"+target); err != nil {
+			return err
+		}
+
+		fmt.Printf("Switched to branch '%s'\n", target)
+
+	default:
+		if err := repo.UpdateRef("HEAD", targetSHA, ""); err != nil {
+			return err
+		}
+
+		commit, err := repo.ReadCommit(targetSHA)
+
+		if err != nil {
+			return err
+		}
+
+		summary, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Printf("HEAD is now at %s %s\n", targetSHA[:7], summary)
+	}
+
+	return nil
+}
+
+// the tree of the commit HEAD points to, empty on an unborn branch
+func headTree(repo *helper.Repository) (string, error) {
+	headSHA, err := repo.ResolveRef("HEAD")
+
+	if errors.Is(err, helper.ErrRefNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	commit, err := repo.ReadCommit(headSHA)
+
+	if err != nil {
+		return "", err
+	}
+
+	return commit.Tree, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/helper"
+)
+
+// how the pkt-lines get to git-upload-pack or git-receive-pack and back.
+// ref discovery, requests and push only go through these, the same for
+// every kind of url. only a v0 negotiation over ssh or git:// needs more,
+// its rounds go on in one connection
+type Transport interface {
+	// what the service says first: the refs and capabilities, or for
+	// protocol v2 the capabilities only. gitProtocol asks for a version,
+	// "version=2", servers that do not know it ignore it
+	Advertise(service string, gitProtocol string) ([]byte, error)
+	// one request, the response is read as it arrives and closed by the caller
+	Request(service string, requestBody []byte, gitProtocol string) (io.ReadCloser, error)
+}
+
+// the transport of a remote url
+//
+//	https://host/org/repo.git     http, smart protocol only
+//	ssh://[user@]host[:port]/path ssh, also the scp-like [user@]host:path
+//	git://host[:port]/path        git daemon, read only
+func newTransport(repoUrl string) (Transport, error) {
+	if strings.HasPrefix(repoUrl, "http://") || strings.HasPrefix(repoUrl, "https://") {
+		return &httpTransport{url: repoUrl}, nil
+	}
+
+	if address, ok := parseSSHUrl(repoUrl); ok {
+		return &connectionTransport{connect: address.connect}, nil
+	}
+
+	if address, ok := parseDaemonUrl(repoUrl); ok {
+		return &connectionTransport{connect: address.connect}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported url '%s'", repoUrl)
+}
+
+// https://git-scm.com/docs/http-protocol, every request a POST of its own
+type httpTransport struct {
+	url string
+}
+
+// GET $GIT_URL/info/refs?service=<service>, gitProtocol goes in the Git-Protocol header
+func (t *httpTransport) Advertise(service string, gitProtocol string) ([]byte, error) {
+	infoUrl := t.url + "/info/refs?service=" + service
+	req, err := http.NewRequest("GET", infoUrl, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating ref discovery request: %w", err)
+	}
+
+	if gitProtocol != "" {
+		req.Header.Set("Git-Protocol", gitProtocol)
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting repository info: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	return body, nil
+}
+
+// POST $GIT_URL/<service> with Content-Type: application/x-<service>-request
+func (t *httpTransport) Request(service string, requestBody []byte, gitProtocol string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("POST", t.url+"/"+service, bytes.NewReader(requestBody))
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s request: %w", service, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-"+service+"-request")
+
+	if gitProtocol != "" {
+		req.Header.Set("Git-Protocol", gitProtocol)
+	}
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("error during %s request: %w", service, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s request failed with status: %d", service, res.StatusCode)
+	}
+
+	return res.Body, nil
+}
+
+// the services behind ssh and git:// talk over one connection, the server
+// starts with its advertisement. every request gets a connection of its
+// own: the advertisement is skipped, the request written and our side
+// closed, which ends the service once it has answered
+type connectionTransport struct {
+	// opens a connection to service, what we write goes to its input and
+	// its output is what we read
+	connect func(service string, gitProtocol string) (io.WriteCloser, io.ReadCloser, error)
+}
+
+func (t *connectionTransport) Advertise(service string, gitProtocol string) ([]byte, error) {
+	input, output, err := t.connect(service, gitProtocol)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer output.Close()
+
+	advertisement, err := readAdvertisement(helper.NewPacketReader(output))
+
+	if err != nil {
+		input.Close()
+		return nil, err
+	}
+
+	// a v0 service waits for our wants or commands, a flush says there are
+	// none. a v2 one waits for a command and is fine with the end of input
+	if !bytes.HasPrefix(advertisement, []byte("000eversion 2\n")) {
+		if _, err := io.WriteString(input, "0000"); err != nil {
+			input.Close()
+			return nil, fmt.Errorf("error writing to %s: %w", service, err)
+		}
+	}
+
+	if err := input.Close(); err != nil {
+		return nil, err
+	}
+
+	return advertisement, nil
+}
+
+func (t *connectionTransport) Request(service string, requestBody []byte, gitProtocol string) (io.ReadCloser, error) {
+	input, packets, output, err := t.open(service, gitProtocol)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := &connectionResponse{reader: packets, output: output, written: make(chan error, 1)}
+
+	// the service may answer while the request is still being written,
+	// a push has the whole pack in it
+	go func() {
+		_, err := input.Write(requestBody)
+
+		if closeErr := input.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			err = fmt.Errorf("error writing to %s: %w", service, err)
+		}
+
+		response.written <- err
+	}()
+
+	return response, nil
+}
+
+// a connection to service with the advertisement read, what is said next
+// is up to the caller. a v0 negotiation goes on over several rounds in it
+func (t *connectionTransport) open(service string, gitProtocol string) (io.WriteCloser, *helper.PacketReader, io.ReadCloser, error) {
+	input, output, err := t.connect(service, gitProtocol)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	packets := helper.NewPacketReader(output)
+
+	if _, err := readAdvertisement(packets); err != nil {
+		input.Close()
+		output.Close()
+		return nil, nil, nil, err
+	}
+
+	return input, packets, output, nil
+}
+
+// the answer of a service to a request that is written at the same time.
+// a request that could not be written fails the read at the end of the
+// answer, by then the service is gone and so is the write, and Close
+type connectionResponse struct {
+	reader  io.Reader
+	output  io.Closer
+	written chan error
+	// what the write ended with, once it is over
+	writeDone bool
+	writeErr  error
+}
+
+func (r *connectionResponse) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	if err != nil {
+		if writeErr := r.writeError(errors.Is(err, io.EOF)); writeErr != nil {
+			err = errors.Join(err, writeErr)
+		}
+	}
+
+	return n, err
+}
+
+// closing our end of the output ends the service, and with it a write
+// that is still going on
+func (r *connectionResponse) Close() error {
+	err := r.output.Close()
+
+	return errors.Join(r.writeError(true), err)
+}
+
+func (r *connectionResponse) writeError(wait bool) error {
+	if r.writeDone {
+		return r.writeErr
+	}
+
+	if wait {
+		r.writeErr = <-r.written
+		r.writeDone = true
+		return r.writeErr
+	}
+
+	select {
+	case r.writeErr = <-r.written:
+		r.writeDone = true
+	default:
+	}
+
+	return r.writeErr
+}
+
+// the pkt-lines up to the first flush, as they came
+func readAdvertisement(packets *helper.PacketReader) ([]byte, error) {
+	advertisement := []byte{}
+
+	for {
+		payload, kind, err := packets.ReadPacket()
+
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("could not read from remote repository, the remote end hung up")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading ref advertisement: %w", err)
+		}
+
+		if kind == 0 {
+			return append(advertisement, "0000"...), nil
+		}
+
+		if kind > 0 {
+			return nil, fmt.Errorf("invalid ref advertisement: unexpected packet %04x", kind)
+		}
+
+		// an error of the server, "ERR access denied"
+		if message, found := strings.CutPrefix(string(payload), "ERR "); found {
+			return nil, fmt.Errorf("remote error: %s", strings.TrimSuffix(message, "\n"))
+		}
+
+		advertisement = append(advertisement, helper.FormatPacketLine(string(payload))...)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// our side of a fake connection, err makes every write fail
+type requestRecorder struct {
+	bytes.Buffer
+	err    error
+	closed bool
+}
+
+func (r *requestRecorder) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return r.Buffer.Write(p)
+}
+
+func (r *requestRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestConnectionTransportRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		writeErr error
+		err      string
+	}{
+		{"written", nil, ""},
+		{"write failed", errors.New("broken pipe"), "error writing to git-upload-pack: broken pipe"},
+	}
+
+	for _, test := range tests {
+		input := &requestRecorder{err: test.writeErr}
+		transport := &connectionTransport{connect: func(service string, gitProtocol string) (io.WriteCloser, io.ReadCloser, error) {
+			// the advertisement, skipped, then the answer
+			output := io.NopCloser(strings.NewReader("0000" + "0008NAK\n"))
+
+			return input, output, nil
+		}}
+
+		response, err := transport.Request("git-upload-pack", []byte("0009done\n"), "")
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		answer, readErr := io.ReadAll(response)
+		closeErr := response.Close()
+
+		if test.err == "" {
+			if readErr != nil || closeErr != nil || string(answer) != "0008NAK\n" || input.String() != "0009done\n" || !input.closed {
+				t.Errorf("%s: read %q, %v, close %v, wrote %q", test.name, answer, readErr, closeErr, input.String())
+			}
+
+			continue
+		}
+
+		for _, err := range []error{readErr, closeErr} {
+			if err == nil || !strings.Contains(err.Error(), test.err) || !errors.Is(err, test.writeErr) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package helper
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// what a server announces before a fetch or push, protocol v0/v1
+// https://git-scm.com/docs/pack-protocol#_reference_discovery
+//
+//	# service=git-upload-pack                     (smart http only)
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEAD\0multi_ack ... symref=HEAD:refs/heads/master object-format=sha1 agent=git/2.43.0
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd refs/heads/master
+//	2cb58b79488a98d2721cea644875a8dd0026b115 refs/tags/v1.0
+//	a3c2e2402b99163d1d59756e5f207ae21cccba4c refs/tags/v1.0^{}
+//
+// an empty repository sends a single "<zero sha> capabilities^{}" line
+// to carry the capabilities
+//
+// a protocol v2 server only sends its capabilities, one per line after
+// "version 2", the refs are asked for with ls-refs and added by AddLsRefs
+type Advertisement struct {
+	// 2 for protocol v2, 0 for v0 and v1 which look the same
+	Version int
+	// in the order the server sent them, HEAD included
+	Refs []Ref
+	// annotated tag -> the object it points to, from the "^{}" lines
+	Peeled map[string]string
+	// as sent, "symref=HEAD:refs/heads/master" included
+	Capabilities []string
+	// HEAD -> refs/heads/master, from the symref= capabilities
+	Symrefs map[string]string
+	// sha1 unless the server says object-format=sha256
+	ObjectFormat string
+}
+
+func ParseAdvertisement(lines []string) (*Advertisement, error) {
+	advertisement := &Advertisement{
+		Peeled:       map[string]string{},
+		Symrefs:      map[string]string{},
+		ObjectFormat: "sha1",
+	}
+
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "version 2" {
+			return parseV2Capabilities(advertisement, lines[i+1:]), nil
+		}
+
+		if strings.HasPrefix(line, "# service=") || line == "version 1" || strings.HasPrefix(line, "shallow ") {
+			continue
+		}
+
+		// the capabilities follow the first ref after a NUL byte
+		line, capabilities, found := strings.Cut(line, "\x00")
+
+		if found {
+			advertisement.Capabilities = strings.Fields(capabilities)
+		}
+
+		sha, name, found := strings.Cut(line, " ")
+
+		if !found || !isHexObjectName(sha) || name == "" {
+			return nil, fmt.Errorf("malformed ref advertisement line %q", line)
+		}
+
+		if name == "capabilities^{}" {
+			continue
+		}
+
+		if tag, peeled := strings.CutSuffix(name, "^{}"); peeled {
+			advertisement.Peeled[tag] = sha
+			continue
+		}
+
+		advertisement.Refs = append(advertisement.Refs, Ref{Name: name, SHA: sha})
+	}
+
+	for _, capability := range advertisement.Capabilities {
+		if value, found := strings.CutPrefix(capability, "symref="); found {
+			if name, target, found := strings.Cut(value, ":"); found {
+				advertisement.Symrefs[name] = target
+			}
+		}
+
+		if value, found := strings.CutPrefix(capability, "object-format="); found {
+			advertisement.ObjectFormat = value
+		}
+	}
+
+	return advertisement, nil
+}
+
+// version 2
+// agent=git/2.43.0
+// ls-refs=unborn
+// fetch=shallow wait-for-done filter
+// object-format=sha1
+func parseV2Capabilities(advertisement *Advertisement, lines []string) *Advertisement {
+	advertisement.Version = 2
+
+	for _, line := range lines {
+		capability := strings.TrimSuffix(line, "\n")
+		advertisement.Capabilities = append(advertisement.Capabilities, capability)
+
+		if value, found := strings.CutPrefix(capability, "object-format="); found {
+			advertisement.ObjectFormat = value
+		}
+	}
+
+	return advertisement
+}
+
+// the answer to a protocol v2 ls-refs, one ref per line with attributes
+//
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd HEAD symref-target:refs/heads/master
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd refs/heads/master
+//	2cb58b79488a98d2721cea644875a8dd0026b115 refs/tags/v1.0 peeled:a3c2e2402b99163d1d59756e5f207ae21cccba4c
+//	unborn HEAD symref-target:refs/heads/main       (an empty repository)
+func (a *Advertisement) AddLsRefs(lines []string) error {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+
+		if len(fields) < 2 || (fields[0] != "unborn" && !isHexObjectName(fields[0])) {
+			return fmt.Errorf("malformed ls-refs line %q", strings.TrimSpace(line))
+		}
+
+		name := fields[1]
+
+		for _, attribute := range fields[2:] {
+			if target, found := strings.CutPrefix(attribute, "symref-target:"); found {
+				a.Symrefs[name] = target
+			}
+
+			if peeled, found := strings.CutPrefix(attribute, "peeled:"); found {
+				a.Peeled[name] = peeled
+			}
+		}
+
+		if fields[0] != "unborn" {
+			a.Refs = append(a.Refs, Ref{Name: name, SHA: fields[0]})
+		}
+	}
+
+	return nil
+}
+
+// what a server would advertise for a repository on this machine: HEAD,
+// every ref under refs/ and the peeled annotated tags. there is nobody to
+// ask for capabilities, the objects are read directly
+func NewLocalAdvertisement(repo *Repository) (*Advertisement, error) {
+	advertisement := &Advertisement{
+		Peeled:       map[string]string{},
+		Symrefs:      map[string]string{},
+		ObjectFormat: "sha1",
+	}
+
+	// an unborn HEAD only has its target, like in an empty repository
+	if target, symbolic, err := repo.ReadSymbolicRef("HEAD"); err == nil && symbolic {
+		advertisement.Symrefs["HEAD"] = target
+	}
+
+	if sha, err := repo.ResolveRef("HEAD"); err == nil {
+		advertisement.Refs = append(advertisement.Refs, Ref{Name: "HEAD", SHA: sha})
+	}
+
+	refs, err := repo.ListRefs("refs/")
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		advertisement.Refs = append(advertisement.Refs, ref)
+
+		if _, objectType, err := repo.Objects.Read(ref.SHA); err == nil && objectType == "tag" {
+			if peeled, err := repo.peelToCommit(ref.SHA); err == nil {
+				advertisement.Peeled[ref.Name] = peeled
+			}
+		}
+	}
+
+	return advertisement, nil
+}
+
+// whether a protocol v2 command supports a feature, "shallow" for
+// "fetch=shallow wait-for-done filter"
+func (a *Advertisement) HasCommandFeature(command string, feature string) bool {
+	value, found := a.Capability(command)
+
+	if !found {
+		return false
+	}
+
+	for _, supported := range strings.Fields(value) {
+		if supported == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// both sha1 and sha256 names, the object format is only checked later
+func isHexObjectName(name string) bool {
+	if len(name) != 40 && len(name) != 64 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+
+	return err == nil
+}
+
+// the sha the ref points to
+func (a *Advertisement) Ref(name string) (string, bool) {
+	for _, ref := range a.Refs {
+		if ref.Name == name {
+			return ref.SHA, true
+		}
+	}
+
+	return "", false
+}
+
+// whether the server supports a capability, "ofs-delta" or "agent"
+// for "agent=git/2.43.0"
+func (a *Advertisement) HasCapability(name string) bool {
+	_, found := a.Capability(name)
+
+	return found
+}
+
+// the value of a name=value capability, empty for a plain one
+func (a *Advertisement) Capability(name string) (string, bool) {
+	for _, capability := range a.Capabilities {
+		key, value, _ := strings.Cut(capability, "=")
+
+		if key == name {
+			return value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,194 @@
+package helper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAdvertisement(t *testing.T) {
+	master := strings.Repeat("a", 40)
+	tag := strings.Repeat("b", 40)
+	peeled := strings.Repeat("c", 40)
+
+	tests := []struct {
+		name    string
+		lines   []string
+		refs    []Ref
+		symrefs map[string]string
+	}{
+		{
+			"refs with capabilities",
+			[]string{
+				"# service=git-upload-pack\n",
+				master + " HEAD\x00multi_ack ofs-delta symref=HEAD:refs/heads/master agent=git/2.43.0\n",
+				master + " refs/heads/master\n",
+				tag + " refs/tags/v1.0\n",
+				peeled + " refs/tags/v1.0^{}\n",
+			},
+			[]Ref{{Name: "HEAD", SHA: master}, {Name: "refs/heads/master", SHA: master}, {Name: "refs/tags/v1.0", SHA: tag}},
+			map[string]string{"HEAD": "refs/heads/master"},
+		},
+		{
+			"empty repository",
+			[]string{ZeroSHA + " capabilities^{}\x00ofs-delta symref=HEAD:refs/heads/main\n"},
+			nil,
+			map[string]string{"HEAD": "refs/heads/main"},
+		},
+		{
+			"no symref",
+			[]string{master + " HEAD\x00multi_ack\n", master + " refs/heads/main\n"},
+			[]Ref{{Name: "HEAD", SHA: master}, {Name: "refs/heads/main", SHA: master}},
+			map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		advertisement, err := ParseAdvertisement(test.lines)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(advertisement.Refs, test.refs) || !reflect.DeepEqual(advertisement.Symrefs, test.symrefs) {
+			t.Errorf("%s: got %+v %v, want %+v %v", test.name, advertisement.Refs, advertisement.Symrefs, test.refs, test.symrefs)
+		}
+	}
+
+	for _, bad := range []string{"not a sha HEAD\n", master + "\n", master + " \n", master[:39] + " HEAD\n"} {
+		if _, err := ParseAdvertisement([]string{bad}); err == nil {
+			t.Errorf("ParseAdvertisement(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestAdvertisementCapabilities(t *testing.T) {
+	master := strings.Repeat("a", 40)
+	tag := strings.Repeat("b", 40)
+	peeled := strings.Repeat("c", 40)
+
+	advertisement, err := ParseAdvertisement([]string{
+		"version 1\n",
+		master + " HEAD\x00multi_ack ofs-delta symref=HEAD:refs/heads/master agent=git/2.43.0\n",
+		master + " refs/heads/master\n",
+		tag + " refs/tags/v1.0\n",
+		peeled + " refs/tags/v1.0^{}\n",
+		"shallow " + master + "\n",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if advertisement.ObjectFormat != "sha1" || !reflect.DeepEqual(advertisement.Peeled, map[string]string{"refs/tags/v1.0": peeled}) {
+		t.Errorf("object format %s, peeled %v", advertisement.ObjectFormat, advertisement.Peeled)
+	}
+
+	if sha, ok := advertisement.Ref("refs/tags/v1.0"); !ok || sha != tag {
+		t.Errorf("Ref(refs/tags/v1.0) = %s, %v", sha, ok)
+	}
+
+	if _, ok := advertisement.Ref("refs/tags/v1.0^{}"); ok {
+		t.Errorf("the peeled line is a ref")
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		found bool
+	}{
+		{"ofs-delta", "", true},
+		{"agent", "git/2.43.0", true},
+		{"symref", "HEAD:refs/heads/master", true},
+		{"side-band-64k", "", false},
+		{"multi", "", false},
+	}
+
+	for _, test := range tests {
+		value, found := advertisement.Capability(test.name)
+
+		if value != test.value || found != test.found || advertisement.HasCapability(test.name) != test.found {
+			t.Errorf("Capability(%s) = %q, %v, want %q, %v", test.name, value, found, test.value, test.found)
+		}
+	}
+
+	sha256 := strings.Repeat("d", 64)
+	advertisement, err = ParseAdvertisement([]string{sha256 + " refs/heads/main\x00object-format=sha256\n"})
+
+	if err != nil || advertisement.ObjectFormat != "sha256" || advertisement.Refs[0].SHA != sha256 {
+		t.Errorf("sha256 advertisement %+v, %v", advertisement, err)
+	}
+}
+
+func TestParseAdvertisementV2(t *testing.T) {
+	master := strings.Repeat("a", 40)
+	tag := strings.Repeat("b", 40)
+	peeled := strings.Repeat("c", 40)
+
+	advertisement, err := ParseAdvertisement([]string{
+		"# service=git-upload-pack\n",
+		"version 2\n",
+		"agent=git/2.43.0\n",
+		"ls-refs=unborn\n",
+		"fetch=shallow wait-for-done filter\n",
+		"server-option\n",
+		"object-format=sha1\n",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if advertisement.Version != 2 || len(advertisement.Refs) != 0 || advertisement.ObjectFormat != "sha1" {
+		t.Errorf("v2 advertisement %+v", advertisement)
+	}
+
+	features := []struct {
+		command string
+		feature string
+		want    bool
+	}{
+		{"fetch", "shallow", true},
+		{"fetch", "filter", true},
+		{"fetch", "sideband-all", false},
+		{"ls-refs", "unborn", true},
+		{"server-option", "", false},
+		{"object-info", "size", false},
+	}
+
+	for _, test := range features {
+		if got := advertisement.HasCommandFeature(test.command, test.feature); got != test.want {
+			t.Errorf("HasCommandFeature(%s, %s) = %v, want %v", test.command, test.feature, got, test.want)
+		}
+	}
+
+	err = advertisement.AddLsRefs([]string{
+		master + " HEAD symref-target:refs/heads/master\n",
+		master + " refs/heads/master\n",
+		tag + " refs/tags/v1.0 peeled:" + peeled + "\n",
+	})
+
+	wantRefs := []Ref{{Name: "HEAD", SHA: master}, {Name: "refs/heads/master", SHA: master}, {Name: "refs/tags/v1.0", SHA: tag}}
+
+	if err != nil || !reflect.DeepEqual(advertisement.Refs, wantRefs) {
+		t.Errorf("refs after ls-refs %+v, %v, want %+v", advertisement.Refs, err, wantRefs)
+	}
+
+	if advertisement.Symrefs["HEAD"] != "refs/heads/master" || advertisement.Peeled["refs/tags/v1.0"] != peeled {
+		t.Errorf("symrefs %v, peeled %v", advertisement.Symrefs, advertisement.Peeled)
+	}
+
+	// an empty repository only has its unborn HEAD
+	empty, _ := ParseAdvertisement([]string{"version 2\n", "ls-refs=unborn\n"})
+
+	if err := empty.AddLsRefs([]string{"unborn HEAD symref-target:refs/heads/main\n"}); err != nil || len(empty.Refs) != 0 || empty.Symrefs["HEAD"] != "refs/heads/main" {
+		t.Errorf("unborn HEAD: refs %+v, symrefs %v, %v", empty.Refs, empty.Symrefs, err)
+	}
+
+	for _, bad := range []string{"HEAD\n", "nonsense HEAD\n", master[:20] + " refs/heads/x\n"} {
+		if err := empty.AddLsRefs([]string{bad}); err == nil {
+			t.Errorf("AddLsRefs(%q) did not fail", bad)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the rules of git check-ref-format for a branch name
+// https://git-scm.com/docs/git-check-ref-format
+func CheckBranchName(name string) error {
+	invalid := fmt.Errorf("'%s' is not a valid branch name", name)
+
+	if name == "" || name == "HEAD" || name == "@" || strings.HasPrefix(name, "-") {
+		return invalid
+	}
+
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+		return invalid
+	}
+
+	if strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return invalid
+	}
+
+	for _, char := range name {
+		if char < 040 || char == 0177 || strings.ContainsRune(" ~^:?*[\\", char) {
+			return invalid
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return invalid
+		}
+	}
+
+	return nil
+}
+
+// the branch HEAD points to ("main", not "refs/heads/main"),
+// empty when HEAD is detached
+func (r *Repository) CurrentBranch() (string, error) {
+	target, symbolic, err := r.ReadSymbolicRef("HEAD")
+
+	if err != nil || !symbolic {
+		return "", err
+	}
+
+	return strings.TrimPrefix(target, "refs/heads/"), nil
+}
+
+// the commit a revision names, a full sha or a ref as ExpandRef finds it.
+// annotated tags are peeled down to the commit they point to
+func (r *Repository) ResolveRevision(revision string) (string, error) {
+	if IsObjectName(revision) && r.Objects.Has(revision) {
+		return r.peelToCommit(revision)
+	}
+
+	_, sha, err := r.ExpandRef(revision)
+
+	if err != nil {
+		return "", err
+	}
+
+	return r.peelToCommit(sha)
+}
+
+// the full name of the ref a short name stands for and what it points to,
+// looked up like git does:
+//
+//	HEAD, refs/heads/main        the ref itself
+//	refs/<name>
+//	refs/tags/<name>
+//	refs/heads/<name>
+//	refs/remotes/<name>
+//	refs/remotes/<name>/HEAD     "origin" is origin's default branch
+func (r *Repository) ExpandRef(name string) (string, string, error) {
+	candidates := []string{
+		name,
+		"refs/" + name,
+		"refs/tags/" + name,
+		"refs/heads/" + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/HEAD",
+	}
+
+	for _, candidate := range candidates {
+		sha, err := r.ResolveRef(candidate)
+
+		if err == nil {
+			return candidate, sha, nil
+		}
+
+		if !errors.Is(err, ErrRefNotFound) {
+			return "", "", err
+		}
+	}
+
+	return "", "", fmt.Errorf("invalid reference: %s", name)
+}
+
+// object <sha>
+// type commit
+// tag v1.0
+// ...
+func (r *Repository) peelToCommit(sha string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		data, objectType, err := r.Objects.Read(sha)
+
+		if err != nil {
+			return "", err
+		}
+
+		if objectType == "commit" {
+			return sha, nil
+		}
+
+		if objectType != "tag" {
+			return "", fmt.Errorf("object %s is a %s, not a commit", sha, objectType)
+		}
+
+		firstLine, _, _ := strings.Cut(string(data), "\n")
+		target, found := strings.CutPrefix(firstLine, "object ")
+
+		if !found || !IsObjectName(target) {
+			return "", fmt.Errorf("invalid tag %s", sha)
+		}
+
+		sha = target
+	}
+
+	return "", fmt.Errorf("too many levels of tags at %s", sha)
+}
+
+// whether ancestor is reachable from commit by following parents,
+// a commit is its own ancestor
+func (r *Repository) IsAncestor(ancestor string, commit string) (bool, error) {
+	seen := map[string]bool{commit: true}
+	queue := []string{commit}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if current == ancestor {
+			return true, nil
+		}
+
+		parsedCommit, err := r.ReadCommit(current)
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, parent := range parsedCommit.Parents {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// a new branch at startSHA, force moves an existing branch
+// unless it is the one checked out
+func (r *Repository) CreateBranch(name string, startSHA string, force bool) error {
+	if err := CheckBranchName(name); err != nil {
+		return err
+	}
+
+	refName := "refs/heads/" + name
+	oldSHA := ZeroSHA
+
+	if force {
+		current, err := r.CurrentBranch()
+
+		if err != nil {
+			return err
+		}
+
+		if current == name {
+			return fmt.Errorf("cannot force update the current branch")
+		}
+
+		oldSHA = ""
+	}
+
+	err := r.UpdateRef(refName, startSHA, oldSHA)
+
+	if err != nil && !force && r.BranchExists(name) {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+
+	return err
+}
+
+func (r *Repository) BranchExists(name string) bool {
+	_, err := r.ResolveRef("refs/heads/" + name)
+
+	return err == nil
+}
+
+// like git branch -d, a branch that is not merged into HEAD
+// is only deleted with force (-D). Returns the sha it pointed to
+func (r *Repository) DeleteBranch(name string, force bool) (string, error) {
+	refName := "refs/heads/" + name
+	sha, err := r.ResolveRef(refName)
+
+	if errors.Is(err, ErrRefNotFound) {
+		return "", fmt.Errorf("branch '%s' not found", name)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	current, err := r.CurrentBranch()
+
+	if err != nil {
+		return "", err
+	}
+
+	if current == name {
+		return "", fmt.Errorf("cannot delete branch '%s' checked out at '%s'", name, r.WorkTree)
+	}
+
+	if !force {
+		headSHA, err := r.ResolveRef("HEAD")
+		merged := false
+
+		if err == nil {
+			merged, err = r.IsAncestor(sha, headSHA)
+		}
+
+		if err != nil && !errors.Is(err, ErrRefNotFound) {
+			return "", err
+		}
+
+		if !merged {
+			return "", fmt.Errorf("the branch '%s' is not fully merged.\nIf you are sure you want to delete it, run 'git branch -D %s'", name, name)
+		}
+	}
+
+	return sha, r.DeleteRef(refName, sha)
+}
+
+// git branch -m, HEAD follows the branch when it is the current one.
+// renaming the current unborn branch only changes HEAD
+func (r *Repository) RenameBranch(oldName string, newName string, force bool) error {
+	if err := CheckBranchName(newName); err != nil {
+		return err
+	}
+
+	current, err := r.CurrentBranch()
+
+	if err != nil {
+		return err
+	}
+
+	sha, err := r.ResolveRef("refs/heads/" + oldName)
+
+	if errors.Is(err, ErrRefNotFound) && current != oldName {
+		return fmt.Errorf("no branch named '%s'", oldName)
+	}
+
+	if err != nil && !errors.Is(err, ErrRefNotFound) {
+		return err
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	if sha != "" {
+		oldSHA := ZeroSHA
+
+		if force {
+			oldSHA = ""
+		}
+
+		if err := r.UpdateRef("refs/heads/"+newName, sha, oldSHA); err != nil {
+			if !force && r.BranchExists(newName) {
+				return fmt.Errorf("a branch named '%s' already exists", newName)
+			}
+
+			return err
+		}
+
+		if err := r.DeleteRef("refs/heads/"+oldName, sha); err != nil {
+			return err
+		}
+	}
+
+	if current == oldName {
+		return r.WriteSymbolicRef("HEAD", "refs/heads/"+newName)
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the paths a checkout refused to touch
+type CheckoutConflictError struct {
+	LocalChanges []string
+	Untracked    []string
+}
+
+func (e *CheckoutConflictError) Error() string {
+	message := strings.Builder{}
+
+	if len(e.LocalChanges) > 0 {
+		message.WriteString("Your local changes to the following files would be overwritten by checkout:\n")
+
+		for _, path := range e.LocalChanges {
+			message.WriteString("\t" + path + "\n")
+		}
+
+		message.WriteString("Please commit your changes or stash them before you switch branches.\n")
+	}
+
+	if len(e.Untracked) > 0 {
+		message.WriteString("The following untracked working tree files would be overwritten by checkout:\n")
+
+		for _, path := range e.Untracked {
+			message.WriteString("\t" + path + "\n")
+		}
+
+		message.WriteString("Please move or remove them before you switch branches.\n")
+	}
+
+	return message.String() + "Aborting"
+}
+
+// move the index and working tree from one tree to another, like the two
+// tree merge of git read-tree -m -u. fromTree is what HEAD has now, empty
+// when nothing is checked out yet (an unborn branch or a fresh clone)
+//
+// only paths that differ between the two trees are touched. for those the
+// index and the file on disk must still match fromTree, otherwise local work
+// would be lost and nothing is changed at all. paths that are the same in
+// both trees keep whatever is staged or modified, like in git
+func (r *Repository) SwitchTree(fromTree string, toTree string) error {
+	if r.WorkTree == "" {
+		return errors.New("this operation must be run in a work tree")
+	}
+
+	fromEntries, err := r.treeEntriesByPath(fromTree)
+
+	if err != nil {
+		return err
+	}
+
+	toEntries, err := r.treeEntriesByPath(toTree)
+
+	if err != nil {
+		return err
+	}
+
+	index, racyTime, err := r.statusIndex(fromEntries)
+
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+
+	for path := range fromEntries {
+		paths = append(paths, path)
+	}
+
+	for path := range toEntries {
+		if _, ok := fromEntries[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	conflicts := &CheckoutConflictError{}
+	removals := []string{}
+	updates := []TreeEntry{}
+
+	for _, path := range paths {
+		fromEntry, inFrom := fromEntries[path]
+		toEntry, inTo := toEntries[path]
+
+		if inFrom && inTo && fromEntry == toEntry {
+			continue
+		}
+
+		indexEntry, inIndex := index.Entry(path)
+		fullPath := filepath.Join(r.WorkTree, filepath.FromSlash(path))
+
+		if inIndex && indexEntry.Stage() != 0 {
+			conflicts.LocalChanges = append(conflicts.LocalChanges, path)
+			continue
+		}
+
+		// already staged the way the other tree has it, nothing to do
+		if (inIndex && inTo && indexMatches(indexEntry, toEntry)) || (!inIndex && !inTo) {
+			continue
+		}
+
+		if inIndex != inFrom || (inIndex && !indexMatches(indexEntry, fromEntry)) {
+			conflicts.LocalChanges = append(conflicts.LocalChanges, path)
+			continue
+		}
+
+		if inIndex {
+			// a file deleted from the working tree is fine to replace or remove
+			change, err := r.workTreeChange(indexEntry, racyTime)
+
+			if err != nil {
+				return err
+			}
+
+			if change != ' ' && change != 'D' {
+				conflicts.LocalChanges = append(conflicts.LocalChanges, path)
+				continue
+			}
+		} else if r.untrackedAt(index, fullPath, path) {
+			conflicts.Untracked = append(conflicts.Untracked, path)
+			continue
+		}
+
+		if inTo {
+			toEntry.Name = path
+			updates = append(updates, toEntry)
+		} else {
+			removals = append(removals, path)
+		}
+	}
+
+	if len(conflicts.LocalChanges) > 0 || len(conflicts.Untracked) > 0 {
+		return conflicts
+	}
+
+	// deepest paths first so emptied directories can go too
+	for i := len(removals) - 1; i >= 0; i-- {
+		if err := r.removeWorkTreeFile(removals[i]); err != nil {
+			return err
+		}
+
+		index.Remove(removals[i])
+	}
+
+	// a partial clone gets the missing blobs in one fetch
+	blobs := []string{}
+
+	for _, entry := range updates {
+		if entry.Mode != "160000" {
+			blobs = append(blobs, entry.SHA)
+		}
+	}
+
+	if err := r.PrefetchObjects(blobs); err != nil {
+		return err
+	}
+
+	for _, entry := range updates {
+		indexEntry, err := r.checkoutEntry(entry)
+
+		if err != nil {
+			return err
+		}
+
+		index.Add(indexEntry)
+	}
+
+	return r.WriteIndex(index)
+}
+
+func (r *Repository) treeEntriesByPath(treeHash string) (map[string]TreeEntry, error) {
+	result := map[string]TreeEntry{}
+
+	if treeHash == "" {
+		return result, nil
+	}
+
+	entries, err := r.FlattenTree(treeHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		result[entry.Name] = entry
+	}
+
+	return result, nil
+}
+
+func indexMatches(indexEntry IndexEntry, treeEntry TreeEntry) bool {
+	mode, _ := strconv.ParseUint(treeEntry.Mode, 8, 32)
+
+	return indexEntry.Mode == uint32(mode) && indexEntry.SHA == treeEntry.SHA
+}
+
+// a file that is not tracked, or a directory with untracked files in it,
+// sitting where the checkout wants to write
+func (r *Repository) untrackedAt(index *Index, fullPath string, path string) bool {
+	info, err := os.Lstat(fullPath)
+
+	if err != nil {
+		return false
+	}
+
+	if !info.IsDir() {
+		return true
+	}
+
+	return !index.hasEntriesUnder(path) && containsFiles(fullPath)
+}
+
+// whether there is a file anywhere under dir. empty directories hold
+// nothing that could be lost, the checkout may take their place
+func containsFiles(dir string) bool {
+	errFound := errors.New("found")
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return errFound
+		}
+
+		return nil
+	})
+
+	return errors.Is(err, errFound)
+}
+
+func (r *Repository) removeWorkTreeFile(path string) error {
+	fullPath := filepath.Join(r.WorkTree, filepath.FromSlash(path))
+	err := os.Remove(fullPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for dir := filepath.Dir(fullPath); dir != r.WorkTree && strings.HasPrefix(dir, r.WorkTree); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// write one file of the tree and return its index entry with fresh stat data
+//
+//	100644  regular file
+//	100755  executable
+//	120000  symlink, the blob is the target
+//	160000  submodule, only an empty directory
+func (r *Repository) checkoutEntry(entry TreeEntry) (IndexEntry, error) {
+	// FlattenTree checks the names too, this is the last stop before the disk
+	if err := verifyPath(entry.Name); err != nil {
+		return IndexEntry{}, err
+	}
+
+	fullPath := filepath.Join(r.WorkTree, filepath.FromSlash(entry.Name))
+
+	if entry.Mode == "160000" {
+		if err := os.MkdirAll(fullPath, 0755); err != nil {
+			return IndexEntry{}, err
+		}
+
+		return IndexEntry{Mode: 0160000, SHA: entry.SHA, Path: entry.Name}, nil
+	}
+
+	blob, objectType, err := r.Objects.Read(entry.SHA)
+
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	if objectType != "blob" {
+		return IndexEntry{}, fmt.Errorf("object %s is a %s, not a blob", entry.SHA, objectType)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return IndexEntry{}, err
+	}
+
+	// an empty directory left where a file goes, or the old file,
+	// which os.WriteFile would keep the permissions of
+	err = os.Remove(fullPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return IndexEntry{}, err
+	}
+
+	switch entry.Mode {
+	case "120000":
+		err = os.Symlink(string(blob), fullPath)
+	case "100755":
+		err = os.WriteFile(fullPath, blob, 0755)
+	default:
+		err = os.WriteFile(fullPath, blob, 0644)
+	}
+
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	info, err := os.Lstat(fullPath)
+
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	indexEntry := NewIndexEntry(entry.Name, info, entry.SHA)
+	mode, _ := strconv.ParseUint(entry.Mode, 8, 32)
+	indexEntry.Mode = uint32(mode)
+
+	return indexEntry, nil
+}
@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a tree object written as is, names are not checked on the way in
+func writeRawTree(t *testing.T, repo *Repository, entries ...TreeEntry) string {
+	t.Helper()
+
+	content := []byte{}
+
+	for _, entry := range entries {
+		sha, err := hex.DecodeString(entry.SHA)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content = append(content, entry.Mode+" "+entry.Name+"\x00"...)
+		content = append(content, sha...)
+	}
+
+	tree, err := repo.Objects.Write("tree", content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestSwitchTreeInvalidPaths(t *testing.T) {
+	repo := newTestRepository(t)
+	blob, err := repo.Objects.Write("blob", []byte("payload\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := func(name string) TreeEntry {
+		return TreeEntry{Mode: "100644", Name: name, SHA: blob}
+	}
+
+	dir := func(name string, entries ...TreeEntry) TreeEntry {
+		return TreeEntry{Mode: "40000", Name: name, SHA: writeRawTree(t, repo, entries...)}
+	}
+
+	tests := []struct {
+		name    string
+		entries []TreeEntry
+	}{
+		{"dot dot", []TreeEntry{dir("..", file("escaped.txt"))}},
+		{"dot", []TreeEntry{dir(".", file("here.txt"))}},
+		{"empty name", []TreeEntry{file("")}},
+		{"slash in the name", []TreeEntry{file("../escaped.txt")}},
+		{".git", []TreeEntry{dir(".git", file("config"))}},
+		{".git in another case", []TreeEntry{dir(".GiT", dir("hooks", file("post-checkout")))}},
+		{"nested .git", []TreeEntry{dir("sub", dir(".git", file("config")))}},
+		{"duplicate", []TreeEntry{file("a"), dir("a", file("b"))}},
+	}
+
+	for _, test := range tests {
+		tree := writeRawTree(t, repo, append([]TreeEntry{file("ok.txt")}, test.entries...)...)
+
+		if err := repo.SwitchTree("", tree); err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Errorf("%s: got %v, want an invalid path error", test.name, err)
+		}
+
+		// nothing at all is written, not even the good file
+		for _, path := range []string{filepath.Join(repo.WorkTree, "ok.txt"), filepath.Join(filepath.Dir(repo.WorkTree), "escaped.txt"), filepath.Join(repo.GitDir, "config", "config")} {
+			if _, err := os.Lstat(path); err == nil {
+				t.Errorf("%s: %s was written", test.name, path)
+			}
+		}
+	}
+
+	tree := writeRawTree(t, repo, file(".gitignore"), dir("git", file("..a")), file("..."))
+
+	if err := repo.SwitchTree("", tree); err != nil {
+		t.Errorf("names that only look like . and .git: %v", err)
+	}
+
+	if _, err := repo.checkoutEntry(file("../escaped.txt")); err == nil {
+		t.Errorf("checkoutEntry wrote outside the work tree")
+	}
+}
+
+func TestVerifyPath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"dir/.gitignore", true},
+		{"dir/.gitx/a", true},
+		{"..a/b..", true},
+		{"", false},
+		{"/abs", false},
+		{"a//b", false},
+		{"a/", false},
+		{"./a", false},
+		{"a/../b", false},
+		{".git/config", false},
+		{"sub/.Git/hooks/pre-commit", false},
+	}
+
+	for _, test := range tests {
+		if err := verifyPath(test.path); (err == nil) != test.ok {
+			t.Errorf("verifyPath(%q) = %v, want ok %v", test.path, err, test.ok)
+		}
+	}
+}
@@ -1,12 +1,11 @@
 package helper
 
 import (
+	"bufio"
 	"bytes"
-	"compress/zlib"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 )
@@ -28,8 +27,9 @@ func ParsePacketLines(data []byte) []string {
 		if err != nil {
 			break
 		}
-		// Length of 0 ("0000") indicates a flush packet
-		if length == 0 {
+		// Length of 0 ("0000") indicates a flush packet, protocol v2 adds
+		// 0001 (delimiter) and 0002 (response end) which carry nothing either
+		if length < 4 {
 			pointer += 4
 			continue
 		}
@@ -38,6 +38,10 @@ func ParsePacketLines(data []byte) []string {
 		contentStart := pointer + 4
 		contentEnd := pointer + int(length)
 
+		if contentEnd > len(data) {
+			break
+		}
+
 		line := string(data[contentStart:contentEnd])
 
 		lines = append(lines, line)
@@ -48,30 +52,131 @@ func ParsePacketLines(data []byte) []string {
 	return lines
 }
 
-func RetrieveMainSHA(lines []string) (string, error) {
+// the opposite of ParsePacketLines, the length includes its own 4 bytes
+//
+//	want 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd\n -> 0032want 47b37f1a82bfe85f6d8df52b6258b75e4343b7fd\n
+func FormatPacketLine(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "# service=git-upload-pack") {
-			continue
-		}
+// pkt-lines read one at a time from a response as it arrives, the pack
+// that follows them is never held in memory as a whole
+type PacketReader struct {
+	reader *bufio.Reader
+}
+
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{reader: bufio.NewReaderSize(r, 65520)}
+}
 
-		// for logging purpose, can ignore
-		if strings.Contains(line, "symref=HEAD:") {
-			parts := strings.Split(line, "symref=HEAD:")
-			defaultBranch := strings.Split(parts[1], " ")[0]
+// the payload of the next pkt-line. the length only packets (flush,
+// delimiter, response end) have no payload and their length as kind,
+// kind is -1 for the others. io.EOF when the response ends between packets
+func (p *PacketReader) ReadPacket() ([]byte, int, error) {
+	var length [4]byte
 
-			fmt.Println("Default branch is ", defaultBranch)
+	if _, err := io.ReadFull(p.reader, length[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, fmt.Errorf("pkt-line: %w", ErrTruncatedPack)
 		}
 
-		if strings.Contains(line, "HEAD") {
-			sha := line[:40]
-			return sha, nil
+		return nil, 0, err
+	}
+
+	packetLength, err := strconv.ParseInt(string(length[:]), 16, 32)
 
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid pkt-line length: %w", err)
+	}
+
+	if packetLength < 4 {
+		return nil, int(packetLength), nil
+	}
+
+	payload := make([]byte, packetLength-4)
+
+	if _, err := io.ReadFull(p.reader, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, fmt.Errorf("pkt-line: %w", ErrTruncatedPack)
 		}
 
+		return nil, 0, err
 	}
 
-	return "", fmt.Errorf("no main ref found")
+	return payload, -1, nil
+}
+
+// the next n bytes without reading them, fewer and an error at the end
+func (p *PacketReader) Peek(n int) ([]byte, error) {
+	return p.reader.Peek(n)
+}
+
+// what comes after the packets read so far, as it is
+func (p *PacketReader) Read(b []byte) (int, error) {
+	return p.reader.Read(b)
+}
+
+// the answer of a v0 upload-pack to the final request
+//
+//	s: 0008NAK                or with multi_ack_detailed
+//	s: PACK<header><objects>  s: 0031ACK <hash> common
+//	                          s: 0031ACK <hash>
+//	                          s: PACK<header><objects>
+//
+// every pkt-line before the pack is skipped. with side-band-64k the pack
+// comes in pkt-lines too, the first one that starts with a band byte.
+// a deepen request is answered first, with shallow and unshallow lines
+// and a flush. the pack is left in the body to be read as it arrives
+func ParseUploadPackResponse(body io.ReadCloser, sideBand bool, progress io.Writer) (*FetchResponse, error) {
+	response, err := parseUploadPackResponse(NewPacketReader(body), sideBand, progress)
+
+	return holdResponseBody(response, err, body)
+}
+
+func parseUploadPackResponse(packets *PacketReader, sideBand bool, progress io.Writer) (*FetchResponse, error) {
+	response := &FetchResponse{}
+
+	for {
+		head, _ := packets.Peek(5)
+
+		if !sideBand && bytes.HasPrefix(head, []byte("PACK")) {
+			response.Pack = packets.reader
+			return response, nil
+		}
+
+		if sideBand && len(head) == 5 && string(head[:4]) != "0000" && head[4] >= 1 && head[4] <= 3 {
+			response.Pack = NewSideBandReader(packets, progress)
+			return response, nil
+		}
+
+		payload, kind, err := packets.ReadPacket()
+
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("invalid upload-pack response: no pack data")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload-pack response: %w", err)
+		}
+
+		if kind != -1 {
+			continue
+		}
+
+		line := strings.TrimSpace(string(payload))
+
+		if message, found := strings.CutPrefix(line, "ERR "); found {
+			return nil, fmt.Errorf("remote error: %s", message)
+		}
+
+		if sha, found := strings.CutPrefix(line, "shallow "); found {
+			response.Shallow = append(response.Shallow, sha)
+		}
+
+		if sha, found := strings.CutPrefix(line, "unshallow "); found {
+			response.Unshallow = append(response.Unshallow, sha)
+		}
+	}
 }
 
 // 7 	-> 0000 0111
@@ -86,6 +191,10 @@ func RetrieveMainSHA(lines []string) (string, error) {
 // T (3 bits) = Type of the object
 // L (4 bits) = Length of the object (only in the first byte, more bits may follow)
 func ReadObjectHeader(data []byte) (string, int64, int, error) {
+	if len(data) == 0 {
+		return "", 0, 0, fmt.Errorf("premature end of header data")
+	}
+
 	objectType := (data[0] >> 4) & 7
 	size := int64(data[0] & 15)
 
@@ -133,28 +242,6 @@ func ReadObjectHeader(data []byte) (string, int64, int, error) {
 	return typeStr, size, headerOffset, nil
 }
 
-func WriteObject(hash [20]byte, blob []byte) error {
-
-	err := os.MkdirAll(fmt.Sprintf(".git/objects/%x/", hash[:1]), 0755)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	compressed := bytes.Buffer{}
-	writer := zlib.NewWriter(&compressed)
-	writer.Write(blob)
-	writer.Close()
-
-	err = os.WriteFile(fmt.Sprintf(".git/objects/%x/%x", hash[:1], hash[1:]), compressed.Bytes(), 0644)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-
-	return nil
-}
-
 //	SLLL LLLL
 //
 // S (1 bit) = Size extension bit (indicates if more length bytes follow)
@@ -181,44 +268,43 @@ func readSize(packfile []byte) (uint64, int, error) {
 	return size, offset, nil
 }
 
-func OpenObject(objectName string) ([]byte, string, error) {
-	file, err := os.Open(fmt.Sprintf(".git/objects/%s/%s", objectName[:2], objectName[2:]))
-
-	if err != nil {
-		return nil, "", err
-	}
-
-	defer file.Close()
-
-	reader, err := zlib.NewReader(file)
-
-	if err != nil {
-		return nil, "", err
+// the offset of an ofs-delta base is encoded as a big endian varint
+//
+//	SOOO OOOO
+//
+// S (1 bit) = continuation bit (indicates if more offset bytes follow)
+// O (7 bits) = offset bits, most significant group first
+//
+// unlike readSize, every continuation adds one before shifting so that
+// there is only one way to encode each offset, from gitformat-pack.txt:
+//
+//	offset encoding:
+//	  n bytes with MSB set in all but the last one.
+//	  The offset is then the number constructed by
+//	  concatenating the lower 7 bit of each byte, and
+//	  for n >= 2 adding 2^7 + 2^14 + ... + 2^(7*(n-1))
+//	  to the result.
+func ReadDeltaOffset(packfile []byte) (int64, int, error) {
+	if len(packfile) == 0 {
+		return 0, 0, fmt.Errorf("premature end of delta offset")
 	}
 
-	defer reader.Close()
-
-	data, err := io.ReadAll(reader)
-
-	if err != nil {
-		return nil, "", err
-	}
+	offset := 0
+	data := packfile[offset]
+	deltaOffset := int64(data & 0x7F)
+	offset++
 
-	// Find header end
-	headerEnd := bytes.IndexByte(data, '\000')
+	for data&0x80 != 0 {
+		if offset >= len(packfile) {
+			return 0, 0, fmt.Errorf("premature end of delta offset")
+		}
 
-	if headerEnd == -1 {
-		return nil, "", errors.New("invalid object header")
+		data = packfile[offset]
+		deltaOffset = ((deltaOffset + 1) << 7) | int64(data&0x7F)
+		offset++
 	}
 
-	var (
-		objectType string
-		size       int
-	)
-	// <object type> <length>
-	fmt.Sscanf(string(data[:headerEnd]), "%s %d", &objectType, &size)
-
-	return data[headerEnd+1:], objectType, nil
+	return deltaOffset, offset, nil
 }
 
 // instruction: <base object size> <expected object size>
@@ -284,6 +370,10 @@ func BuildDeltaObject(baseObject []byte, buildInstruction []byte) ([]byte, error
 			var props uint64
 			for bit := 0; bit < 7; bit++ {
 				if opcode&(1<<bit) != 0 {
+					if offset >= len(buildInstruction) {
+						return nil, errors.New("delta copy instruction is truncated")
+					}
+
 					currentInstructionByte := buildInstruction[offset]
 					currentInstruction := uint64(currentInstructionByte)
 
@@ -301,6 +391,15 @@ func BuildDeltaObject(baseObject []byte, buildInstruction []byte) ([]byte, error
 			// mask = 11111111 11111111 11111111
 			sizeOfObjectToCopy := (props >> 32) & 0xFFFFFF // extract upper 32 bits
 
+			// a size of zero means 0x10000, the largest copy a single instruction can do
+			if sizeOfObjectToCopy == 0 {
+				sizeOfObjectToCopy = 0x10000
+			}
+
+			if startIndexToCopy+sizeOfObjectToCopy > uint64(len(baseObject)) {
+				return nil, errors.New("delta copy instruction reads past the end of the base object")
+			}
+
 			buffer.Write(baseObject[startIndexToCopy : startIndexToCopy+sizeOfObjectToCopy])
 		} else {
 			// insert instruction : insert from the instruction arg
@@ -315,6 +414,12 @@ func BuildDeltaObject(baseObject []byte, buildInstruction []byte) ([]byte, error
 
 			// size is last 7 bits
 			size := int(opcode & 0x7F)
+
+			// opcode 0 is reserved
+			if size == 0 || offset+size > len(buildInstruction) {
+				return nil, errors.New("invalid delta insert instruction")
+			}
+
 			buffer.Write(buildInstruction[offset : offset+size])
 			offset += size
 		}
@@ -0,0 +1,166 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// the size varint of a delta header, 7 bits at a time, least significant first
+func deltaSize(size int) []byte {
+	encoded := []byte{}
+
+	for size >= 0x80 {
+		encoded = append(encoded, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+
+	return append(encoded, byte(size))
+}
+
+func delta(baseSize int, resultSize int, instructions ...byte) []byte {
+	return append(append(deltaSize(baseSize), deltaSize(resultSize)...), instructions...)
+}
+
+func TestBuildDeltaObject(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 0x1000+1)
+
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+		want  []byte
+	}{
+		{
+			name:  "insert",
+			base:  []byte("hello"),
+			delta: delta(5, 3, 0x03, 'a', 'b', 'c'),
+			want:  []byte("abc"),
+		},
+		{
+			// offset1 and size1 present
+			name:  "copy",
+			base:  []byte("hello world"),
+			delta: delta(11, 5, 0x91, 6, 5),
+			want:  []byte("world"),
+		},
+		{
+			name:  "copy from offset zero without offset bytes",
+			base:  []byte("hello world"),
+			delta: delta(11, 5, 0x90, 5),
+			want:  []byte("hello"),
+		},
+		{
+			name:  "copy and insert",
+			base:  []byte("hello world"),
+			delta: delta(11, 12, 0x90, 5, 0x01, ',', 0x91, 5, 6),
+			want:  []byte("hello, world"),
+		},
+		{
+			// offset2 only, offset1 is zero
+			name:  "sparse offset bytes",
+			base:  big,
+			delta: delta(len(big), 4, 0x92, 0x01, 4),
+			want:  big[0x100:0x104],
+		},
+		{
+			// no size bytes at all is the largest copy, 0x10000 bytes
+			name:  "copy of 0x10000 bytes",
+			base:  big,
+			delta: delta(len(big), 0x10000, 0x80),
+			want:  big[:0x10000],
+		},
+	}
+
+	for _, test := range tests {
+		got, err := BuildDeltaObject(test.base, test.delta)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBuildDeltaObjectErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+	}{
+		{"base size mismatch", []byte("hello"), delta(4, 3, 0x03, 'a', 'b', 'c')},
+		{"result size mismatch", []byte("hello"), delta(5, 4, 0x03, 'a', 'b', 'c')},
+		{"copy past the base", []byte("hello"), delta(5, 5, 0x91, 3, 5)},
+		{"truncated copy", []byte("hello"), delta(5, 5, 0x91, 3)},
+		{"insert past the delta", []byte("hello"), delta(5, 3, 0x05, 'a', 'b')},
+		{"reserved opcode zero", []byte("hello"), delta(5, 0, 0x00)},
+	}
+
+	for _, test := range tests {
+		if got, err := BuildDeltaObject(test.base, test.delta); err == nil {
+			t.Errorf("%s: got %q, want an error", test.name, got)
+		}
+	}
+}
+
+func TestReadDeltaOffset(t *testing.T) {
+	tests := []struct {
+		encoded  []byte
+		want     int64
+		consumed int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x7f}, 127, 1},
+		// two bytes start at 2^7: (0 + 1) << 7 | 0
+		{[]byte{0x80, 0x00}, 128, 2},
+		{[]byte{0x80, 0x7f}, 255, 2},
+		{[]byte{0x81, 0x00}, 256, 2},
+		{[]byte{0xff, 0x7f}, 16511, 2},
+		{[]byte{0x80, 0x80, 0x00}, 16512, 3},
+		// the bytes after the offset are left alone
+		{[]byte{0x05, 0xff}, 5, 1},
+	}
+
+	for _, test := range tests {
+		got, consumed, err := ReadDeltaOffset(test.encoded)
+
+		if err != nil || got != test.want || consumed != test.consumed {
+			t.Errorf("ReadDeltaOffset(%x) = %d, %d, %v, want %d, %d", test.encoded, got, consumed, err, test.want, test.consumed)
+		}
+	}
+
+	for _, truncated := range [][]byte{{}, {0x80}, {0xff, 0xff}} {
+		if _, _, err := ReadDeltaOffset(truncated); err == nil {
+			t.Errorf("ReadDeltaOffset(%x) succeeded, want an error", truncated)
+		}
+	}
+}
+
+func TestParsePacketLines(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"lines and flush", "001e# service=git-upload-pack\n0000" + "0008NAK\n0000", []string{"# service=git-upload-pack\n", "NAK\n"}},
+		{"v2 delimiter and response end", "0009peel\n0001000csymrefs\n0002", []string{"peel\n", "symrefs\n"}},
+		{"cut short", "0008NAK\n0020ACK", []string{"NAK\n"}},
+		{"empty", "", nil},
+	}
+
+	for _, test := range tests {
+		got := ParsePacketLines([]byte(test.data))
+
+		if len(got) != len(test.want) || len(got) > 0 && strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: ParsePacketLines = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if got := FormatPacketLine("want " + strings.Repeat("a", 40) + "\n"); got[:4] != "0032" {
+		t.Errorf("FormatPacketLine length %s, want 0032", got[:4])
+	}
+}
@@ -0,0 +1,187 @@
+package helper
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tree 0f99f9c5b83b010cfbd67870502df7b293ec0e37
+// parent 40c614ba65a7faf2c97a52a2fa74568dabc49ebb
+// author Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530
+// committer Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530
+//
+// <message>
+type Commit struct {
+	Tree      string
+	Parents   []string
+	Author    string
+	Committer string
+	Message   string
+}
+
+func ParseCommit(data []byte) (*Commit, error) {
+	headers, message, _ := bytes.Cut(data, []byte("\n\n"))
+	commit := &Commit{Message: string(message)}
+
+	for _, line := range strings.Split(string(headers), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "author":
+			commit.Author = value
+		case "committer":
+			commit.Committer = value
+		}
+	}
+
+	if !IsObjectName(commit.Tree) {
+		return nil, errors.New("invalid commit: missing tree")
+	}
+
+	return commit, nil
+}
+
+func (r *Repository) ReadCommit(commitHash string) (*Commit, error) {
+	data, objectType, err := r.Objects.Read(commitHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if objectType != "commit" {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", commitHash, objectType)
+	}
+
+	commit, err := ParseCommit(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the parents of a shallow commit were never fetched
+	if r.IsShallow(commitHash) {
+		commit.Parents = nil
+	}
+
+	return commit, nil
+}
+
+// when the commit was made, the committer date like git log --date-order uses
+func (c *Commit) Time() time.Time {
+	committer, err := ParseSignature(c.Committer)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return committer.When
+}
+
+// CommitWalker hands out the history of some commits newest first,
+// every commit once, like git rev-list --date-order. Hide marks a commit
+// and everything reachable from it as not wanted anymore
+type CommitWalker struct {
+	repo    *Repository
+	queue   commitQueue
+	seen    map[string]bool
+	hidden  map[string]bool
+	parents map[string][]string
+}
+
+type queuedCommit struct {
+	sha  string
+	when time.Time
+}
+
+// a max-heap on the commit date
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int           { return len(q) }
+func (q commitQueue) Less(i, j int) bool { return q[i].when.After(q[j].when) }
+func (q commitQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)        { *q = append(*q, x.(queuedCommit)) }
+
+func (q *commitQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return item
+}
+
+func (r *Repository) NewCommitWalker(starts []string) (*CommitWalker, error) {
+	walker := &CommitWalker{
+		repo:    r,
+		seen:    map[string]bool{},
+		hidden:  map[string]bool{},
+		parents: map[string][]string{},
+	}
+
+	for _, sha := range starts {
+		if err := walker.push(sha); err != nil {
+			return nil, err
+		}
+	}
+
+	return walker, nil
+}
+
+func (w *CommitWalker) push(sha string) error {
+	if w.seen[sha] {
+		return nil
+	}
+
+	w.seen[sha] = true
+
+	commit, err := w.repo.ReadCommit(sha)
+
+	if err != nil {
+		return err
+	}
+
+	w.parents[sha] = commit.Parents
+	heap.Push(&w.queue, queuedCommit{sha: sha, when: commit.Time()})
+
+	return nil
+}
+
+// the next commit, empty once the history is exhausted
+func (w *CommitWalker) Next() (string, error) {
+	for w.queue.Len() > 0 {
+		sha := heap.Pop(&w.queue).(queuedCommit).sha
+
+		for _, parent := range w.parents[sha] {
+			if w.hidden[sha] {
+				w.hidden[parent] = true
+			}
+
+			if err := w.push(parent); err != nil {
+				return "", err
+			}
+		}
+
+		if !w.hidden[sha] {
+			return sha, nil
+		}
+	}
+
+	return "", nil
+}
+
+// stop handing out sha and its ancestors, the ones still
+// waiting in the queue inherit the mark when they come out
+func (w *CommitWalker) Hide(sha string) {
+	w.hidden[sha] = true
+
+	for _, parent := range w.parents[sha] {
+		w.hidden[parent] = true
+	}
+}
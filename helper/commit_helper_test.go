@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+// a commit of the empty tree made at unix time when
+func commitAt(t *testing.T, repo *Repository, when int64, parents ...string) string {
+	t.Helper()
+
+	tree, err := repo.Objects.Write("tree", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := Signature{"A U Thor", "author@example.com", time.Unix(when, 0).UTC()}
+	commit, err := repo.CommitTree(tree, parents, "commit", signature, signature)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commit
+}
+
+func TestCommitWalker(t *testing.T) {
+	repo := newTestRepository(t)
+
+	//   c1 - c2 - c3 - c5
+	//          \
+	//           c4
+	c1 := commitAt(t, repo, 1000)
+	c2 := commitAt(t, repo, 2000, c1)
+	c3 := commitAt(t, repo, 3000, c2)
+	c4 := commitAt(t, repo, 4000, c2)
+	c5 := commitAt(t, repo, 5000, c3)
+
+	walk := func(starts []string, hideAfter string, hide string) []string {
+		walker, err := repo.NewCommitWalker(starts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shas := []string{}
+
+		for {
+			sha, err := walker.Next()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sha == "" {
+				return shas
+			}
+
+			shas = append(shas, sha)
+
+			if sha == hideAfter {
+				walker.Hide(hide)
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		starts    []string
+		hideAfter string
+		hide      string
+		want      []string
+	}{
+		{"newest first", []string{c5, c4}, "", "", []string{c5, c4, c3, c2, c1}},
+		{"every commit once", []string{c4, c5, c4, c3}, "", "", []string{c5, c4, c3, c2, c1}},
+		{"one branch", []string{c4}, "", "", []string{c4, c2, c1}},
+		{"hide the ancestors", []string{c5, c4}, c5, c3, []string{c5, c4}},
+		{"hide one side", []string{c5, c4}, c4, c4, []string{c5, c4, c3}},
+	}
+
+	for _, test := range tests {
+		got := walk(test.starts, test.hideAfter, test.hide)
+
+		if len(got) != len(test.want) {
+			t.Errorf("%s: walked %d commits %v, want %v", test.name, len(got), got, test.want)
+			continue
+		}
+
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: walked %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package helper
 
 import (
-	"bytes"
-	"compress/zlib"
 	"crypto/sha1"
 	"fmt"
-	"io"
-	"os"
+	"runtime"
+	"sync"
 )
 
 func ArrayContains[T comparable](arr []T, item T) bool {
@@ -18,28 +16,6 @@ func ArrayContains[T comparable](arr []T, item T) bool {
 	return false
 }
 
-// get the object before compression
-func ProcessObject(packfile []byte) (int, []byte, error) {
-	b := bytes.NewReader(packfile)
-	r, err := zlib.NewReader(b)
-	if err != nil {
-		return 0, nil, err
-	}
-	defer r.Close()
-	object, err := io.ReadAll(r)
-
-	if err != nil {
-		return 0, nil, err
-	}
-
-	totalPackfileSize := len(packfile)
-	remainingBytes := b.Len() // number of unread bytes
-
-	processedOffset := int(totalPackfileSize) - remainingBytes
-
-	return processedOffset, object, nil
-}
-
 func GetObjectSHA(blob []byte, objectType string) ([20]byte, []byte) {
 	header := fmt.Sprintf("%s %d\000", objectType, len(blob))
 	fullContent := append([]byte(header), blob...)
@@ -49,28 +25,57 @@ func GetObjectSHA(blob []byte, objectType string) ([20]byte, []byte) {
 	return hash, fullContent
 }
 
-func SaveBlob(hash [20]byte, blob []byte) error {
-	// create dir
-	err := os.MkdirAll(fmt.Sprintf(".git/objects/%x/", hash[:1]), 0755)
-	if err != nil {
-		fmt.Println(err)
-		return err
+// runs the tasks on up to jobs goroutines, one per CPU when jobs is 0.
+// the first error is returned once the running tasks are done, the ones
+// not started yet are skipped
+func RunJobs(jobs int, tasks []func() error) error {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
 	}
 
-	// compress file content
-	compressed := bytes.Buffer{}
-	writer := zlib.NewWriter(&compressed)
-	writer.Write(blob)
-	writer.Close()
+	if jobs > len(tasks) {
+		jobs = len(tasks)
+	}
 
-	fullPath := fmt.Sprintf(".git/objects/%x/%x", hash[:1], hash[1:])
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		next     int
+		firstErr error
+	)
 
-	err = os.WriteFile(fullPath, compressed.Bytes(), 0644)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				mu.Lock()
+
+				if firstErr != nil || next == len(tasks) {
+					mu.Unlock()
+					return
+				}
+
+				task := tasks[next]
+				next++
+				mu.Unlock()
+
+				if err := task(); err != nil {
+					mu.Lock()
+
+					if firstErr == nil {
+						firstErr = err
+					}
+
+					mu.Unlock()
+				}
+			}
+		}()
 	}
 
-	// fmt.Println("data is saved to ", fullPath)
-	return nil
+	wg.Wait()
+
+	return firstErr
 }
@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunJobs(t *testing.T) {
+	for _, jobs := range []int{0, 1, 3, 100} {
+		var mu sync.Mutex
+		var running, peak int32
+		done := make([]bool, 20)
+		tasks := []func() error{}
+
+		for i := range done {
+			tasks = append(tasks, func() error {
+				now := atomic.AddInt32(&running, 1)
+
+				mu.Lock()
+				peak = max(peak, now)
+				done[i] = true
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+
+				return nil
+			})
+		}
+
+		if err := RunJobs(jobs, tasks); err != nil {
+			t.Errorf("RunJobs(%d) = %v", jobs, err)
+		}
+
+		for i, ran := range done {
+			if !ran {
+				t.Errorf("RunJobs(%d) skipped task %d", jobs, i)
+			}
+		}
+
+		if jobs > 0 && int(peak) > jobs {
+			t.Errorf("RunJobs(%d) ran %d tasks at once", jobs, peak)
+		}
+	}
+
+	if err := RunJobs(4, nil); err != nil {
+		t.Errorf("RunJobs without tasks = %v", err)
+	}
+}
+
+func TestRunJobsError(t *testing.T) {
+	failed := errors.New("task failed")
+	var started int32
+	tasks := []func() error{}
+
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, func() error {
+			atomic.AddInt32(&started, 1)
+
+			if i == 1 {
+				return failed
+			}
+
+			return nil
+		})
+	}
+
+	// one goroutine takes the tasks in order, nothing starts after the error
+	if err := RunJobs(1, tasks); !errors.Is(err, failed) || started != 2 {
+		t.Errorf("RunJobs = %v after %d tasks, want %v after 2", err, started, failed)
+	}
+}
@@ -0,0 +1,364 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// one "key = value" line of a config file, name is the full dotted
+// name with the section and key lowercased: user.name, remote.origin.url
+type ConfigEntry struct {
+	Name  string
+	Value string
+}
+
+// git config files, later entries win over earlier ones
+//
+//	[user]
+//		name = Paul Kuruvilla
+//	[remote "origin"]
+//		url = https://github.com/codecrafters-io/git-sample-1
+//		fetch = +refs/heads/*:refs/remotes/origin/*
+type Config struct {
+	Entries []ConfigEntry
+}
+
+func (c *Config) Get(name string) (string, bool) {
+	values := c.GetAll(name)
+
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[len(values)-1], true
+}
+
+// git's booleans, true/yes/on/1 and false/no/off/0 in any case. an
+// unset name or a value that is neither gives defaultValue
+func (c *Config) GetBool(name string, defaultValue bool) bool {
+	value, ok := c.Get(name)
+
+	if !ok {
+		return defaultValue
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0", "":
+		return false
+	default:
+		return defaultValue
+	}
+}
+
+func (c *Config) GetAll(name string) []string {
+	name = normalizeConfigName(name)
+	values := []string{}
+
+	for _, entry := range c.Entries {
+		if entry.Name == name {
+			values = append(values, entry.Value)
+		}
+	}
+
+	return values
+}
+
+// section and key are case insensitive, the subsection is not
+func normalizeConfigName(name string) string {
+	firstDot := strings.Index(name, ".")
+	lastDot := strings.LastIndex(name, ".")
+
+	if firstDot == -1 {
+		return strings.ToLower(name)
+	}
+
+	return strings.ToLower(name[:firstDot]) + name[firstDot:lastDot] + strings.ToLower(name[lastDot:])
+}
+
+// a missing file is an empty config
+func ReadConfigFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	config := &Config{}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+
+			if end == -1 {
+				return nil, fmt.Errorf("bad config line %d in file %s", lineNumber, path)
+			}
+
+			section = parseConfigSection(line[1:end])
+			rest := strings.TrimSpace(line[end+1:])
+
+			// [section] key = value on the same line
+			if rest == "" || rest[0] == '#' || rest[0] == ';' {
+				continue
+			}
+
+			line = rest
+		}
+
+		if section == "" {
+			return nil, fmt.Errorf("bad config line %d in file %s", lineNumber, path)
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		// a key without a value is a boolean true
+		if !found {
+			value = "true"
+		} else {
+			value = parseConfigValue(value)
+		}
+
+		config.Entries = append(config.Entries, ConfigEntry{Name: section + "." + strings.ToLower(key), Value: value})
+	}
+
+	return config, scanner.Err()
+}
+
+// [remote "origin"] -> remote.origin, [user] -> user
+func parseConfigSection(header string) string {
+	name, subsection, found := strings.Cut(header, " ")
+
+	if !found {
+		// the deprecated [section.subsection] form
+		name, subsection, found = strings.Cut(header, ".")
+
+		if !found {
+			return strings.ToLower(header)
+		}
+
+		return strings.ToLower(name) + "." + strings.ToLower(subsection)
+	}
+
+	subsection = strings.TrimSpace(subsection)
+	subsection = strings.TrimSuffix(strings.TrimPrefix(subsection, "\""), "\"")
+	subsection = strings.NewReplacer("\\\"", "\"", "\\\\", "\\").Replace(subsection)
+
+	return strings.ToLower(name) + "." + subsection
+}
+
+// strip comments and quotes, and handle \n \t \" \\ escapes. whitespace
+// around the value is dropped unless it is inside the quotes
+func parseConfigValue(raw string) string {
+	value := strings.Builder{}
+	quoted := false
+	escaped := false
+	// the length of the value without its unquoted trailing whitespace
+	keep := 0
+
+	for _, char := range strings.TrimSpace(raw) {
+		switch {
+		case escaped:
+			switch char {
+			case 'n':
+				value.WriteRune('\n')
+			case 't':
+				value.WriteRune('\t')
+			default:
+				value.WriteRune(char)
+			}
+
+			escaped = false
+			keep = value.Len()
+		case char == '\\':
+			escaped = true
+		case char == '"':
+			quoted = !quoted
+		case (char == '#' || char == ';') && !quoted:
+			return value.String()[:keep]
+		default:
+			value.WriteRune(char)
+
+			if quoted || (char != ' ' && char != '\t') {
+				keep = value.Len()
+			}
+		}
+	}
+
+	return value.String()[:keep]
+}
+
+// the global config ($XDG_CONFIG_HOME/git/config, then ~/.gitconfig)
+// followed by the repository's own .git/config, which wins
+func (r *Repository) Config() (*Config, error) {
+	paths := []string{}
+
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+
+	if xdgConfigHome == "" && home != "" {
+		xdgConfigHome = filepath.Join(home, ".config")
+	}
+
+	if xdgConfigHome != "" {
+		paths = append(paths, filepath.Join(xdgConfigHome, "git", "config"))
+	}
+
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+
+	paths = append(paths, filepath.Join(r.GitDir, "config"))
+
+	config := &Config{}
+
+	for _, path := range paths {
+		fileConfig, err := ReadConfigFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Entries = append(config.Entries, fileConfig.Entries...)
+	}
+
+	return config, nil
+}
+
+// set name to value in the repository's .git/config like git config <name> <value>,
+// replacing the last existing value or adding the key to its section,
+// which is created at the end of the file when missing
+func (r *Repository) SetConfig(name string, value string) error {
+	configPath := filepath.Join(r.GitDir, "config")
+	content, err := os.ReadFile(configPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lastDot := strings.LastIndex(name, ".")
+
+	if lastDot <= 0 || lastDot == len(name)-1 {
+		return fmt.Errorf("key does not contain a section: %s", name)
+	}
+
+	// the subsection keeps its case, like in normalizeConfigName
+	sectionName, subsection, found := strings.Cut(name[:lastDot], ".")
+	section := strings.ToLower(sectionName)
+
+	if found {
+		section += "." + subsection
+	}
+
+	key := strings.ToLower(name[lastDot+1:])
+	line := fmt.Sprintf("\t%s = %s", key, formatConfigValue(value))
+
+	lines := []string{}
+
+	if len(content) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+
+	currentSection := ""
+	sectionEnd := -1
+	keyLine := -1
+
+	for i, existing := range lines {
+		trimmed := strings.TrimSpace(existing)
+
+		if strings.HasPrefix(trimmed, "[") {
+			if end := strings.LastIndex(trimmed, "]"); end != -1 {
+				currentSection = parseConfigSection(trimmed[1:end])
+			}
+		}
+
+		if currentSection != section {
+			continue
+		}
+
+		sectionEnd = i
+		existingKey, _, _ := strings.Cut(trimmed, "=")
+
+		if !strings.HasPrefix(trimmed, "[") && strings.ToLower(strings.TrimSpace(existingKey)) == key {
+			keyLine = i
+		}
+	}
+
+	switch {
+	case keyLine != -1:
+		lines[keyLine] = line
+	case sectionEnd != -1:
+		lines = append(lines[:sectionEnd+1], append([]string{line}, lines[sectionEnd+1:]...)...)
+	default:
+		lines = append(lines, formatConfigSection(section), line)
+	}
+
+	return writeLockedFile(configPath, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// remote.origin -> [remote "origin"]
+func formatConfigSection(section string) string {
+	name, subsection, found := strings.Cut(section, ".")
+
+	if !found {
+		return "[" + name + "]"
+	}
+
+	subsection = strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(subsection)
+
+	return fmt.Sprintf("[%s \"%s\"]", name, subsection)
+}
+
+// quote values that would not survive parseConfigValue as they are
+func formatConfigValue(value string) string {
+	escaped := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t").Replace(value)
+
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;") {
+		return "\"" + escaped + "\""
+	}
+
+	return escaped
+}
+
+// write through <path>.lock and rename it into place so readers
+// never see a half written file
+func writeLockedFile(path string, content []byte) error {
+	lockPath := path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	defer os.Remove(lockPath)
+
+	_, err = lock.Write(content)
+	lock.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(lockPath, path)
+}
@@ -0,0 +1,141 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	content := "# comment\n" +
+		"[core]\n" +
+		"\tbare = false\n" +
+		"\tFileMode\n" +
+		"[User]\n" +
+		"\tname = \"Paul  Kuruvilla\" ; comment\n" +
+		"\temail = paul@example.com # comment\n" +
+		"[remote \"Origin\"]\n" +
+		"\turl = https://example.com/a.git\n" +
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*\n" +
+		"[branch.main] remote = origin\n" +
+		"[alias]\n" +
+		"\tlog1 = \"log --format=\\\"%h\\\"\\t#1\"\n" +
+		"[user]\n" +
+		"\tname = Override\n"
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfigFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"core.bare", []string{"false"}},
+		{"core.filemode", []string{"true"}},
+		{"user.name", []string{"Paul  Kuruvilla", "Override"}},
+		{"USER.Email", []string{"paul@example.com"}},
+		{"remote.Origin.url", []string{"https://example.com/a.git"}},
+		{"remote.origin.url", []string{}},
+		{"branch.main.remote", []string{"origin"}},
+		{"alias.log1", []string{"log --format=\"%h\"\t#1"}},
+	}
+
+	for _, test := range tests {
+		if got := config.GetAll(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GetAll(%s) = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if name, ok := config.Get("user.name"); !ok || name != "Override" {
+		t.Errorf("Get(user.name) = %q, %v, want the last value", name, ok)
+	}
+
+	for _, bad := range []string{"key = value\n", "[core\n\tbare = true\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ReadConfigFile(path); err == nil {
+			t.Errorf("ReadConfigFile(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	repo := newTestRepository(t)
+
+	settings := [][2]string{
+		{"core.bare", "true"},
+		{"remote.Origin.url", "https://example.com/a.git"},
+		{"remote.Origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		{"user.name", " padded "},
+		{"branch.main.merge", "refs/heads/main"},
+		{"remote.Origin.url", "https://example.com/b.git"},
+		{"alias.hash", "log #1"},
+	}
+
+	for _, setting := range settings {
+		if err := repo.SetConfig(setting[0], setting[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(repo.GitDir, "config"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[core]\n" +
+		"\trepositoryformatversion = 0\n" +
+		"\tfilemode = true\n" +
+		"\tbare = true\n" +
+		"[remote \"Origin\"]\n" +
+		"\turl = https://example.com/b.git\n" +
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*\n" +
+		"[user]\n" +
+		"\tname = \" padded \"\n" +
+		"[branch \"main\"]\n" +
+		"\tmerge = refs/heads/main\n" +
+		"[alias]\n" +
+		"\thash = \"log #1\"\n"
+
+	if string(content) != want {
+		t.Errorf("config\n%s\nwant\n%s", content, want)
+	}
+
+	// what was written reads back the same
+	config, err := ReadConfigFile(filepath.Join(repo.GitDir, "config"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"user.name", "alias.hash", "remote.Origin.url"} {
+		value, _ := config.Get(name)
+		expected := ""
+
+		for _, setting := range settings {
+			if setting[0] == name {
+				expected = setting[1]
+			}
+		}
+
+		if value != expected {
+			t.Errorf("%s reads back as %q, want %q", name, value, expected)
+		}
+	}
+
+	if err := repo.SetConfig("nosection", "x"); err == nil {
+		t.Errorf("SetConfig without a section did not fail")
+	}
+}
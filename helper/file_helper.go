@@ -2,7 +2,6 @@ package helper
 
 import (
 	"compress/zlib"
-	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -66,46 +65,75 @@ func WriteIntoPath(path string, filename string, body []byte) error {
 }
 
 // tree {treeSHa}
-// parent {parentCommitSHA}
-// author {author} <{email}> {currentUnixTime} {timezone}
-// committer {author} <{email}> {currentUnixTime} {timezone}
+// parent {parentCommitSHA}      one line per parent, none for a root commit
+// author {author} <{email}> {unixTime} {+hhmm offset}
+// committer {committer} <{email}> {unixTime} {+hhmm offset}
+//
 // {commitMessage}
-func CommitTree(treeSHA string, parentCommitSHA string, commitMessage string) (string, error) {
-	author := "Foo bar"
-	email := "foo@example.com"
-	currentUnixTime := time.Now().Unix()
-	timezone, _ := time.Now().Local().Zone()
-
-	content := fmt.Sprintf("tree %s\nparent %s\nauthor %s <%s> %d %s\ncommitter %s <%s> %d %s\n\n%s\n",
-		treeSHA,
-		parentCommitSHA,
-		author,
-		email,
-		currentUnixTime,
-		timezone,
-		author,
-		email,
-		currentUnixTime,
-		timezone,
-		commitMessage,
-	)
-
-	header := fmt.Sprintf("commit %d\000", len(content))
-	fullContent := append([]byte(header), []byte(content)...)
-
-	hash := sha1.Sum([]byte(fullContent))
-
-	hashHex := hex.EncodeToString(hash[:])
-
-	objectPath := filepath.Join(".git/objects", hashHex[:2])
-	err := WriteIntoPath(objectPath, hashHex[2:], []byte(fullContent))
+func (r *Repository) CommitTree(treeSHA string, parentCommitSHAs []string, commitMessage string, author Signature, committer Signature) (string, error) {
+	content := fmt.Sprintf("tree %s\n", treeSHA)
+
+	for _, parentCommitSHA := range parentCommitSHAs {
+		content += fmt.Sprintf("parent %s\n", parentCommitSHA)
+	}
+
+	content += fmt.Sprintf("author %s\ncommitter %s\n\n%s\n", author, committer, commitMessage)
+
+	return r.Objects.Write("commit", []byte(content))
+}
+
+// the author and committer of a new commit, --author "Name <email>"
+// and --date override the author only, like in git
+func (r *Repository) CommitSignatures(authorOverride string, dateOverride string) (Signature, Signature, error) {
+	author := Signature{}
+	var err error
+
+	if authorOverride != "" {
+		author.Name, author.Email, err = ParseIdentity(authorOverride)
+		author.When = time.Now()
+
+		if date := os.Getenv("GIT_AUTHOR_DATE"); err == nil && date != "" {
+			author.When, err = ParseDate(date)
+		}
+	} else {
+		author, err = r.Identity("author")
+	}
 
 	if err != nil {
-		return "", err
+		return Signature{}, Signature{}, err
+	}
+
+	if dateOverride != "" {
+		author.When, err = ParseDate(dateOverride)
+
+		if err != nil {
+			return Signature{}, Signature{}, err
+		}
+	}
+
+	committer, err := r.Identity("committer")
+
+	if err != nil {
+		return Signature{}, Signature{}, err
+	}
+
+	return author, committer, nil
+}
+
+// the tree of what is staged, a repository that has never had an
+// index falls back to the working directory
+func (r *Repository) WriteCurrentTree() (string, error) {
+	if !r.HasIndex() {
+		return r.WriteTree(r.WorkTree)
 	}
 
-	return hashHex, nil
+	index, err := r.ReadIndex()
+
+	if err != nil {
+		return "", err
+	}
 
+	return r.WriteIndexTree(index)
 }
 
 // tree format is
@@ -113,7 +141,7 @@ func CommitTree(treeSHA string, parentCommitSHA string, commitMessage string) (s
 //	tree <size>\0
 //	<mode> <name>\0<20_byte_sha>
 //	<mode> <name>\0<20_byte_sha>
-func WriteTree(currentPath string) (string, error) {
+func (r *Repository) WriteTree(currentPath string) (string, error) {
 	entries := []TreeEntry{}
 
 	files, err := os.ReadDir(currentPath)
@@ -125,14 +153,14 @@ func WriteTree(currentPath string) (string, error) {
 	for _, file := range files {
 		name := file.Name()
 
-		if name == ".git" {
+		fullPath := filepath.Join(currentPath, name)
+
+		if name == ".git" || fullPath == r.GitDir {
 			continue
 		}
 
-		fullPath := filepath.Join(currentPath, name)
-
 		if file.IsDir() {
-			hash, err := WriteTree(fullPath)
+			hash, err := r.WriteTree(fullPath)
 
 			if err != nil {
 				return "", err
@@ -145,7 +173,7 @@ func WriteTree(currentPath string) (string, error) {
 			})
 
 		} else {
-			hash, err := writeBlob(fullPath)
+			hash, err := r.writeBlob(fullPath)
 
 			if err != nil {
 				return "", err
@@ -159,9 +187,27 @@ func WriteTree(currentPath string) (string, error) {
 		}
 	}
 
+	return r.writeTreeEntries(entries)
+}
+
+// git sorts tree entries as if directory names ended with a "/",
+// so "a.txt" comes before the directory "a"
+func sortTreeEntries(entries []TreeEntry) {
+	sortKey := func(entry TreeEntry) string {
+		if entry.Mode == "40000" {
+			return entry.Name + "/"
+		}
+
+		return entry.Name
+	}
+
 	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Name < entries[j].Name
+		return sortKey(entries[i]) < sortKey(entries[j])
 	})
+}
+
+func (r *Repository) writeTreeEntries(entries []TreeEntry) (string, error) {
+	sortTreeEntries(entries)
 
 	// tree <size>\0
 	// <mode> <name>\0<20_byte_sha>
@@ -180,58 +226,16 @@ func WriteTree(currentPath string) (string, error) {
 		treeContent = append(treeContent, hashBytes...)
 	}
 
-	header := fmt.Sprintf("tree %d\000", len(treeContent))
-	fullContent := append([]byte(header), treeContent...)
-
-	hash := sha1.Sum(fullContent)
-	hashHex := hex.EncodeToString(hash[:])
-
-	objectPath := filepath.Join(".git/objects", hashHex[:2])
-	err = WriteIntoPath(objectPath, hashHex[2:], fullContent)
-
-	if err != nil {
-		return "", err
-	}
-
-	return hashHex, nil
+	return r.Objects.Write("tree", treeContent)
 }
 
 // blob <size>\0<content>,
-func writeBlob(path string) (string, error) {
+func (r *Repository) writeBlob(path string) (string, error) {
 	file, err := os.ReadFile(path)
 
 	if err != nil {
 		return "", err
 	}
 
-	header := fmt.Sprintf("blob %d\000", len(file))
-	fullContent := append([]byte(header), file...)
-
-	hash := sha1.Sum(fullContent)
-	hashHex := hex.EncodeToString(hash[:])
-
-	objectPath := filepath.Join(".git/objects", hashHex[:2])
-
-	err = WriteIntoPath(objectPath, hashHex[2:], fullContent)
-
-	if err != nil {
-		return "", err
-	}
-
-	return hashHex, nil
-
-}
-
-func InitialiseGitDirectory() {
-	for _, dir := range []string{".git", ".git/objects", ".git/refs"} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating directory: %s\n", err)
-		}
-	}
-	headFileContents := []byte("ref: refs/heads/main\n")
-	if err := os.WriteFile(".git/HEAD", headFileContents, 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing file: %s\n", err)
-	}
-	fmt.Println("Initialized git directory")
-
+	return r.Objects.Write("blob", file)
 }
@@ -0,0 +1,288 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// who made a commit and when, written as
+//
+//	Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530
+//
+// the timezone is always a numeric offset, git rejects zone names like "UTC"
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), FormatTimezone(s.When))
+}
+
+// the opposite of String, "Name <email> 1587572148 +0530"
+func ParseSignature(signature string) (Signature, error) {
+	end := strings.LastIndex(signature, ">")
+
+	if end == -1 {
+		return Signature{}, fmt.Errorf("malformed signature %q", signature)
+	}
+
+	name, email, err := ParseIdentity(signature[:end+1])
+
+	if err != nil {
+		return Signature{}, err
+	}
+
+	when, err := ParseDate(signature[end+1:])
+
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed signature %q: %w", signature, err)
+	}
+
+	return Signature{Name: name, Email: email, When: when}, nil
+}
+
+// +hhmm or -hhmm
+func FormatTimezone(when time.Time) string {
+	_, offset := when.Zone()
+	sign := '+'
+
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, (offset%3600)/60)
+}
+
+// "Name <email>", the form --author takes
+func ParseIdentity(identity string) (string, string, error) {
+	start := strings.LastIndex(identity, "<")
+	end := strings.LastIndex(identity, ">")
+
+	if start == -1 || end < start {
+		return "", "", fmt.Errorf("malformed identity %q, expected \"Name <email>\"", identity)
+	}
+
+	return strings.TrimSpace(identity[:start]), strings.TrimSpace(identity[start+1 : end]), nil
+}
+
+// the author or committer of a new commit, like git the environment wins over config:
+//
+//	GIT_AUTHOR_NAME / GIT_AUTHOR_EMAIL / GIT_AUTHOR_DATE
+//	GIT_COMMITTER_NAME / GIT_COMMITTER_EMAIL / GIT_COMMITTER_DATE
+//	user.name / user.email, then $EMAIL for the email
+//
+// when none of them say who it is the system user and host stand in, unless
+// user.useConfigOnly is set or the host has no domain to make an email with
+func (r *Repository) Identity(kind string) (Signature, error) {
+	prefix := "GIT_" + strings.ToUpper(kind) + "_"
+
+	config, err := r.Config()
+
+	if err != nil {
+		return Signature{}, err
+	}
+
+	useConfigOnly := config.GetBool("user.useConfigOnly", false)
+	unknown := func(reason string) error {
+		return fmt.Errorf("%s identity unknown\n\n*** Please tell me who you are.\n\nRun\n\n"+
+			"  git config --global user.email \"you@example.com\"\n"+
+			"  git config --global user.name \"Your Name\"\n\nto set your account's default identity.\n"+
+			"Omit --global to set the identity only in this repository.\n\n%s",
+			strings.ToUpper(kind[:1])+kind[1:], reason)
+	}
+
+	email := os.Getenv(prefix + "EMAIL")
+
+	if email == "" {
+		email, _ = config.Get("user.email")
+	}
+
+	if email == "" && useConfigOnly {
+		return Signature{}, unknown("no email was given and auto-detection is disabled")
+	}
+
+	if email == "" {
+		email = os.Getenv("EMAIL")
+	}
+
+	if email == "" {
+		var bogus bool
+		email, bogus = systemEmail()
+
+		if bogus {
+			return Signature{}, unknown(fmt.Sprintf("unable to auto-detect email address (got '%s')", email))
+		}
+	}
+
+	name := os.Getenv(prefix + "NAME")
+
+	if name == "" {
+		name, _ = config.Get("user.name")
+	}
+
+	if name == "" && useConfigOnly {
+		return Signature{}, unknown("no name was given and auto-detection is disabled")
+	}
+
+	if name == "" {
+		name = defaultName()
+	}
+
+	if name == "" {
+		return Signature{}, unknown(fmt.Sprintf("empty ident name (for <%s>) not allowed", email))
+	}
+
+	when := time.Now()
+
+	if date := os.Getenv(prefix + "DATE"); date != "" {
+		when, err = ParseDate(date)
+
+		if err != nil {
+			return Signature{}, fmt.Errorf("invalid %sDATE: %w", prefix, err)
+		}
+	}
+
+	return Signature{Name: name, Email: email, When: when}, nil
+}
+
+// the full name from the passwd entry, or the login name when it has none
+func defaultName() string {
+	current, err := user.Current()
+
+	if err != nil {
+		return os.Getenv("USER")
+	}
+
+	if current.Name != "" {
+		return current.Name
+	}
+
+	return current.Username
+}
+
+// the host's domain may take a dns lookup, it is only looked for when
+// nothing else gives an email and then once. a variable so tests can stand
+// in for the system
+var systemEmail = sync.OnceValues(defaultEmail)
+
+// user@host with the host's domain. git makes up user@host.(none) when
+// there is no domain to be found and refuses to commit with it, so
+// bogus says so
+func defaultEmail() (email string, bogus bool) {
+	username := os.Getenv("USER")
+
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+
+	host, err := os.Hostname()
+
+	if err != nil {
+		return username + "@(none)", true
+	}
+
+	if !strings.Contains(host, ".") {
+		if canonical, err := net.LookupCNAME(host); err == nil && strings.Contains(strings.TrimSuffix(canonical, "."), ".") {
+			host = strings.TrimSuffix(canonical, ".")
+		} else {
+			return username + "@" + host + ".(none)", true
+		}
+	}
+
+	return username + "@" + host, false
+}
+
+var rawDatePattern = regexp.MustCompile(`^@?(\d+)(?:\s+([+-]\d{4}))?$`)
+
+// the date formats git accepts for GIT_*_DATE and --date
+//
+//	1587572148 +0530                 git's internal format, optionally prefixed with @
+//	Thu, 07 Apr 2005 22:13:13 +0200  RFC 2822
+//	2005-04-07T22:13:13+02:00        ISO 8601, the offset is optional (local time)
+//	2005-04-07 22:13:13 +0200
+func ParseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+
+	if match := rawDatePattern.FindStringSubmatch(date); match != nil {
+		seconds, err := strconv.ParseInt(match[1], 10, 64)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		location := time.UTC
+
+		if match[2] != "" {
+			location, err = parseTimezone(match[2])
+
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+
+		return time.Unix(seconds, 0).In(location), nil
+	}
+
+	withZone := []string{
+		time.RFC1123Z,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon Jan 2 15:04:05 2006 -0700",
+		time.RFC3339,
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02 15:04:05-07:00",
+	}
+
+	for _, layout := range withZone {
+		if when, err := time.Parse(layout, date); err == nil {
+			return when, nil
+		}
+	}
+
+	withoutZone := []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+
+	for _, layout := range withoutZone {
+		if when, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return when, nil
+		}
+	}
+
+	return time.Time{}, errors.New("unsupported date format " + strconv.Quote(date))
+}
+
+func parseTimezone(offset string) (*time.Location, error) {
+	hours, err := strconv.Atoi(offset[1:3])
+
+	if err != nil {
+		return nil, err
+	}
+
+	minutes, err := strconv.Atoi(offset[3:5])
+
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := hours*3600 + minutes*60
+
+	if offset[0] == '-' {
+		seconds = -seconds
+	}
+
+	return time.FixedZone("", seconds), nil
+}
@@ -0,0 +1,291 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		date     string
+		unix     int64
+		timezone string
+	}{
+		{"1587572148 +0530", 1587572148, "+0530"},
+		{"@1587572148 -0700", 1587572148, "-0700"},
+		{"1587572148", 1587572148, "+0000"},
+		{"Thu, 07 Apr 2005 22:13:13 +0200", 1112904793, "+0200"},
+		{"Thu, 7 Apr 2005 22:13:13 +0200", 1112904793, "+0200"},
+		{"Thu Apr 7 22:13:13 2005 +0200", 1112904793, "+0200"},
+		{"2005-04-07T22:13:13+02:00", 1112904793, "+0200"},
+		{"2005-04-07T22:13:13-0130", 1112917393, "-0130"},
+		{"2005-04-07 22:13:13 +0200", 1112904793, "+0200"},
+		{"  2005-04-07 20:13:13+00:00 ", 1112904793, "+0000"},
+	}
+
+	for _, test := range tests {
+		when, err := ParseDate(test.date)
+
+		if err != nil {
+			t.Errorf("ParseDate(%q): %v", test.date, err)
+			continue
+		}
+
+		if when.Unix() != test.unix || FormatTimezone(when) != test.timezone {
+			t.Errorf("ParseDate(%q) = %d %s, want %d %s", test.date, when.Unix(), FormatTimezone(when), test.unix, test.timezone)
+		}
+	}
+
+	// without an offset the date is local time
+	if when, err := ParseDate("2005-04-07 22:13:13"); err != nil || when.Location() != time.Local || when.Hour() != 22 {
+		t.Errorf("ParseDate of a local date = %v, %v", when, err)
+	}
+
+	for _, bad := range []string{"", "yesterday", "2005-13-45", "1587572148 +05x0"} {
+		if _, err := ParseDate(bad); err == nil {
+			t.Errorf("ParseDate(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestFormatTimezone(t *testing.T) {
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, "+0000"},
+		{5*3600 + 30*60, "+0530"},
+		{-7 * 3600, "-0700"},
+		{-(3*3600 + 30*60), "-0330"},
+		{14 * 3600, "+1400"},
+	}
+
+	for _, test := range tests {
+		when := time.Unix(0, 0).In(time.FixedZone("", test.offset))
+
+		if got := FormatTimezone(when); got != test.want {
+			t.Errorf("FormatTimezone(%d) = %s, want %s", test.offset, got, test.want)
+		}
+	}
+
+	signature := Signature{"A U Thor", "author@example.com", time.Unix(1587572148, 0).In(time.FixedZone("IST", 5*3600+30*60))}
+
+	if got := signature.String(); got != "A U Thor <author@example.com> 1587572148 +0530" {
+		t.Errorf("signature %s", got)
+	}
+}
+
+func TestParseIdentity(t *testing.T) {
+	tests := []struct {
+		identity string
+		name     string
+		email    string
+	}{
+		{"A U Thor <author@example.com>", "A U Thor", "author@example.com"},
+		{"  Spaced   < spaced@example.com >", "Spaced", "spaced@example.com"},
+		{"No Email <>", "No Email", ""},
+	}
+
+	for _, test := range tests {
+		name, email, err := ParseIdentity(test.identity)
+
+		if err != nil || name != test.name || email != test.email {
+			t.Errorf("ParseIdentity(%q) = %q, %q, %v", test.identity, name, email, err)
+		}
+	}
+
+	for _, bad := range []string{"A U Thor", "A U Thor author@example.com>", "A U Thor >x<"} {
+		if _, _, err := ParseIdentity(bad); err == nil {
+			t.Errorf("ParseIdentity(%q) did not fail", bad)
+		}
+	}
+}
+
+// an identity only comes from what the test sets, not from the
+// environment or the global config of whoever runs it
+func isolateIdentity(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	for _, name := range []string{"NAME", "EMAIL", "DATE"} {
+		t.Setenv("GIT_AUTHOR_"+name, "")
+		t.Setenv("GIT_COMMITTER_"+name, "")
+	}
+
+	t.Setenv("EMAIL", "")
+}
+
+func TestIdentity(t *testing.T) {
+	// the system user and host, without asking the system
+	defer func(previous func() (string, bool)) { systemEmail = previous }(systemEmail)
+	systemEmail = func() (string, bool) { return "user@host.example.com", false }
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		config string
+		want   string
+	}{
+		{
+			"config",
+			nil,
+			"[user]\n\tname = Config Name\n\temail = config@example.com\n",
+			"Config Name <config@example.com>",
+		},
+		{
+			"environment wins over config",
+			map[string]string{"GIT_AUTHOR_NAME": "Env Name", "GIT_AUTHOR_EMAIL": "env@example.com", "EMAIL": "email@example.com"},
+			"[user]\n\tname = Config Name\n\temail = config@example.com\n",
+			"Env Name <env@example.com>",
+		},
+		{
+			"committer variables leave the author alone",
+			map[string]string{"GIT_COMMITTER_NAME": "Committer", "GIT_COMMITTER_EMAIL": "committer@example.com"},
+			"[user]\n\tname = Config Name\n\temail = config@example.com\n",
+			"Config Name <config@example.com>",
+		},
+		{
+			"config wins over $EMAIL",
+			map[string]string{"EMAIL": "email@example.com"},
+			"[user]\n\tname = Config Name\n\temail = config@example.com\n",
+			"Config Name <config@example.com>",
+		},
+		{
+			"$EMAIL without user.email",
+			map[string]string{"EMAIL": "email@example.com"},
+			"[user]\n\tname = Config Name\n",
+			"Config Name <email@example.com>",
+		},
+		{
+			"the system without user.email or $EMAIL",
+			nil,
+			"[user]\n\tname = Config Name\n",
+			"Config Name <user@host.example.com>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isolateIdentity(t)
+			repo := newTestRepository(t)
+
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			if err := os.WriteFile(filepath.Join(repo.GitDir, "config"), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			signature, err := repo.Identity("author")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := signature.Name + " <" + signature.Email + ">"; got != test.want {
+				t.Errorf("author %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	// a host without a domain makes no email
+	systemEmail = func() (string, bool) { return "user@host.(none)", true }
+	isolateIdentity(t)
+	repo := newTestRepository(t)
+
+	if _, err := repo.Identity("author"); err == nil || !strings.Contains(err.Error(), "unable to auto-detect email address (got 'user@host.(none)')") {
+		t.Errorf("a bogus system email: got %v", err)
+	}
+}
+
+func TestIdentityDate(t *testing.T) {
+	isolateIdentity(t)
+	repo := newTestRepository(t)
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_AUTHOR_DATE", "1587572148 +0530")
+
+	signature, err := repo.Identity("author")
+
+	if err != nil || signature.String() != "A U Thor <author@example.com> 1587572148 +0530" {
+		t.Errorf("author %s, %v", signature, err)
+	}
+
+	t.Setenv("GIT_AUTHOR_DATE", "yesterday")
+
+	if _, err := repo.Identity("author"); err == nil || !strings.Contains(err.Error(), "invalid GIT_AUTHOR_DATE") {
+		t.Errorf("a bad GIT_AUTHOR_DATE: got %v", err)
+	}
+}
+
+func TestCommitSignatures(t *testing.T) {
+	isolateIdentity(t)
+	repo := newTestRepository(t)
+	t.Setenv("GIT_AUTHOR_NAME", "A U Thor")
+	t.Setenv("GIT_AUTHOR_EMAIL", "author@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "C O Mitter")
+	t.Setenv("GIT_COMMITTER_EMAIL", "committer@example.com")
+	t.Setenv("GIT_COMMITTER_DATE", "1587572148 +0000")
+
+	// --author and --date replace the author only
+	author, committer, err := repo.CommitSignatures("Other <other@example.com>", "2005-04-07T22:13:13+02:00")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := author.String(); got != "Other <other@example.com> 1112904793 +0200" {
+		t.Errorf("author %s", got)
+	}
+
+	if got := committer.String(); got != "C O Mitter <committer@example.com> 1587572148 +0000" {
+		t.Errorf("committer %s", got)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	signature, err := ParseSignature("Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530")
+
+	if err != nil || signature.Name != "Paul Kuruvilla" || signature.Email != "rohitpaulk@gmail.com" || signature.When.Unix() != 1587572148 || FormatTimezone(signature.When) != "+0530" {
+		t.Errorf("ParseSignature = %+v, %v", signature, err)
+	}
+
+	if round := signature.String(); round != "Paul Kuruvilla <rohitpaulk@gmail.com> 1587572148 +0530" {
+		t.Errorf("String() = %s", round)
+	}
+
+	for _, bad := range []string{"Paul Kuruvilla", "Paul <paul@example.com>", "Paul <paul@example.com> yesterday"} {
+		if _, err := ParseSignature(bad); err == nil {
+			t.Errorf("ParseSignature(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestIdentityUseConfigOnly(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"[user]\n\tuseConfigOnly = true\n\tname = Config Name\n", "no email was given and auto-detection is disabled"},
+		{"[user]\n\tuseConfigOnly = true\n\temail = config@example.com\n", "no name was given and auto-detection is disabled"},
+	}
+
+	for _, test := range tests {
+		isolateIdentity(t)
+		repo := newTestRepository(t)
+
+		if err := os.WriteFile(filepath.Join(repo.GitDir, "config"), []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.Identity("author"); err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), "Author identity unknown") {
+			t.Errorf("%q: got %v, want %q", test.config, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the patterns of https://git-scm.com/docs/gitignore that keep untracked
+// files out of git status. from lowest to highest precedence:
+//
+//	core.excludesFile    ~/.config/git/ignore by default
+//	.git/info/exclude
+//	.gitignore           of every directory, the deeper one wins
+//
+// the last pattern that matches decides, a "!" pattern brings a path back
+type IgnoreRules struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	// the directory of the .gitignore, relative to the work tree,
+	// "" for the top and the files in .git
+	base    string
+	negated bool
+	// "build/" only matches directories
+	dirOnly bool
+	// a pattern with a slash in it is matched against the path below
+	// base, one without against the name alone at any depth
+	matchPath bool
+	pattern   *regexp.Regexp
+}
+
+// core.excludesFile and .git/info/exclude, the .gitignore files are
+// added while walking the work tree
+func (r *Repository) IgnoreRules() (*IgnoreRules, error) {
+	config, err := r.Config()
+
+	if err != nil {
+		return nil, err
+	}
+
+	excludesFile, ok := config.Get("core.excludesFile")
+
+	if !ok {
+		xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+
+		if home, _ := os.UserHomeDir(); xdgConfigHome == "" && home != "" {
+			xdgConfigHome = filepath.Join(home, ".config")
+		}
+
+		if xdgConfigHome != "" {
+			excludesFile = filepath.Join(xdgConfigHome, "git", "ignore")
+		}
+	}
+
+	if rest, found := strings.CutPrefix(excludesFile, "~/"); found {
+		home, _ := os.UserHomeDir()
+		excludesFile = filepath.Join(home, rest)
+	}
+
+	rules := &IgnoreRules{}
+
+	for _, file := range []string{excludesFile, filepath.Join(r.GitDir, "info", "exclude")} {
+		if file == "" {
+			continue
+		}
+
+		if rules, err = rules.WithFile(file, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+// the rules plus the patterns of file, which apply below base.
+// a missing file adds nothing
+func (rules *IgnoreRules) WithFile(file string, base string) (*IgnoreRules, error) {
+	f, err := os.Open(file)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return rules, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	// a copy, the rules of one directory do not leak into its siblings
+	patterns := append([]ignorePattern{}, rules.patterns...)
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		if pattern, ok := parseIgnorePattern(scanner.Text(), base); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &IgnoreRules{patterns: patterns}, nil
+}
+
+// one line of an ignore file
+//
+//	# comment         \#file   a file starting with #
+//	!important.log    \!file   a file starting with !
+//	build/            directories only
+//	/TODO             only at the top of base, not in its subdirectories
+//	doc/**/*.pdf      ** crosses directories
+func parseIgnorePattern(line string, base string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	pattern := ignorePattern{base: base}
+
+	if rest, found := strings.CutPrefix(line, "!"); found {
+		pattern.negated = true
+		line = rest
+	}
+
+	if rest, found := strings.CutSuffix(line, "/"); found {
+		pattern.dirOnly = true
+		line = rest
+	}
+
+	pattern.matchPath = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	compiled, err := regexp.Compile(globToRegexp(line))
+
+	if err != nil {
+		return ignorePattern{}, false
+	}
+
+	pattern.pattern = compiled
+
+	return pattern, true
+}
+
+// * and ? stop at a slash, ** at the start, at the end or between two
+// slashes matches any number of directories
+func globToRegexp(glob string) string {
+	var expression strings.Builder
+	expression.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') && (i+2 == len(glob) || glob[i+2] == '/'):
+			if i+2 == len(glob) {
+				expression.WriteString(".*")
+			} else {
+				expression.WriteString("(?:.*/)?")
+			}
+
+			i += 2
+		case c == '*':
+			expression.WriteString("[^/]*")
+		case c == '?':
+			expression.WriteString("[^/]")
+		case c == '[':
+			end := bracketEnd(glob, i)
+
+			if end == -1 {
+				expression.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : end]
+
+			if rest, found := strings.CutPrefix(class, "!"); found {
+				class = "^" + rest
+			}
+
+			expression.WriteString("[" + class + "]")
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			expression.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	expression.WriteString("$")
+
+	return expression.String()
+}
+
+// the ] closing the bracket expression at start, -1 when there is none.
+// a ] right after [ or [! is part of the class, so is [:alpha:]
+func bracketEnd(glob string, start int) int {
+	i := start + 1
+
+	if i < len(glob) && glob[i] == '!' {
+		i++
+	}
+
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+
+	for ; i < len(glob); i++ {
+		if strings.HasPrefix(glob[i:], "[:") {
+			if end := strings.Index(glob[i+2:], ":]"); end != -1 {
+				i += end + 3
+				continue
+			}
+		}
+
+		if glob[i] == ']' {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// path is relative to the work tree with forward slashes
+func (rules *IgnoreRules) IsIgnored(filePath string, isDir bool) bool {
+	for i := len(rules.patterns) - 1; i >= 0; i-- {
+		pattern := rules.patterns[i]
+		relativePath := filePath
+
+		if pattern.base != "" {
+			rest, found := strings.CutPrefix(filePath, pattern.base+"/")
+
+			if !found {
+				continue
+			}
+
+			relativePath = rest
+		}
+
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+
+		if !pattern.matchPath {
+			relativePath = path.Base(relativePath)
+		}
+
+		if pattern.pattern.MatchString(relativePath) {
+			return !pattern.negated
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "top"), "# comment\n*.log\n!important.log\nbuild/\n/TODO\ndoc/**/*.pdf\n\\#hash\nspace\\ \ntrailing   \n")
+	writeTestFile(t, filepath.Join(dir, "sub"), "*.tmp\n!keep.log\n")
+
+	rules, err := (&IgnoreRules{}).WithFile(filepath.Join(dir, "top"), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rules of src/ only apply below it
+	subRules, err := rules.WithFile(filepath.Join(dir, "sub"), "src")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if missing, err := rules.WithFile(filepath.Join(dir, "missing"), ""); err != nil || missing != rules {
+		t.Errorf("a missing file added rules: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"debug.log", false, true},
+		{"deep/in/debug.log", false, true},
+		{"important.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"src/build", true, true},
+		{"TODO", false, true},
+		{"src/TODO", false, false},
+		{"doc/a.pdf", false, true},
+		{"doc/x/y/a.pdf", false, true},
+		{"other/doc/a.pdf", false, false},
+		{"#hash", false, true},
+		{"space ", false, true},
+		{"trailing", false, true},
+		{"comment", false, false},
+		{"src/a.tmp", false, true},
+		{"a.tmp", false, false},
+		{"src/keep.log", false, false},
+		{"keep.log", false, true},
+	}
+
+	for _, test := range tests {
+		if got := subRules.IsIgnored(test.path, test.isDir); got != test.ignored {
+			t.Errorf("IsIgnored(%s, %v) = %v, want %v", test.path, test.isDir, got, test.ignored)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		match []string
+		miss  []string
+	}{
+		{"*.go", []string{"main.go", ".go"}, []string{"dir/main.go", "main.goo"}},
+		{"?.txt", []string{"a.txt"}, []string{"ab.txt", "/.txt"}},
+		{"[abc].txt", []string{"b.txt"}, []string{"d.txt"}},
+		{"[!abc].txt", []string{"d.txt"}, []string{"a.txt"}},
+		{"[].txt", []string{"[].txt"}, []string{"a.txt"}},
+		{"**/foo", []string{"foo", "a/b/foo"}, []string{"afoo"}},
+		{"foo/**", []string{"foo/a", "foo/a/b"}, []string{"foo"}},
+		{"a/**/b", []string{"a/b", "a/x/y/b"}, []string{"ab"}},
+		{"a.b+c", []string{"a.b+c"}, []string{"axb+c"}},
+	}
+
+	for _, test := range tests {
+		pattern, ok := parseIgnorePattern(test.glob, "")
+
+		if !ok {
+			t.Errorf("parseIgnorePattern(%s) failed", test.glob)
+			continue
+		}
+
+		for _, path := range test.match {
+			if !pattern.pattern.MatchString(path) {
+				t.Errorf("%s does not match %s", test.glob, path)
+			}
+		}
+
+		for _, path := range test.miss {
+			if pattern.pattern.MatchString(path) {
+				t.Errorf("%s matches %s", test.glob, path)
+			}
+		}
+	}
+}
+
+func TestStatusIgnored(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	writeTestFile(t, filepath.Join(home, ".config", "git", "ignore"), "*.swp\n")
+
+	repo := newTestRepository(t)
+	writeTestFile(t, filepath.Join(repo.GitDir, "info", "exclude"), "secret.txt\n")
+	writeWorkTreeFile(t, repo, ".gitignore", "*.log\nbuild/\n")
+	writeWorkTreeFile(t, repo, "main.go", "package main\n")
+	writeWorkTreeFile(t, repo, "main.go.swp", "swap\n")
+	writeWorkTreeFile(t, repo, "secret.txt", "secret\n")
+	writeWorkTreeFile(t, repo, "debug.log", "log\n")
+	writeWorkTreeFile(t, repo, "build/out", "binary\n")
+	// only ignored files, the directory does not show
+	writeWorkTreeFile(t, repo, "logs/a.log", "log\n")
+	writeWorkTreeFile(t, repo, "src/.gitignore", "!keep.log\n")
+	writeWorkTreeFile(t, repo, "src/keep.log", "kept\n")
+
+	statuses, err := repo.Status()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileStatus{{".gitignore", '?', '?'}, {"main.go", '?', '?'}, {"src/", '?', '?'}}
+
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status %q, want %q", statuses, want)
+	}
+}
@@ -0,0 +1,659 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// one staged file, the stat data lets later commands tell
+// whether the file changed without hashing it again
+type IndexEntry struct {
+	CTimeSeconds     uint32
+	CTimeNanoseconds uint32
+	MTimeSeconds     uint32
+	MTimeNanoseconds uint32
+	Dev              uint32
+	Ino              uint32
+	Mode             uint32
+	UID              uint32
+	GID              uint32
+	Size             uint32
+	SHA              string
+	Flags            uint16
+	Path             string
+}
+
+// the merge stage, anything but 0 is an unresolved conflict
+func (entry IndexEntry) Stage() int {
+	return int(entry.Flags>>12) & 3
+}
+
+// the staging area, .git/index. Entries are sorted by path
+type Index struct {
+	Version uint32
+	Entries []IndexEntry
+}
+
+func (r *Repository) indexPath() string {
+	return filepath.Join(r.GitDir, "index")
+}
+
+func (r *Repository) HasIndex() bool {
+	_, err := os.Stat(r.indexPath())
+
+	return err == nil
+}
+
+// https://github.com/git/git/blob/795ea8776befc95ea2becd8020c7a284677b4161/Documentation/gitformat-index.txt
+//
+//	DIRC <version> <number of entries>
+//	<entries>
+//	<extensions>     4-byte signature, 4-byte size, data (skipped, we do not use them)
+//	<sha1 checksum>  of everything above
+//
+// a repository without an index has an empty one
+func (r *Repository) ReadIndex() (*Index, error) {
+	data, err := os.ReadFile(r.indexPath())
+
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{Version: 2}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseIndex(data)
+}
+
+func ParseIndex(data []byte) (*Index, error) {
+	if len(data) < 12+20 || !bytes.HasPrefix(data, []byte("DIRC")) {
+		return nil, errors.New("index file corrupt: missing DIRC signature")
+	}
+
+	checksum := sha1.Sum(data[:len(data)-20])
+
+	if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+		return nil, errors.New("index file corrupt: bad checksum")
+	}
+
+	index := &Index{Version: binary.BigEndian.Uint32(data[4:8])}
+
+	// version 4 prefix-compresses the paths, real git only writes it when asked to
+	if index.Version != 2 && index.Version != 3 {
+		return nil, fmt.Errorf("unsupported index version %d", index.Version)
+	}
+
+	numEntries := binary.BigEndian.Uint32(data[8:12])
+	content := data[:len(data)-20]
+	offset := 12
+
+	for i := uint32(0); i < numEntries; i++ {
+		entry, entryLength, err := parseIndexEntry(content[offset:])
+
+		if err != nil {
+			return nil, fmt.Errorf("index file corrupt: %w", err)
+		}
+
+		index.Entries = append(index.Entries, entry)
+		offset += entryLength
+	}
+
+	return index, nil
+}
+
+// every entry is
+//
+//	ctime, mtime      seconds and nanoseconds, 4 bytes each
+//	dev, ino, mode, uid, gid, size    4 bytes each
+//	sha               20 bytes
+//	flags             2 bytes, 1-bit assume-valid, 1-bit extended,
+//	                  2-bit stage, 12-bit name length
+//	extended flags    2 bytes, version 3 only and only if the extended bit is set
+//	path              followed by 1-8 NUL bytes so the entry is a multiple of 8 bytes
+func parseIndexEntry(data []byte) (IndexEntry, int, error) {
+	if len(data) < 62 {
+		return IndexEntry{}, 0, errors.New("entry is truncated")
+	}
+
+	fields := make([]uint32, 10)
+
+	for i := range fields {
+		fields[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+
+	entry := IndexEntry{
+		CTimeSeconds:     fields[0],
+		CTimeNanoseconds: fields[1],
+		MTimeSeconds:     fields[2],
+		MTimeNanoseconds: fields[3],
+		Dev:              fields[4],
+		Ino:              fields[5],
+		Mode:             fields[6],
+		UID:              fields[7],
+		GID:              fields[8],
+		Size:             fields[9],
+		SHA:              hex.EncodeToString(data[40:60]),
+		Flags:            binary.BigEndian.Uint16(data[60:62]),
+	}
+
+	pathStart := 62
+
+	if entry.Flags&0x4000 != 0 {
+		pathStart += 2
+	}
+
+	pathLength := bytes.IndexByte(data[pathStart:], 0)
+
+	if pathLength == -1 {
+		return IndexEntry{}, 0, errors.New("entry path is not terminated")
+	}
+
+	entry.Path = string(data[pathStart : pathStart+pathLength])
+
+	entryLength := (pathStart + pathLength + 8) &^ 7
+
+	if entryLength > len(data) {
+		return IndexEntry{}, 0, errors.New("entry is truncated")
+	}
+
+	return entry, entryLength, nil
+}
+
+// entries are sorted by path then stage, the file is written to index.lock
+// first and renamed over the index so a reader never sees half of it
+func (r *Repository) WriteIndex(index *Index) error {
+	sortIndexEntries(index.Entries)
+
+	content := bytes.Buffer{}
+	content.WriteString("DIRC")
+	binary.Write(&content, binary.BigEndian, uint32(2))
+	binary.Write(&content, binary.BigEndian, uint32(len(index.Entries)))
+
+	for _, entry := range index.Entries {
+		hash, err := hex.DecodeString(entry.SHA)
+
+		if err != nil || len(hash) != 20 {
+			return fmt.Errorf("invalid sha %q for %s", entry.SHA, entry.Path)
+		}
+
+		for _, field := range []uint32{
+			entry.CTimeSeconds, entry.CTimeNanoseconds,
+			entry.MTimeSeconds, entry.MTimeNanoseconds,
+			entry.Dev, entry.Ino, entry.Mode, entry.UID, entry.GID, entry.Size,
+		} {
+			binary.Write(&content, binary.BigEndian, field)
+		}
+
+		content.Write(hash)
+
+		// version 2 has no extended flags
+		flags := entry.Flags &^ 0x4fff
+		flags |= uint16(min(len(entry.Path), 0xfff))
+		binary.Write(&content, binary.BigEndian, flags)
+
+		content.WriteString(entry.Path)
+
+		entryLength := 62 + len(entry.Path)
+		content.Write(make([]byte, ((entryLength+8)&^7)-entryLength))
+	}
+
+	checksum := sha1.Sum(content.Bytes())
+	content.Write(checksum[:])
+
+	lockPath := r.indexPath() + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("unable to create '%s': %w", lockPath, err)
+	}
+
+	_, err = lock.Write(content.Bytes())
+	lock.Close()
+
+	if err == nil {
+		err = os.Rename(lockPath, r.indexPath())
+	}
+
+	if err != nil {
+		os.Remove(lockPath)
+		return err
+	}
+
+	return nil
+}
+
+func sortIndexEntries(entries []IndexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Path != entries[j].Path {
+			return entries[i].Path < entries[j].Path
+		}
+
+		return entries[i].Stage() < entries[j].Stage()
+	})
+}
+
+func (index *Index) Entry(path string) (IndexEntry, bool) {
+	position := sort.Search(len(index.Entries), func(i int) bool {
+		return index.Entries[i].Path >= path
+	})
+
+	if position < len(index.Entries) && index.Entries[position].Path == path {
+		return index.Entries[position], true
+	}
+
+	return IndexEntry{}, false
+}
+
+// the path is staged, or files below it are
+func (index *Index) tracks(path string) bool {
+	_, ok := index.Entry(path)
+
+	return ok || index.hasEntriesUnder(path)
+}
+
+// stage the entry, replacing whatever was staged at its path (conflicts included)
+func (index *Index) Add(entry IndexEntry) {
+	start, end := index.entryRange(entry.Path)
+
+	if end == start {
+		index.Entries = append(index.Entries, IndexEntry{})
+		copy(index.Entries[start+1:], index.Entries[start:])
+	} else {
+		index.Entries = append(index.Entries[:start+1], index.Entries[end:]...)
+	}
+
+	index.Entries[start] = entry
+}
+
+func (index *Index) Remove(path string) {
+	start, end := index.entryRange(path)
+
+	index.Entries = append(index.Entries[:start], index.Entries[end:]...)
+}
+
+// the entries staged at path, one per stage
+func (index *Index) entryRange(path string) (int, int) {
+	start := sort.Search(len(index.Entries), func(i int) bool {
+		return index.Entries[i].Path >= path
+	})
+
+	end := start
+
+	for end < len(index.Entries) && index.Entries[end].Path == path {
+		end++
+	}
+
+	return start, end
+}
+
+// the mode git records for a file, only the executable bit of regular files is kept
+func fileMode(info fs.FileInfo) uint32 {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return 0120000
+	}
+
+	if info.Mode()&0111 != 0 {
+		return 0100755
+	}
+
+	return 0100644
+}
+
+func NewIndexEntry(path string, info fs.FileInfo, sha string) IndexEntry {
+	entry := IndexEntry{
+		Mode: fileMode(info),
+		Size: uint32(info.Size()),
+		SHA:  sha,
+		Path: path,
+	}
+
+	fillStatData(&entry, info)
+
+	return entry
+}
+
+// the blob of a file, a symlink is stored as the path it points to
+func readWorkTreeFile(fullPath string, info fs.FileInfo) ([]byte, error) {
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(fullPath)
+
+		return []byte(target), err
+	}
+
+	return os.ReadFile(fullPath)
+}
+
+// path relative to the work tree with / separators, the way the index stores it
+func (r *Repository) RelativePath(path string) (string, error) {
+	if r.WorkTree == "" {
+		return "", errors.New("this operation must be run in a work tree")
+	}
+
+	fullPath, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	relativePath, err := filepath.Rel(r.WorkTree, fullPath)
+
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, "../") {
+		return "", fmt.Errorf("'%s' is outside repository at '%s'", path, r.WorkTree)
+	}
+
+	if relativePath == "." {
+		return "", nil
+	}
+
+	return filepath.ToSlash(relativePath), nil
+}
+
+// git add [-f] <paths>, files are hashed into blobs and staged,
+// directories are added recursively and staged files that
+// no longer exist are removed from the index
+//
+// untracked files and directories the ignore rules match are left out
+// of a directory, tracked ones are still updated. a path that is ignored
+// itself is only added with force, otherwise it is reported once the
+// other paths are staged
+func (r *Repository) Add(paths []string, force bool) error {
+	index, err := r.ReadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	// nothing is ignored with force
+	var rules *IgnoreRules
+
+	if !force {
+		if rules, err = r.IgnoreRules(); err != nil {
+			return err
+		}
+	}
+
+	ignoredPaths := []string{}
+
+	for _, path := range paths {
+		relativePath, err := r.RelativePath(path)
+
+		if err != nil {
+			return err
+		}
+
+		matched := false
+
+		// deletions first, anything staged under the path that is gone
+		for _, entry := range append([]IndexEntry{}, index.Entries...) {
+			if relativePath != "" && entry.Path != relativePath && !strings.HasPrefix(entry.Path, relativePath+"/") {
+				continue
+			}
+
+			matched = true
+
+			if _, err := os.Lstat(filepath.Join(r.WorkTree, entry.Path)); errors.Is(err, os.ErrNotExist) {
+				index.Remove(entry.Path)
+			}
+		}
+
+		fullPath := filepath.Join(r.WorkTree, relativePath)
+		info, err := os.Lstat(fullPath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			if !matched {
+				return fmt.Errorf("pathspec '%s' did not match any files", path)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the rules of the directory the path is in, and whether the path
+		// is ignored. the walk below starts from there
+		parent := addDirectory{rules: rules}
+
+		if rules != nil && relativePath != "" {
+			parentDir := ""
+
+			if slash := strings.LastIndex(relativePath, "/"); slash != -1 {
+				parentDir = relativePath[:slash]
+			}
+
+			parent.rules, parent.ignored, err = r.ignoreRulesIn(rules, parentDir)
+
+			if err != nil {
+				return err
+			}
+
+			ignored := parent.ignored || parent.rules.IsIgnored(relativePath, info.IsDir())
+
+			if ignored && !index.tracks(relativePath) {
+				ignoredPaths = append(ignoredPaths, path)
+				continue
+			}
+		}
+
+		directories := map[string]addDirectory{filepath.Dir(fullPath): parent}
+
+		err = filepath.WalkDir(fullPath, func(walkPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// a .git file, the gitfile of a submodule or worktree, is left
+			// out on its own: SkipDir on a file skips the rest of its directory
+			if walkPath == r.GitDir || d.Name() == ".git" {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			walkRelativePath, err := filepath.Rel(r.WorkTree, walkPath)
+
+			if err != nil {
+				return err
+			}
+
+			// the top of the work tree is never ignored
+			if walkRelativePath = filepath.ToSlash(walkRelativePath); walkRelativePath == "." {
+				walkRelativePath = ""
+			}
+
+			dir := directories[filepath.Dir(walkPath)]
+			ignored := dir.rules != nil && walkRelativePath != "" && (dir.ignored || dir.rules.IsIgnored(walkRelativePath, d.IsDir()))
+
+			if d.IsDir() {
+				// only what is tracked in an ignored directory is updated
+				if ignored && !index.hasEntriesUnder(walkRelativePath) {
+					return filepath.SkipDir
+				}
+
+				entered := addDirectory{rules: dir.rules, ignored: ignored}
+
+				if dir.rules != nil && !ignored {
+					entered.rules, err = dir.rules.WithFile(filepath.Join(walkPath, ".gitignore"), walkRelativePath)
+
+					if err != nil {
+						return err
+					}
+				}
+
+				directories[walkPath] = entered
+
+				return nil
+			}
+
+			if _, tracked := index.Entry(walkRelativePath); ignored && !tracked {
+				return nil
+			}
+
+			return r.addFile(index, walkPath)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.WriteIndex(index); err != nil {
+		return err
+	}
+
+	if len(ignoredPaths) > 0 {
+		return fmt.Errorf("The following paths are ignored by one of your .gitignore files:\n%s\n"+
+			"hint: Use -f if you really want to add them.", strings.Join(ignoredPaths, "\n"))
+	}
+
+	return nil
+}
+
+// a directory on the way of add, ignored when everything in it is
+type addDirectory struct {
+	rules   *IgnoreRules
+	ignored bool
+}
+
+// the ignore rules inside dir, relative to the work tree: the .gitignore
+// of every directory from the top down to dir is added. ignored is true
+// when one of those directories is ignored itself
+func (r *Repository) ignoreRulesIn(rules *IgnoreRules, dir string) (*IgnoreRules, bool, error) {
+	rules, err := rules.WithFile(filepath.Join(r.WorkTree, ".gitignore"), "")
+
+	if err != nil || dir == "" {
+		return rules, false, err
+	}
+
+	current := ""
+
+	for _, name := range strings.Split(dir, "/") {
+		current = strings.TrimPrefix(current+"/"+name, "/")
+
+		if rules.IsIgnored(current, true) {
+			return rules, true, nil
+		}
+
+		rules, err = rules.WithFile(filepath.Join(r.WorkTree, filepath.FromSlash(current), ".gitignore"), current)
+
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return rules, false, nil
+}
+
+func (r *Repository) addFile(index *Index, fullPath string) error {
+	info, err := os.Lstat(fullPath)
+
+	if err != nil {
+		return err
+	}
+
+	relativePath, err := filepath.Rel(r.WorkTree, fullPath)
+
+	if err != nil {
+		return err
+	}
+
+	relativePath = filepath.ToSlash(relativePath)
+
+	// unchanged since it was staged, no need to hash it again
+	if entry, ok := index.Entry(relativePath); ok && entry.Stage() == 0 && !StatChanged(entry, info) {
+		return nil
+	}
+
+	content, err := readWorkTreeFile(fullPath, info)
+
+	if err != nil {
+		return err
+	}
+
+	sha, err := r.Objects.Write("blob", content)
+
+	if err != nil {
+		return err
+	}
+
+	index.Add(NewIndexEntry(relativePath, info, sha))
+
+	return nil
+}
+
+// whether the file may have changed since the entry was staged,
+// a false positive only costs a rehash
+func StatChanged(entry IndexEntry, info fs.FileInfo) bool {
+	current := NewIndexEntry(entry.Path, info, entry.SHA)
+
+	return current.Mode != entry.Mode ||
+		current.Size != entry.Size ||
+		current.MTimeSeconds != entry.MTimeSeconds ||
+		current.MTimeNanoseconds != entry.MTimeNanoseconds ||
+		current.CTimeSeconds != entry.CTimeSeconds ||
+		current.CTimeNanoseconds != entry.CTimeNanoseconds ||
+		current.Ino != entry.Ino
+}
+
+// build the trees out of the index instead of the working directory,
+// entries sharing a directory prefix are next to each other because
+// the index is sorted by path
+func (r *Repository) WriteIndexTree(index *Index) (string, error) {
+	for _, entry := range index.Entries {
+		if entry.Stage() != 0 {
+			return "", fmt.Errorf("%s: unmerged (%s)", entry.Path, entry.SHA)
+		}
+	}
+
+	return r.writeIndexTree(index.Entries, "")
+}
+
+func (r *Repository) writeIndexTree(entries []IndexEntry, prefix string) (string, error) {
+	treeEntries := []TreeEntry{}
+
+	for i := 0; i < len(entries); {
+		name := strings.TrimPrefix(entries[i].Path, prefix)
+
+		if dir, _, found := strings.Cut(name, "/"); found {
+			subPrefix := prefix + dir + "/"
+			end := i
+
+			for end < len(entries) && strings.HasPrefix(entries[end].Path, subPrefix) {
+				end++
+			}
+
+			hash, err := r.writeIndexTree(entries[i:end], subPrefix)
+
+			if err != nil {
+				return "", err
+			}
+
+			treeEntries = append(treeEntries, TreeEntry{Mode: "40000", Name: dir, SHA: hash})
+			i = end
+
+			continue
+		}
+
+		treeEntries = append(treeEntries, TreeEntry{
+			Mode: fmt.Sprintf("%o", entries[i].Mode),
+			Name: name,
+			SHA:  entries[i].SHA,
+		})
+		i++
+	}
+
+	return r.writeTreeEntries(treeEntries)
+}
@@ -0,0 +1,384 @@
+package helper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+// path has / separators and is relative to the work tree
+func writeWorkTreeFile(t *testing.T, repo *Repository, path string, content string) {
+	t.Helper()
+
+	fullPath := filepath.Join(repo.WorkTree, filepath.FromSlash(path))
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blobSHA(content string) string {
+	hash, _ := GetObjectSHA([]byte(content), "blob")
+
+	return hex.EncodeToString(hash[:])
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	repo := newTestRepository(t)
+	sha := blobSHA("content\n")
+
+	entry := func(path string, stage uint16) IndexEntry {
+		return IndexEntry{
+			CTimeSeconds: 1700000000, CTimeNanoseconds: 1,
+			MTimeSeconds: 1700000001, MTimeNanoseconds: 2,
+			Dev: 3, Ino: 4, Mode: 0100644, UID: 5, GID: 6, Size: 8,
+			SHA: sha, Flags: stage << 12, Path: path,
+		}
+	}
+
+	// out of order, the paths pad their entries to every multiple of 8,
+	// and a conflict with its stages
+	written := []IndexEntry{
+		entry("src/main.go", 0),
+		entry("a", 0),
+		entry("conflict.txt", 3),
+		entry("conflict.txt", 1),
+		entry("conflict.txt", 2),
+		entry("ab", 0),
+		entry("abc", 0),
+		entry("abcdefgh", 0),
+		entry(strings.Repeat("long/", 30)+"file", 0),
+	}
+
+	if err := repo.WriteIndex(&Index{Version: 2, Entries: append([]IndexEntry{}, written...)}); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := repo.ReadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []IndexEntry{written[1], written[5], written[6], written[7], written[3], written[4], written[2], written[8], written[0]}
+
+	// the name length lives in the flags
+	for i := range want {
+		want[i].Flags |= uint16(len(want[i].Path))
+	}
+
+	if !reflect.DeepEqual(index.Entries, want) {
+		t.Errorf("read back\n%+v\nwant\n%+v", index.Entries, want)
+	}
+
+	if stage := index.Entries[6].Stage(); stage != 3 {
+		t.Errorf("stage of %s = %d, want 3", index.Entries[6].Path, stage)
+	}
+}
+
+func TestParseIndexErrors(t *testing.T) {
+	withChecksum := func(content []byte) []byte {
+		checksum := sha1.Sum(content)
+
+		return append(content, checksum[:]...)
+	}
+
+	empty := withChecksum([]byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x00"))
+	badChecksum := append([]byte{}, empty...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"no signature", withChecksum([]byte("CRID\x00\x00\x00\x02\x00\x00\x00\x00")), "missing DIRC signature"},
+		{"bad checksum", badChecksum, "bad checksum"},
+		{"version 4", withChecksum([]byte("DIRC\x00\x00\x00\x04\x00\x00\x00\x00")), "unsupported index version 4"},
+		{"missing entry", withChecksum([]byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x01")), "entry is truncated"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseIndex(test.data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+
+	if index, err := ParseIndex(empty); err != nil || len(index.Entries) != 0 {
+		t.Errorf("empty index: got %+v, %v", index, err)
+	}
+}
+
+func TestIndexAddRemove(t *testing.T) {
+	index := &Index{Version: 2}
+
+	for _, path := range []string{"b", "a", "c"} {
+		index.Add(IndexEntry{Path: path, SHA: blobSHA(path)})
+	}
+
+	index.Add(IndexEntry{Path: "b", SHA: blobSHA("b2")})
+
+	// staging a path replaces its conflict stages
+	index.Entries = append(index.Entries, IndexEntry{Path: "d", Flags: 1 << 12}, IndexEntry{Path: "d", Flags: 2 << 12})
+	index.Add(IndexEntry{Path: "d", SHA: blobSHA("d")})
+	index.Remove("a")
+
+	paths := []string{}
+
+	for _, entry := range index.Entries {
+		paths = append(paths, entry.Path)
+	}
+
+	if strings.Join(paths, " ") != "b c d" {
+		t.Errorf("paths %q, want b c d", paths)
+	}
+
+	if entry, ok := index.Entry("b"); !ok || entry.SHA != blobSHA("b2") {
+		t.Errorf("Entry(b) = %+v, %v, want the second b", entry, ok)
+	}
+
+	if entry, ok := index.Entry("d"); !ok || entry.Stage() != 0 {
+		t.Errorf("Entry(d) = %+v, %v, want stage 0", entry, ok)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	repo := newTestRepository(t)
+	writeWorkTreeFile(t, repo, "README.md", "hello\n")
+	writeWorkTreeFile(t, repo, "src/main.go", "package main\n")
+	writeWorkTreeFile(t, repo, "src/lib/lib.go", "package lib\n")
+
+	if err := repo.Add([]string{repo.WorkTree}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := repo.ReadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"README.md":      blobSHA("hello\n"),
+		"src/lib/lib.go": blobSHA("package lib\n"),
+		"src/main.go":    blobSHA("package main\n"),
+	}
+
+	if len(index.Entries) != len(want) {
+		t.Fatalf("staged %+v, want %v", index.Entries, want)
+	}
+
+	for _, entry := range index.Entries {
+		if want[entry.Path] != entry.SHA || entry.Mode != 0100644 {
+			t.Errorf("staged %s as %o %s, want 100644 %s", entry.Path, entry.Mode, entry.SHA, want[entry.Path])
+		}
+
+		if content, objectType, err := repo.Objects.Read(entry.SHA); err != nil || objectType != "blob" {
+			t.Errorf("blob of %s: %q, %s, %v", entry.Path, content, objectType, err)
+		}
+	}
+
+	// a deleted file leaves the index when its directory is added again
+	if err := os.Remove(filepath.Join(repo.WorkTree, "src", "main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "src")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, _ = repo.ReadIndex()
+
+	if _, ok := index.Entry("src/main.go"); ok || len(index.Entries) != 2 {
+		t.Errorf("after deleting src/main.go the index has %+v", index.Entries)
+	}
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "missing")}, false); err == nil || !strings.Contains(err.Error(), "did not match any files") {
+		t.Errorf("adding a missing path: got %v", err)
+	}
+}
+
+func TestAddGitFile(t *testing.T) {
+	repo := newTestRepository(t)
+	// the gitfile of a worktree, with entries after it in walk order
+	writeWorkTreeFile(t, repo, "sub/.git", "gitdir: ../elsewhere/.git\n")
+	writeWorkTreeFile(t, repo, "sub/after.txt", "after\n")
+	writeWorkTreeFile(t, repo, "sub/z/deeper.txt", "deeper\n")
+
+	if err := repo.Add([]string{repo.WorkTree}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := repo.ReadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+
+	for _, entry := range index.Entries {
+		paths = append(paths, entry.Path)
+	}
+
+	if want := []string{"sub/after.txt", "sub/z/deeper.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("staged %q, want %q", paths, want)
+	}
+}
+
+func TestAddIgnored(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	stagedPaths := func(repo *Repository) []string {
+		t.Helper()
+
+		paths := []string{}
+
+		for _, entry := range mustReadIndex(t, repo).Entries {
+			paths = append(paths, entry.Path)
+		}
+
+		return paths
+	}
+
+	newRepository := func() *Repository {
+		repo := newTestRepository(t)
+		writeWorkTreeFile(t, repo, ".gitignore", "*.log\nbuild/\n")
+		writeWorkTreeFile(t, repo, "main.go", "package main\n")
+		writeWorkTreeFile(t, repo, "debug.log", "log\n")
+		writeWorkTreeFile(t, repo, "build/out", "binary\n")
+		writeWorkTreeFile(t, repo, "src/.gitignore", "generated.go\n!keep.log\n")
+		writeWorkTreeFile(t, repo, "src/lib.go", "package src\n")
+		writeWorkTreeFile(t, repo, "src/generated.go", "package src\n")
+		writeWorkTreeFile(t, repo, "src/keep.log", "kept\n")
+		writeWorkTreeFile(t, repo, "src/trace.log", "log\n")
+
+		return repo
+	}
+
+	tests := []struct {
+		name   string
+		paths  []string
+		force  bool
+		staged []string
+		err    string
+	}{
+		{
+			"the work tree",
+			[]string{""},
+			false,
+			[]string{".gitignore", "main.go", "src/.gitignore", "src/keep.log", "src/lib.go"},
+			"",
+		},
+		{
+			"a directory",
+			[]string{"src"},
+			false,
+			[]string{"src/.gitignore", "src/keep.log", "src/lib.go"},
+			"",
+		},
+		{
+			"an ignored file named explicitly",
+			[]string{"main.go", "debug.log"},
+			false,
+			[]string{"main.go"},
+			"debug.log\nhint: Use -f if you really want to add them.",
+		},
+		{
+			"a file in an ignored directory",
+			[]string{"build/out"},
+			false,
+			[]string{},
+			"build/out",
+		},
+		{
+			"an ignored file named by src/.gitignore",
+			[]string{"src/generated.go"},
+			false,
+			[]string{},
+			"src/generated.go",
+		},
+		{
+			"forced",
+			[]string{"debug.log", "build", "src/generated.go"},
+			true,
+			[]string{"build/out", "debug.log", "src/generated.go"},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		repo := newRepository()
+		paths := []string{}
+
+		for _, path := range test.paths {
+			paths = append(paths, filepath.Join(repo.WorkTree, filepath.FromSlash(path)))
+		}
+
+		err := repo.Add(paths, test.force)
+
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+
+		if staged := stagedPaths(repo); !reflect.DeepEqual(staged, test.staged) {
+			t.Errorf("%s: staged %q, want %q", test.name, staged, test.staged)
+		}
+	}
+
+	// a tracked file stays tracked, also in an ignored directory
+	repo := newRepository()
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "build", "out")}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	writeWorkTreeFile(t, repo, "build/out", "rebuilt\n")
+	writeWorkTreeFile(t, repo, "build/new", "new\n")
+
+	if err := repo.Add([]string{repo.WorkTree}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, ok := mustReadIndex(t, repo).Entry("build/out"); !ok || entry.SHA != blobSHA("rebuilt\n") {
+		t.Errorf("the tracked build/out was not updated: %+v", entry)
+	}
+
+	if _, ok := mustReadIndex(t, repo).Entry("build/new"); ok {
+		t.Errorf("build/new was staged from an ignored directory")
+	}
+}
+
+func mustReadIndex(t *testing.T, repo *Repository) *Index {
+	t.Helper()
+
+	index, err := repo.ReadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return index
+}
@@ -0,0 +1,120 @@
+package helper
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// git clone --local, the object database of source is copied as it is,
+// loose objects and packs, hard linked where the file system allows it.
+// the alternates of source become ours so nothing goes missing
+func (r *Repository) CopyObjects(source *Repository) error {
+	sourceDir := filepath.Join(source.GitDir, "objects")
+	targetDir := filepath.Join(r.GitDir, "objects")
+
+	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(sourceDir, path)
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(targetDir, relativePath), 0755)
+		}
+
+		// half written objects and packs, and the alternates added below
+		if strings.HasPrefix(d.Name(), "tmp_") || relativePath == filepath.Join("info", "alternates") {
+			return nil
+		}
+
+		return linkOrCopyFile(path, filepath.Join(targetDir, relativePath))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	alternates, err := ReadAlternates(sourceDir)
+
+	if err != nil {
+		return err
+	}
+
+	return r.AddAlternates(alternates)
+}
+
+func linkOrCopyFile(source string, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	info, err := in.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// adds object directories to objects/info/alternates, as absolute paths
+func (r *Repository) AddAlternates(dirs []string) error {
+	objectsDir := filepath.Join(r.GitDir, "objects")
+	existing, err := ReadAlternates(objectsDir)
+
+	if err != nil {
+		return err
+	}
+
+	added := false
+
+	for _, dir := range dirs {
+		dir, err := filepath.Abs(dir)
+
+		if err != nil {
+			return err
+		}
+
+		if !ArrayContains(existing, dir) {
+			existing = append(existing, dir)
+			added = true
+		}
+	}
+
+	if !added {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(objectsDir, "info"), 0755); err != nil {
+		return err
+	}
+
+	return writeLockedFile(filepath.Join(objectsDir, "info", "alternates"), []byte(strings.Join(existing, "\n")+"\n"))
+}
@@ -0,0 +1,220 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAlternates(t *testing.T) {
+	objectsDir := filepath.Join(t.TempDir(), "objects")
+	writeTestFile(t, filepath.Join(objectsDir, "info", "alternates"), "/srv/git/project.git/objects\n\n# a comment\n../../shared/objects\n")
+
+	dirs, err := ReadAlternates(objectsDir)
+	want := []string{"/srv/git/project.git/objects", filepath.Join(filepath.Dir(filepath.Dir(objectsDir)), "shared", "objects")}
+
+	if err != nil || !reflect.DeepEqual(dirs, want) {
+		t.Errorf("ReadAlternates = %q, %v, want %q", dirs, err, want)
+	}
+
+	if dirs, err := ReadAlternates(t.TempDir()); err != nil || dirs != nil {
+		t.Errorf("ReadAlternates without the file = %q, %v", dirs, err)
+	}
+}
+
+func TestAddAlternates(t *testing.T) {
+	source, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := source.Objects.Write("blob", []byte("shared\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store was opened before the alternates were written
+	if repo.Objects.Has(blob) {
+		t.Fatalf("the object is there before the alternates")
+	}
+
+	sourceObjects := filepath.Join(source.GitDir, "objects")
+
+	for i := 0; i < 2; i++ {
+		if err := repo.AddAlternates([]string{sourceObjects}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dirs, err := ReadAlternates(filepath.Join(repo.GitDir, "objects")); err != nil || !reflect.DeepEqual(dirs, []string{sourceObjects}) {
+		t.Errorf("alternates = %q, %v, want %s once", dirs, err, sourceObjects)
+	}
+
+	if content, objectType, err := repo.Objects.Read(blob); err != nil || string(content) != "shared\n" || objectType != "blob" {
+		t.Errorf("Read through the alternate = %q, %s, %v", content, objectType, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "objects", blob[:2], blob[2:])); err == nil {
+		t.Errorf("the object was copied")
+	}
+}
+
+func TestCopyObjects(t *testing.T) {
+	shared, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sharedBlob, _ := shared.Objects.Write("blob", []byte("from the alternate\n"))
+
+	source, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, _ := source.Objects.Write("blob", []byte("loose\n"))
+
+	if err := source.AddAlternates([]string{filepath.Join(shared.GitDir, "objects")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pack being written when the copy starts
+	writeTestFile(t, filepath.Join(source.GitDir, "objects", "pack", "tmp_pack_123"), "half")
+
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.CopyObjects(source); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{blob, sharedBlob} {
+		if !repo.Objects.Has(name) {
+			t.Errorf("object %s is missing", name)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "objects", "pack", "tmp_pack_123")); err == nil {
+		t.Errorf("the temporary pack was copied")
+	}
+}
+
+func TestNewLocalAdvertisement(t *testing.T) {
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unborn, HEAD only has its target
+	advertisement, err := NewLocalAdvertisement(repo)
+
+	if err != nil || len(advertisement.Refs) != 0 || advertisement.Symrefs["HEAD"] == "" {
+		t.Errorf("an empty repository advertises %+v, %v", advertisement, err)
+	}
+
+	signature := Signature{Name: "A U Thor", Email: "author@example.com"}
+	tree, _ := repo.Objects.Write("tree", nil)
+	commit, err := repo.CommitTree(tree, nil, "first", signature, signature)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag, _ := repo.Objects.Write("tag", []byte("object "+commit+"\ntype commit\ntag v1.0\ntagger A U Thor <author@example.com> 0 +0000\n\nv1.0\n"))
+	head := advertisement.Symrefs["HEAD"]
+
+	for name, sha := range map[string]string{head: commit, "refs/tags/v1.0": tag} {
+		if err := repo.UpdateRef(name, sha, ZeroSHA); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	advertisement, err = NewLocalAdvertisement(repo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Ref{{Name: "HEAD", SHA: commit}, {Name: head, SHA: commit}, {Name: "refs/tags/v1.0", SHA: tag}}
+
+	if !reflect.DeepEqual(advertisement.Refs, want) || advertisement.Peeled["refs/tags/v1.0"] != commit {
+		t.Errorf("NewLocalAdvertisement = %+v, want %+v with the tag peeled to %s", advertisement, want, commit)
+	}
+}
+
+func TestStreamPack(t *testing.T) {
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+
+	for _, content := range []string{"one\n", "two\n", strings.Repeat("three\n", 1000)} {
+		name, err := repo.Objects.Write("blob", []byte(content))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+
+	stream := repo.StreamPack(names)
+	defer stream.Close()
+
+	reader, err := NewPackReader(stream, io.Discard)
+
+	if err != nil || reader.NumObjects != 3 {
+		t.Fatalf("NewPackReader = %v, %v, want 3 objects", reader, err)
+	}
+
+	for _, name := range names {
+		if entry, err := reader.Next(); err != nil || entry.SHA != name {
+			t.Errorf("entry %+v, %v, want %s", entry, err, name)
+		}
+	}
+
+	if _, err := reader.Finish(); err != nil {
+		t.Errorf("Finish = %v", err)
+	}
+
+	// a missing object fails the reader
+	missing := repo.StreamPack([]string{strings.Repeat("0", 40)})
+	defer missing.Close()
+
+	if _, err := io.Copy(&bytes.Buffer{}, missing); err == nil {
+		t.Errorf("streaming a missing object did not fail")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
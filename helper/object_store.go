@@ -0,0 +1,353 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the object database of a repository, objects are addressed by the
+// hex SHA-1 of "<type> <size>\0<content>" and are returned without that header
+type ObjectStore interface {
+	Read(objectName string) ([]byte, string, error)
+	Write(objectType string, content []byte) (string, error)
+	Has(objectName string) bool
+	// like Read, but the content is not held in memory when it can be avoided,
+	// returns the reader, the object type and the content size
+	Stream(objectName string) (io.ReadCloser, string, int64, error)
+}
+
+// objects are either loose in <dir>/xx/yyyy
+// or packed in one of <dir>/pack/pack-*.pack,
+// or in one of the object directories of <dir>/info/alternates
+type FileObjectStore struct {
+	dir string
+
+	mu          sync.Mutex
+	packIndexes map[string]*packIndex
+	alternates  map[string]*FileObjectStore
+	// how many alternates deep this store is, git stops at 5
+	depth int
+}
+
+func NewFileObjectStore(dir string) *FileObjectStore {
+	return &FileObjectStore{dir: dir, packIndexes: map[string]*packIndex{}, alternates: map[string]*FileObjectStore{}}
+}
+
+// objects/info/alternates lists other object directories, one per line,
+// absolute or relative to objectsDir. objects missing here are looked up
+// there, clone --shared has none of its own
+//
+//	/srv/git/project.git/objects
+//	../../shared/objects
+func ReadAlternates(objectsDir string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(objectsDir, "info", "alternates"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objectsDir, line)
+		}
+
+		dirs = append(dirs, filepath.Clean(line))
+	}
+
+	return dirs, nil
+}
+
+// the file is read again every time, it may be written after the store
+// was created like clone --shared does
+func (s *FileObjectStore) alternateStores() []*FileObjectStore {
+	if s.depth >= 5 {
+		return nil
+	}
+
+	dirs, err := ReadAlternates(s.dir)
+
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stores := make([]*FileObjectStore, 0, len(dirs))
+
+	for _, dir := range dirs {
+		store, ok := s.alternates[dir]
+
+		if !ok {
+			store = NewFileObjectStore(dir)
+			store.depth = s.depth + 1
+			s.alternates[dir] = store
+		}
+
+		stores = append(stores, store)
+	}
+
+	return stores
+}
+
+func (s *FileObjectStore) alternateWith(objectName string) (*FileObjectStore, bool) {
+	for _, alternate := range s.alternateStores() {
+		if alternate.Has(objectName) {
+			return alternate, true
+		}
+	}
+
+	return nil, false
+}
+
+func IsObjectName(objectName string) bool {
+	if len(objectName) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(objectName)
+
+	return err == nil
+}
+
+func (s *FileObjectStore) loosePath(objectName string) string {
+	return filepath.Join(s.dir, objectName[:2], objectName[2:])
+}
+
+func (s *FileObjectStore) Has(objectName string) bool {
+	if !IsObjectName(objectName) {
+		return false
+	}
+
+	if _, err := os.Stat(s.loosePath(objectName)); err == nil {
+		return true
+	}
+
+	if _, _, found := s.findPackedObject(objectName); found {
+		return true
+	}
+
+	_, found := s.alternateWith(objectName)
+
+	return found
+}
+
+func (s *FileObjectStore) Read(objectName string) ([]byte, string, error) {
+	reader, objectType, _, err := s.Stream(objectName)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading object %s: %w", objectName, err)
+	}
+
+	return data, objectType, nil
+}
+
+func (s *FileObjectStore) Stream(objectName string) (io.ReadCloser, string, int64, error) {
+	if !IsObjectName(objectName) {
+		return nil, "", 0, fmt.Errorf("invalid object name %q", objectName)
+	}
+
+	file, err := os.Open(s.loosePath(objectName))
+
+	if errors.Is(err, os.ErrNotExist) {
+		if _, _, found := s.findPackedObject(objectName); !found {
+			if alternate, found := s.alternateWith(objectName); found {
+				return alternate.Stream(objectName)
+			}
+		}
+
+		// a packed object may be a delta, so it has to be built in memory anyway
+		data, objectType, err := s.readPackedObject(objectName, 0)
+
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), objectType, int64(len(data)), nil
+	}
+
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	reader, err := zlib.NewReader(file)
+
+	if err != nil {
+		file.Close()
+		return nil, "", 0, fmt.Errorf("error creating zlib reader: %w", err)
+	}
+
+	// <object type> <length>\0<content>
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.ReadString('\000')
+
+	if err != nil {
+		reader.Close()
+		file.Close()
+		return nil, "", 0, errors.New("invalid object header")
+	}
+
+	objectType, sizeStr, found := strings.Cut(strings.TrimSuffix(header, "\000"), " ")
+	size, sizeErr := strconv.ParseInt(sizeStr, 10, 64)
+
+	if !found || sizeErr != nil {
+		reader.Close()
+		file.Close()
+		return nil, "", 0, errors.New("invalid object header")
+	}
+
+	return &looseObjectReader{Reader: io.LimitReader(buffered, size), zlib: reader, file: file}, objectType, size, nil
+}
+
+type looseObjectReader struct {
+	io.Reader
+	zlib io.ReadCloser
+	file *os.File
+}
+
+func (r *looseObjectReader) Close() error {
+	r.zlib.Close()
+	return r.file.Close()
+}
+
+// objects are immutable, so an object that is already there is left alone.
+// the object is written to a temporary file first so that a concurrent
+// reader never sees half of it
+func (s *FileObjectStore) Write(objectType string, content []byte) (string, error) {
+	hash, fullContent := GetObjectSHA(content, objectType)
+	objectName := hex.EncodeToString(hash[:])
+
+	if s.Has(objectName) {
+		return objectName, nil
+	}
+
+	objectDir := filepath.Join(s.dir, objectName[:2])
+
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating object directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(objectDir, "tmp_obj_")
+
+	if err != nil {
+		return "", fmt.Errorf("error creating object file: %w", err)
+	}
+
+	err = CompressIntoFile(file, fullContent)
+	file.Close()
+
+	if err == nil {
+		err = os.Rename(file.Name(), s.loosePath(objectName))
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return objectName, nil
+}
+
+func (s *FileObjectStore) loadPackIndex(indexPath string) (*packIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index, ok := s.packIndexes[indexPath]; ok {
+		return index, nil
+	}
+
+	index, err := loadPackIndex(indexPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.packIndexes[indexPath] = index
+
+	return index, nil
+}
+
+// look the object up in every pack of the store, new packs
+// are picked up as soon as their .idx has been written
+func (s *FileObjectStore) findPackedObject(objectName string) (*packIndex, uint64, bool) {
+	hash, err := hex.DecodeString(objectName)
+
+	if err != nil || len(hash) != 20 {
+		return nil, 0, false
+	}
+
+	indexPaths, _ := filepath.Glob(filepath.Join(s.dir, "pack", "pack-*.idx"))
+
+	for _, indexPath := range indexPaths {
+		index, err := s.loadPackIndex(indexPath)
+
+		if err != nil {
+			continue
+		}
+
+		if offset, ok := index.find(hash); ok {
+			return index, offset, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+func (s *FileObjectStore) readPackedObject(objectName string, depth int) ([]byte, string, error) {
+	index, offset, ok := s.findPackedObject(objectName)
+
+	if !ok {
+		return nil, "", fmt.Errorf("object %s not found", objectName)
+	}
+
+	pack, err := os.Open(index.packPath)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer pack.Close()
+
+	return readPackedObject(pack, int64(offset), depth, s.readDeltaBase)
+}
+
+// the base of a ref-delta. a packed one counts towards the depth of the
+// chain, which keeps deltas that are each other's base from going round
+// forever
+func (s *FileObjectStore) readDeltaBase(objectName string, depth int) ([]byte, string, error) {
+	if _, _, found := s.findPackedObject(objectName); found {
+		return s.readPackedObject(objectName, depth)
+	}
+
+	return s.Read(objectName)
+}
@@ -0,0 +1,555 @@
+package helper
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrTruncatedPack    = errors.New("truncated pack file")
+	ErrChecksumMismatch = errors.New("pack file checksum mismatch")
+	ErrBadZlibStream    = errors.New("bad zlib stream")
+)
+
+// PackError tells which object of the pack file could not be read,
+// Offset is the offset of the object header (the type byte)
+type PackError struct {
+	Offset int
+	Err    error
+}
+
+func (e *PackError) Error() string {
+	return fmt.Sprintf("pack file offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *PackError) Unwrap() error {
+	return e.Err
+}
+
+// one object of a pack file as recorded in its .idx
+type PackIndexEntry struct {
+	SHA    [20]byte
+	Offset uint64
+	CRC32  uint32
+}
+
+// https://github.com/git/git/blob/795ea8776befc95ea2becd8020c7a284677b4161/Documentation/gitformat-pack.txt
+// version 2 pack-*.idx files support packs larger than 4 GiB
+//
+//	<\377tOc> <version 2>
+//	<fanout table>           256 x 4-byte, entry N is the number of objects
+//	                         whose first SHA byte is <= N
+//	<sorted object names>    N x 20-byte SHA
+//	<crc32 of packed data>   N x 4-byte, same order as the names
+//	<offsets>                N x 4-byte, if the MSB is set the rest is an
+//	                         index into the next table
+//	<large offsets>          8-byte offsets for objects past 2^31
+//	<pack checksum>          copy of the 20-byte trailer of the pack
+//	<index checksum>         SHA-1 of everything above
+func BuildPackIndex(entries []PackIndexEntry, packChecksum []byte) []byte {
+	sorted := append([]PackIndexEntry{}, entries...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].SHA[:], sorted[j].SHA[:]) < 0
+	})
+
+	index := bytes.Buffer{}
+	index.Write([]byte{0xff, 't', 'O', 'c'})
+	binary.Write(&index, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+
+	for _, entry := range sorted {
+		fanout[entry.SHA[0]]++
+	}
+
+	var total uint32
+
+	for i := range fanout {
+		total += fanout[i]
+		binary.Write(&index, binary.BigEndian, total)
+	}
+
+	for _, entry := range sorted {
+		index.Write(entry.SHA[:])
+	}
+
+	for _, entry := range sorted {
+		binary.Write(&index, binary.BigEndian, entry.CRC32)
+	}
+
+	largeOffsets := []uint64{}
+
+	for _, entry := range sorted {
+		if entry.Offset < 0x80000000 {
+			binary.Write(&index, binary.BigEndian, uint32(entry.Offset))
+			continue
+		}
+
+		binary.Write(&index, binary.BigEndian, uint32(0x80000000|len(largeOffsets)))
+		largeOffsets = append(largeOffsets, entry.Offset)
+	}
+
+	for _, offset := range largeOffsets {
+		binary.Write(&index, binary.BigEndian, offset)
+	}
+
+	index.Write(packChecksum)
+
+	checksum := sha1.Sum(index.Bytes())
+	index.Write(checksum[:])
+
+	return index.Bytes()
+}
+
+// objects/pack/tmp_pack_*, where a pack is spilled while it is read
+func (r *Repository) CreateTempPack() (*os.File, error) {
+	packDir := filepath.Join(r.GitDir, "objects", "pack")
+
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating pack directory: %w", err)
+	}
+
+	return os.CreateTemp(packDir, "tmp_pack_")
+}
+
+// the equivalent of git index-pack, the pack is kept as it was received:
+// the temporary file it was spilled to becomes
+// <git dir>/objects/pack/pack-<checksum>.pack next to a generated .idx.
+// the .idx is written last, a pack without one is ignored by readers
+func (r *Repository) SavePack(tempPath string, packChecksum []byte, entries []PackIndexEntry) (string, error) {
+	packDir := filepath.Join(r.GitDir, "objects", "pack")
+	packName := fmt.Sprintf("pack-%x", packChecksum)
+
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		return "", fmt.Errorf("error writing pack file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, filepath.Join(packDir, packName+".pack")); err != nil {
+		return "", fmt.Errorf("error writing pack file: %w", err)
+	}
+
+	index := BuildPackIndex(entries, packChecksum)
+
+	err := os.WriteFile(filepath.Join(packDir, packName+".idx"), index, 0644)
+
+	if err != nil {
+		return "", fmt.Errorf("error writing pack index: %w", err)
+	}
+
+	return packName, nil
+}
+
+// a pack-*.idx loaded in memory, the names are kept as one
+// byte slice so the binary search can compare in place
+type packIndex struct {
+	packPath     string
+	fanout       [256]uint32
+	names        []byte
+	offsets      []byte
+	largeOffsets []byte
+}
+
+func loadPackIndex(indexPath string) (*packIndex, error) {
+	data, err := os.ReadFile(indexPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8+256*4+20+20 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) {
+		return nil, fmt.Errorf("%s: not a version 2 pack index", indexPath)
+	}
+
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version %d", indexPath, version)
+	}
+
+	index := &packIndex{packPath: strings.TrimSuffix(indexPath, ".idx") + ".pack"}
+
+	for i := range index.fanout {
+		index.fanout[i] = binary.BigEndian.Uint32(data[8+i*4:])
+	}
+
+	count := int(index.fanout[255])
+	namesStart := 8 + 256*4
+	crcsStart := namesStart + count*20
+	offsetsStart := crcsStart + count*4
+	largeOffsetsStart := offsetsStart + count*4
+
+	if largeOffsetsStart+40 > len(data) {
+		return nil, fmt.Errorf("%s: %w", indexPath, ErrTruncatedPack)
+	}
+
+	index.names = data[namesStart:crcsStart]
+	index.offsets = data[offsetsStart:largeOffsetsStart]
+	index.largeOffsets = data[largeOffsetsStart : len(data)-40]
+
+	return index, nil
+}
+
+// the fanout narrows the search down to the objects sharing the first
+// byte of the name, the sorted names are then binary searched
+func (index *packIndex) find(hash []byte) (uint64, bool) {
+	low := 0
+
+	if hash[0] > 0 {
+		low = int(index.fanout[hash[0]-1])
+	}
+
+	high := int(index.fanout[hash[0]])
+
+	position := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(index.names[(low+i)*20:(low+i+1)*20], hash) >= 0
+	})
+
+	if position >= high || !bytes.Equal(index.names[position*20:(position+1)*20], hash) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(index.offsets[position*4:])
+
+	// MSB set, the rest is an index into the 8-byte offset table
+	if offset&0x80000000 != 0 {
+		largeOffset := int(offset&0x7fffffff) * 8
+
+		return binary.BigEndian.Uint64(index.largeOffsets[largeOffset:]), true
+	}
+
+	return uint64(offset), true
+}
+
+// git pack-objects does not make longer delta chains than this. a longer
+// one, or one that goes round in circles through ref-deltas, is a broken pack
+const maxDeltaDepth = 4095
+
+// seek to the entry and inflate it, deltas are applied on top of their
+// base which is read the same way (ofs-delta) or looked up by name (ref-delta).
+// depth is how many deltas are waiting for this object to be their base
+func readPackedObject(pack *os.File, objectStart int64, depth int, readObject func(string, int) ([]byte, string, error)) ([]byte, string, error) {
+	if depth > maxDeltaDepth {
+		return nil, "", &PackError{Offset: int(objectStart), Err: fmt.Errorf("delta chain is longer than %d", maxDeltaDepth)}
+	}
+
+	// enough for the type and size varint plus an ofs-delta offset or a ref-delta name
+	header := make([]byte, 64)
+	n, err := pack.ReadAt(header, objectStart)
+
+	if n == 0 && err != nil {
+		return nil, "", &PackError{Offset: int(objectStart), Err: ErrTruncatedPack}
+	}
+
+	header = header[:n]
+
+	objectType, size, offset, err := ReadObjectHeader(header)
+
+	if err != nil {
+		return nil, "", &PackError{Offset: int(objectStart), Err: err}
+	}
+
+	var (
+		baseObject []byte
+		baseType   string
+	)
+
+	switch objectType {
+	case "ofs-delta":
+		deltaOffset, processedOffset, err := ReadDeltaOffset(header[offset:])
+
+		if err != nil {
+			return nil, "", &PackError{Offset: int(objectStart), Err: err}
+		}
+
+		offset += processedOffset
+
+		// the base comes before the delta, and after the 12 byte pack header
+		if deltaOffset <= 0 || objectStart-deltaOffset < 12 {
+			return nil, "", &PackError{Offset: int(objectStart), Err: errors.New("ofs-delta base points outside the pack file")}
+		}
+
+		baseObject, baseType, err = readPackedObject(pack, objectStart-deltaOffset, depth+1, readObject)
+
+		if err != nil {
+			return nil, "", err
+		}
+	case "ref-delta":
+		if offset+20 > len(header) {
+			return nil, "", &PackError{Offset: int(objectStart), Err: ErrTruncatedPack}
+		}
+
+		baseObject, baseType, err = readObject(hex.EncodeToString(header[offset:offset+20]), depth+1)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		offset += 20
+	}
+
+	data, err := inflatePackData(pack, objectStart, objectStart+int64(offset), size)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if objectType != "ofs-delta" && objectType != "ref-delta" {
+		return data, objectType, nil
+	}
+
+	undeltifiedObject, err := BuildDeltaObject(baseObject, data)
+
+	if err != nil {
+		return nil, "", &PackError{Offset: int(objectStart), Err: err}
+	}
+
+	return undeltifiedObject, baseType, nil
+}
+
+// the zlib stream of the entry at objectStart, starting at dataStart.
+// size is the one from the header, a big object is read without the
+// buffer growing and copying along the way
+func inflatePackData(pack io.ReaderAt, objectStart int64, dataStart int64, size int64) ([]byte, error) {
+	reader, err := zlib.NewReader(io.NewSectionReader(pack, dataStart, math.MaxInt64-dataStart))
+
+	if err != nil {
+		return nil, &PackError{Offset: int(objectStart), Err: fmt.Errorf("%w: %v", ErrBadZlibStream, err)}
+	}
+
+	defer reader.Close()
+
+	// the size comes from the pack so it is not trusted with an allocation,
+	// the buffer grows as the data comes and stops one byte past the size
+	data, err := io.ReadAll(io.LimitReader(reader, size+1))
+
+	if err != nil {
+		return nil, &PackError{Offset: int(objectStart), Err: fmt.Errorf("%w: %w", ErrBadZlibStream, err)}
+	}
+
+	if int64(len(data)) != size {
+		return nil, &PackError{Offset: int(objectStart), Err: errors.New("object length doesnt match with header")}
+	}
+
+	return data, nil
+}
+
+// an undeltified object to be written into a pack
+type PackObject struct {
+	Type    string
+	Content []byte
+}
+
+// the opposite of ReadObjectHeader
+//
+//	1TTT SSSS  1SSS SSSS  ...  0SSS SSSS
+func EncodeObjectHeader(objectType string, size int) ([]byte, error) {
+	typeNumbers := map[string]byte{"commit": 1, "tree": 2, "blob": 3, "tag": 4, "ofs-delta": 6, "ref-delta": 7}
+	typeNumber, ok := typeNumbers[objectType]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown object type: %s", objectType)
+	}
+
+	header := []byte{typeNumber<<4 | byte(size&15)}
+	size >>= 4
+
+	for size > 0 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+		size >>= 7
+	}
+
+	return header, nil
+}
+
+// one whole object as stored in a pack, the header then the zlib stream
+func encodePackObject(object PackObject) ([]byte, error) {
+	header, err := EncodeObjectHeader(object.Type, len(object.Content))
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := bytes.NewBuffer(header)
+	w := zlib.NewWriter(entry)
+
+	if _, err := w.Write(object.Content); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return entry.Bytes(), nil
+}
+
+// a pack of whole objects, no deltas, the way push sends them
+//
+//	PACK <version 2> <number of objects> <objects...> <sha1 checksum>
+func BuildPack(objects []PackObject) ([]byte, error) {
+	pack := bytes.NewBufferString("PACK")
+	binary.Write(pack, binary.BigEndian, uint32(2))
+	binary.Write(pack, binary.BigEndian, uint32(len(objects)))
+
+	for _, object := range objects {
+		encoded, err := encodePackObject(object)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pack.Write(encoded)
+	}
+
+	checksum := sha1.Sum(pack.Bytes())
+	pack.Write(checksum[:])
+
+	return pack.Bytes(), nil
+}
+
+// BuildPack of the objects in the repository, written as the pack is
+// read instead of held in memory. closing the reader stops the writing
+func (r *Repository) StreamPack(objectNames []string) io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	go func() {
+		writer.CloseWithError(r.writePack(writer, objectNames))
+	}()
+
+	return reader
+}
+
+func (r *Repository) writePack(w io.Writer, objectNames []string) error {
+	checksum := sha1.New()
+	pack := io.MultiWriter(w, checksum)
+
+	header := []byte("PACK")
+	header = binary.BigEndian.AppendUint32(header, 2)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(objectNames)))
+
+	if _, err := pack.Write(header); err != nil {
+		return err
+	}
+
+	for _, objectName := range objectNames {
+		content, objectType, err := r.Objects.Read(objectName)
+
+		if err != nil {
+			return err
+		}
+
+		encoded, err := encodePackObject(PackObject{Type: objectType, Content: content})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := pack.Write(encoded); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(checksum.Sum(nil))
+
+	return err
+}
+
+// git index-pack --fix-thin. a thin pack has ref-deltas against objects the
+// receiver already has, fine on the wire but a pack on disk must be self
+// contained. the bases are read from the object database and appended to
+// the spilled pack as whole objects, then the object count and the trailer
+// are rewritten. returns the new checksum and the index entries of the
+// appended objects. the bases are compressed on up to jobs goroutines
+func (r *Repository) CompleteThinPack(pack *os.File, baseNames []string, jobs int) ([]byte, []PackIndexEntry, error) {
+	info, err := pack.Stat()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	// the old trailer is overwritten
+	end := info.Size() - 20
+	entries := make([]PackIndexEntry, 0, len(baseNames))
+
+	// compressed jobs at a time, then written in order
+	for start := 0; start < len(baseNames); start += jobs {
+		batch := baseNames[start:min(start+jobs, len(baseNames))]
+		encoded := make([][]byte, len(batch))
+		tasks := make([]func() error, len(batch))
+
+		for i, name := range batch {
+			tasks[i] = func() error {
+				content, objectType, err := r.Objects.Read(name)
+
+				if err != nil {
+					return err
+				}
+
+				encoded[i], err = encodePackObject(PackObject{Type: objectType, Content: content})
+
+				return err
+			}
+		}
+
+		if err := RunJobs(jobs, tasks); err != nil {
+			return nil, nil, err
+		}
+
+		for i, name := range batch {
+			entry := PackIndexEntry{Offset: uint64(end), CRC32: crc32.ChecksumIEEE(encoded[i])}
+			hex.Decode(entry.SHA[:], []byte(name))
+			entries = append(entries, entry)
+
+			if _, err := pack.WriteAt(encoded[i], end); err != nil {
+				return nil, nil, err
+			}
+
+			end += int64(len(encoded[i]))
+		}
+	}
+
+	count := make([]byte, 4)
+
+	if _, err := pack.ReadAt(count, 8); err != nil {
+		return nil, nil, err
+	}
+
+	binary.BigEndian.PutUint32(count, binary.BigEndian.Uint32(count)+uint32(len(baseNames)))
+
+	if _, err := pack.WriteAt(count, 8); err != nil {
+		return nil, nil, err
+	}
+
+	checksum := sha1.New()
+
+	if _, err := io.Copy(checksum, io.NewSectionReader(pack, 0, end)); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := pack.WriteAt(checksum.Sum(nil), end); err != nil {
+		return nil, nil, err
+	}
+
+	if err := pack.Truncate(end + 20); err != nil {
+		return nil, nil, err
+	}
+
+	return checksum.Sum(nil), entries, nil
+}
@@ -0,0 +1,568 @@
+package helper
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(data)
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.Bytes()
+}
+
+// a pack header for count objects followed by body and the SHA-1 trailer
+func packWithTrailer(count byte, body []byte) []byte {
+	pack := append([]byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, count}, body...)
+	checksum := sha1.Sum(pack)
+
+	return append(pack, checksum[:]...)
+}
+
+// a pack of whole objects, each named and checked as it is read
+func TestPackReader(t *testing.T) {
+	objects := []PackObject{{Type: "blob", Content: []byte("hello, pack\n")}, {Type: "commit", Content: []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\nempty\n")}}
+	pack, err := BuildPack(objects)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spilled bytes.Buffer
+	reader, err := NewPackReader(bytes.NewReader(pack), &spilled)
+
+	if err != nil || reader.NumObjects != 2 {
+		t.Fatalf("NewPackReader = %v, %v, want 2 objects", reader, err)
+	}
+
+	entries := []*PackEntry{}
+
+	for {
+		entry, err := reader.Next()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if entry == nil {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+
+	checksum, err := reader.Finish()
+
+	if err != nil || !bytes.Equal(checksum, pack[len(pack)-20:]) {
+		t.Errorf("Finish = %x, %v, want the trailer %x", checksum, err, pack[len(pack)-20:])
+	}
+
+	if !bytes.Equal(spilled.Bytes(), pack) {
+		t.Errorf("spilled %d bytes, not the %d of the pack", spilled.Len(), len(pack))
+	}
+
+	for i, entry := range entries {
+		name, _ := GetObjectSHA(objects[i].Content, objects[i].Type)
+		end := int64(len(pack) - 20)
+
+		if i+1 < len(entries) {
+			end = entries[i+1].Offset
+		}
+
+		if entry.SHA != fmt.Sprintf("%x", name) || entry.Type != objects[i].Type || entry.Size != int64(len(objects[i].Content)) {
+			t.Errorf("entry %d = %+v, want %s %x", i, entry, objects[i].Type, name)
+		}
+
+		if crc := crc32.ChecksumIEEE(pack[entry.Offset:end]); crc != entry.CRC32 {
+			t.Errorf("entry %d crc %#x, want %#x", i, entry.CRC32, crc)
+		}
+
+		data, err := ReadPackEntryData(bytes.NewReader(pack), entry)
+
+		if err != nil || !bytes.Equal(data, objects[i].Content) {
+			t.Errorf("ReadPackEntryData(%d) = %q, %v", i, data, err)
+		}
+	}
+}
+
+func TestPackReaderErrors(t *testing.T) {
+	content := []byte("hello, pack\n")
+	good := packWithTrailer(1, append(packEntryHeader(3, len(content)), deflate(t, content)...))
+
+	corrupt := append([]byte{}, good...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	wrongSize := packWithTrailer(1, append(packEntryHeader(3, len(content)+1), deflate(t, content)...))
+	notZlib := packWithTrailer(1, append(packEntryHeader(3, 3), "not zlib"...))
+	// an ofs-delta whose base would be before the pack header
+	outside := packWithTrailer(1, append(packEntryHeader(6, 3), 0x40))
+
+	tests := []struct {
+		name   string
+		pack   []byte
+		err    error
+		offset int
+	}{
+		{"checksum mismatch", corrupt, ErrChecksumMismatch, len(good) - 20},
+		{"no trailer", good[:len(good)-20], ErrTruncatedPack, len(good) - 20},
+		{"object cut short", good[:20], ErrTruncatedPack, 12},
+		{"size does not match the header", wrongSize, nil, 12},
+		{"not a zlib stream", notZlib, ErrBadZlibStream, 12},
+		{"ofs-delta base outside the pack", outside, nil, 12},
+	}
+
+	for _, test := range tests {
+		reader, err := NewPackReader(bytes.NewReader(test.pack), io.Discard)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = reader.Next()
+
+		if err == nil {
+			_, err = reader.Finish()
+		}
+
+		var packError *PackError
+
+		if !errors.As(err, &packError) || packError.Offset != test.offset || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want a PackError at offset %d wrapping %v", test.name, err, test.offset, test.err)
+		}
+	}
+
+	for _, header := range [][]byte{nil, []byte("PACK\x00\x00\x00"), []byte("KCAP\x00\x00\x00\x02\x00\x00\x00\x00"), []byte("PACK\x00\x00\x00\x03\x00\x00\x00\x00")} {
+		if _, err := NewPackReader(bytes.NewReader(header), io.Discard); err == nil {
+			t.Errorf("NewPackReader(%q) did not fail", header)
+		}
+	}
+}
+
+// a spill that cannot be written fails the read, not a zlib error
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestPackReaderSpillError(t *testing.T) {
+	content := bytes.Repeat([]byte("spilled "), 8192)
+	pack := packWithTrailer(1, append(packEntryHeader(3, len(content)), deflate(t, content)...))
+
+	reader, err := NewPackReader(bytes.NewReader(pack), failingWriter{})
+
+	if err == nil {
+		_, err = reader.Next()
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "no space left on device") {
+		t.Errorf("got %v, want the spill error", err)
+	}
+}
+
+func TestPackIndex(t *testing.T) {
+	name := func(first byte, last byte) [20]byte {
+		var sha [20]byte
+		sha[0], sha[19] = first, last
+
+		return sha
+	}
+
+	// out of order, two names share a first byte, offsets past 2^31 and 2^32
+	entries := []PackIndexEntry{
+		{SHA: name(0xff, 1), Offset: 0x1_2345_6789, CRC32: 0xffff},
+		{SHA: name(0x00, 2), Offset: 40, CRC32: 0x0002},
+		{SHA: name(0x7f, 1), Offset: 0x8000_0000, CRC32: 0x7f01},
+		{SHA: name(0x00, 1), Offset: 12, CRC32: 0x0001},
+	}
+	packChecksum := bytes.Repeat([]byte{0xab}, 20)
+	data := BuildPackIndex(entries, packChecksum)
+
+	indexChecksum := sha1.Sum(data[:len(data)-20])
+
+	if !bytes.Equal(data[len(data)-20:], indexChecksum[:]) || !bytes.Equal(data[len(data)-40:len(data)-20], packChecksum) {
+		t.Fatalf("the index does not end with the pack checksum and its own")
+	}
+
+	indexPath := filepath.Join(t.TempDir(), "pack-test.idx")
+
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := loadPackIndex(indexPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for first, want := range map[int]uint32{0x00: 2, 0x01: 2, 0x7e: 2, 0x7f: 3, 0xfe: 3, 0xff: 4} {
+		if index.fanout[first] != want {
+			t.Errorf("fanout[%#x] = %d, want %d", first, index.fanout[first], want)
+		}
+	}
+
+	// the CRCs follow the sorted names
+	crcsStart := 8 + 256*4 + 4*20
+
+	for i, want := range []uint32{0x0001, 0x0002, 0x7f01, 0xffff} {
+		if crc := binary.BigEndian.Uint32(data[crcsStart+i*4:]); crc != want {
+			t.Errorf("crc %d = %#x, want %#x", i, crc, want)
+		}
+	}
+
+	for _, entry := range entries {
+		if offset, ok := index.find(entry.SHA[:]); !ok || offset != entry.Offset {
+			t.Errorf("find(%x) = %#x, %v, want %#x", entry.SHA, offset, ok, entry.Offset)
+		}
+	}
+
+	for _, missing := range [][20]byte{name(0x00, 3), name(0x80, 0), name(0xff, 0)} {
+		if offset, ok := index.find(missing[:]); ok {
+			t.Errorf("find(%x) = %#x, want not found", missing, offset)
+		}
+	}
+}
+
+// the type and size header of a pack entry
+func packEntryHeader(objectType byte, size int) []byte {
+	header := []byte{objectType<<4 | byte(size&0x0f)}
+	size >>= 4
+
+	for size > 0 {
+		header[len(header)-1] |= 0x80
+		header = append(header, byte(size&0x7f))
+		size >>= 7
+	}
+
+	return header
+}
+
+func TestReadPackedObject(t *testing.T) {
+	base := []byte("the base object, long enough to copy from\n")
+	deltaData := delta(len(base), 13, 0x90, 8, 0x05, ' ', 'b', 'l', 'o', 'b')
+
+	pack := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 2}
+	baseStart := len(pack)
+	pack = append(pack, packEntryHeader(3, len(base))...)
+	pack = append(pack, deflate(t, base)...)
+
+	deltaStart := len(pack)
+	// an ofs-delta, its base is deltaStart-baseStart bytes back
+	pack = append(pack, packEntryHeader(6, len(deltaData))...)
+	pack = append(pack, byte(deltaStart-baseStart))
+	pack = append(pack, deflate(t, deltaData)...)
+
+	packPath := filepath.Join(t.TempDir(), "pack-test.pack")
+
+	if err := os.WriteFile(packPath, packWithTrailer(2, pack[12:]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(packPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	tests := []struct {
+		start      int
+		want       string
+		objectType string
+	}{
+		{baseStart, string(base), "blob"},
+		{deltaStart, "the base blob", "blob"},
+	}
+
+	// only ref-deltas look their base up by name
+	noObjects := func(objectName string, depth int) ([]byte, string, error) {
+		return nil, "", fmt.Errorf("object %s not found", objectName)
+	}
+
+	for _, test := range tests {
+		content, objectType, err := readPackedObject(file, int64(test.start), 0, noObjects)
+
+		if err != nil || string(content) != test.want || objectType != test.objectType {
+			t.Errorf("readPackedObject at %d = %q, %s, %v, want %q, %s", test.start, content, objectType, err, test.want, test.objectType)
+		}
+	}
+}
+
+func TestReadPackedObjectBadDelta(t *testing.T) {
+	deltaData := delta(1, 1, 0x90, 1)
+	// every entry starts right after the pack header
+	entry := func(header ...[]byte) []byte {
+		data := []byte{}
+
+		for _, part := range header {
+			data = append(data, part...)
+		}
+
+		return append(data, deflate(t, deltaData)...)
+	}
+
+	selfName := make([]byte, 20)
+
+	tests := []struct {
+		name  string
+		entry []byte
+		want  string
+	}{
+		{"ofs-delta on itself", entry(packEntryHeader(6, len(deltaData)), []byte{0}), "outside the pack file"},
+		{"ofs-delta before the pack", entry(packEntryHeader(6, len(deltaData)), []byte{1}), "outside the pack file"},
+		{"ref-delta on itself", entry(packEntryHeader(7, len(deltaData)), selfName), "delta chain is longer than"},
+	}
+
+	for _, test := range tests {
+		packPath := filepath.Join(t.TempDir(), "pack-test.pack")
+
+		if err := os.WriteFile(packPath, packWithTrailer(1, test.entry), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(packPath)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the base of the ref-delta is the ref-delta, round and round
+		var readSelf func(string, int) ([]byte, string, error)
+		readSelf = func(objectName string, depth int) ([]byte, string, error) {
+			return readPackedObject(file, 12, depth, readSelf)
+		}
+
+		_, _, err = readPackedObject(file, 12, 0, readSelf)
+		file.Close()
+
+		var packError *PackError
+
+		if !errors.As(err, &packError) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want a pack error with %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadPackedObjectWrongSize(t *testing.T) {
+	content := []byte("ten bytes\n")
+
+	// the header is all there is to go on until the data is inflated
+	for _, size := range []int{len(content) - 1, len(content) + 1, 1 << 40} {
+		pack := append(packEntryHeader(3, size), deflate(t, content)...)
+		packPath := filepath.Join(t.TempDir(), "pack-test.pack")
+
+		if err := os.WriteFile(packPath, packWithTrailer(1, pack), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(packPath)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err = readPackedObject(file, 12, 0, nil)
+		file.Close()
+
+		var packError *PackError
+
+		if !errors.As(err, &packError) || !strings.Contains(err.Error(), "length doesnt match") {
+			t.Errorf("a blob of %d bytes with a header of %d: got %v", len(content), size, err)
+		}
+	}
+}
+
+func TestEncodeObjectHeader(t *testing.T) {
+	tests := []struct {
+		objectType string
+		size       int
+		want       []byte
+	}{
+		{"blob", 0, []byte{0x30}},
+		{"commit", 15, []byte{0x1f}},
+		{"tree", 16, []byte{0xa0, 0x01}},
+		{"tag", 0x7ff, []byte{0xcf, 0x7f}},
+		{"ref-delta", 0x800, []byte{0xf0, 0x80, 0x01}},
+		{"ofs-delta", 1 << 30, []byte{0xe0, 0x80, 0x80, 0x80, 0x20}},
+	}
+
+	for _, test := range tests {
+		header, err := EncodeObjectHeader(test.objectType, test.size)
+
+		if err != nil || !bytes.Equal(header, test.want) {
+			t.Errorf("EncodeObjectHeader(%s, %d) = %x, %v, want %x", test.objectType, test.size, header, err, test.want)
+			continue
+		}
+
+		objectType, size, length, err := ReadObjectHeader(header)
+
+		if err != nil || objectType != test.objectType || size != int64(test.size) || length != len(header) {
+			t.Errorf("ReadObjectHeader(%x) = %s, %d, %d, %v", header, objectType, size, length, err)
+		}
+	}
+
+	if _, err := EncodeObjectHeader("note", 1); err == nil {
+		t.Errorf("EncodeObjectHeader of an unknown type did not fail")
+	}
+}
+
+func TestCompleteThinPack(t *testing.T) {
+	base := []byte("a base the receiver already has\n")
+	baseName, _ := GetObjectSHA(base, "blob")
+	deltaData := delta(len(base), 6, 0x90, 6)
+
+	// a ref-delta against an object that is not in the pack
+	body := packEntryHeader(7, len(deltaData))
+	body = append(body, baseName[:]...)
+	body = append(body, deflate(t, deltaData)...)
+
+	thin := packWithTrailer(1, body)
+
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Objects.Write("blob", base); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := repo.CreateTempPack()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if _, err := file.Write(thin); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, entries, err := repo.CompleteThinPack(file, []string{fmt.Sprintf("%x", baseName)}, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completed, err := os.ReadFile(file.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the trailer is checked while the completed pack is read again
+	reader, err := NewPackReader(bytes.NewReader(completed), io.Discard)
+
+	if err != nil || reader.NumObjects != 2 {
+		t.Fatalf("NewPackReader = %v, %v, want 2 objects", reader, err)
+	}
+
+	for entry, err := reader.Next(); entry != nil || err != nil; entry, err = reader.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if trailer, err := reader.Finish(); err != nil || !bytes.Equal(trailer, checksum) {
+		t.Fatalf("the completed pack has a bad trailer: %x, %v, want %x", trailer, err, checksum)
+	}
+
+	if !bytes.Equal(completed[12:len(thin)-20], body) {
+		t.Errorf("the thin pack's own objects changed")
+	}
+
+	if len(entries) != 1 || entries[0].SHA != baseName || entries[0].Offset != uint64(len(thin)-20) {
+		t.Fatalf("entries %+v, want the base at %d", entries, len(thin)-20)
+	}
+
+	if crc := crc32.ChecksumIEEE(completed[entries[0].Offset : len(completed)-20]); crc != entries[0].CRC32 {
+		t.Errorf("crc %#x, want %#x", entries[0].CRC32, crc)
+	}
+
+	// the delta finds its base in the same pack now
+	readBase := func(objectName string, depth int) ([]byte, string, error) {
+		if objectName != fmt.Sprintf("%x", baseName) {
+			return nil, "", fmt.Errorf("object %s not found", objectName)
+		}
+
+		return readPackedObject(file, int64(entries[0].Offset), depth, nil)
+	}
+
+	for _, test := range []struct {
+		start int64
+		want  string
+	}{
+		{int64(entries[0].Offset), string(base)},
+		{12, "a base"},
+	} {
+		content, objectType, err := readPackedObject(file, test.start, 0, readBase)
+
+		if err != nil || string(content) != test.want || objectType != "blob" {
+			t.Errorf("readPackedObject at %d = %q, %s, %v, want %q", test.start, content, objectType, err, test.want)
+		}
+	}
+
+	if _, _, err := repo.CompleteThinPack(file, []string{strings.Repeat("0", 40)}, 2); err == nil {
+		t.Errorf("completing a pack with a missing base did not fail")
+	}
+}
+
+func TestSavePack(t *testing.T) {
+	repo, err := InitRepository(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack := packWithTrailer(0, nil)
+	file, err := repo.CreateTempPack()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write(pack)
+	file.Close()
+
+	packName, err := repo.SavePack(file.Name(), pack[len(pack)-20:], nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(file.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the temporary pack is still there: %v", err)
+	}
+
+	if want := fmt.Sprintf("pack-%x", pack[len(pack)-20:]); packName != want {
+		t.Errorf("SavePack = %s, want %s", packName, want)
+	}
+
+	for _, extension := range []string{".pack", ".idx"} {
+		if _, err := os.Stat(filepath.Join(repo.GitDir, "objects", "pack", fmt.Sprintf("pack-%x", pack[len(pack)-20:])+extension)); err != nil {
+			t.Errorf("no %s: %v", extension, err)
+		}
+	}
+}
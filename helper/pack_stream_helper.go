@@ -0,0 +1,340 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// one entry of a pack as it was read, the data itself is not kept.
+// SHA is known right away for whole objects, for deltas only once
+// they are resolved
+type PackEntry struct {
+	// of the header, the type byte
+	Offset int64
+	// of the zlib stream after the header and the base
+	DataOffset int64
+	// commit, tree, blob, tag, ofs-delta or ref-delta
+	Type string
+	// inflated size, of the delta instructions for deltas
+	Size       int64
+	BaseOffset int64
+	BaseSHA    string
+	SHA        string
+	CRC32      uint32
+}
+
+// git index-pack --stdin, the pack is read once as it comes from the
+// network. every byte read goes to spill, usually the temporary pack file,
+// and into the SHA-1 of the trailer. entries are inflated on the fly to
+// check them and to name the whole objects, deltas are resolved later
+// from what was spilled
+type PackReader struct {
+	source *bufio.Reader
+	spill  io.Writer
+	hash   hash.Hash
+	crc    hash.Hash32
+	// read but not yet hashed and spilled, written in chunks
+	pending []byte
+	offset  int64
+	// the first error of source or spill, the zlib reader would hide it
+	err error
+
+	NumObjects uint32
+	read       uint32
+}
+
+// reads the 12 byte header
+//
+//	PACK <version 2> <number of objects>
+func NewPackReader(source io.Reader, spill io.Writer) (*PackReader, error) {
+	p := &PackReader{
+		source: bufio.NewReaderSize(source, 65536),
+		spill:  spill,
+		hash:   sha1.New(),
+		crc:    crc32.NewIEEE(),
+	}
+
+	header := make([]byte, 12)
+
+	if _, err := io.ReadFull(p, header); err != nil || !bytes.HasPrefix(header, []byte("PACK")) {
+		if p.err != nil {
+			return nil, p.err
+		}
+
+		return nil, errors.New("invalid packfile: missing PACK signature")
+	}
+
+	// Git currently accepts version number 2 or 3 but
+	// generates version 2 only.
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 {
+		return nil, fmt.Errorf("unsupported packfile version: %d", version)
+	}
+
+	p.NumObjects = binary.BigEndian.Uint32(header[8:12])
+
+	return p, p.flush()
+}
+
+func (p *PackReader) Read(b []byte) (int, error) {
+	n, err := p.source.Read(b)
+	p.consume(b[:n])
+
+	if err != nil && !errors.Is(err, io.EOF) && p.err == nil {
+		p.err = err
+	}
+
+	return n, err
+}
+
+// zlib reads through here byte by byte, which keeps it from reading
+// past the end of its stream into the next entry
+func (p *PackReader) ReadByte() (byte, error) {
+	b, err := p.source.ReadByte()
+
+	if err != nil {
+		if !errors.Is(err, io.EOF) && p.err == nil {
+			p.err = err
+		}
+
+		return 0, err
+	}
+
+	p.pending = append(p.pending, b)
+	p.offset++
+
+	if len(p.pending) >= 32768 {
+		p.flush()
+	}
+
+	return b, nil
+}
+
+func (p *PackReader) consume(b []byte) {
+	p.pending = append(p.pending, b...)
+	p.offset += int64(len(b))
+
+	if len(p.pending) >= 32768 {
+		p.flush()
+	}
+}
+
+func (p *PackReader) flush() error {
+	if len(p.pending) > 0 {
+		p.hash.Write(p.pending)
+		p.crc.Write(p.pending)
+
+		if _, err := p.spill.Write(p.pending); err != nil && p.err == nil {
+			p.err = fmt.Errorf("error spilling pack file: %w", err)
+		}
+
+		p.pending = p.pending[:0]
+	}
+
+	return p.err
+}
+
+// the next entry, nil after the last one
+//
+//	<header> <data>                    commit, tree, blob, tag
+//	<header> <base offset> <data>      ofs-delta
+//	<header> <base name> <data>        ref-delta
+func (p *PackReader) Next() (*PackEntry, error) {
+	if p.read == p.NumObjects {
+		return nil, nil
+	}
+
+	// the .idx keeps a crc32 of the raw entry, header included,
+	// so that a damaged pack can be detected without inflating it
+	if err := p.flush(); err != nil {
+		return nil, err
+	}
+
+	p.crc.Reset()
+
+	entry := &PackEntry{Offset: p.offset}
+	header, err := p.readVarint()
+
+	if err != nil {
+		return nil, p.entryError(entry, err)
+	}
+
+	entry.Type, entry.Size, _, err = ReadObjectHeader(header)
+
+	if err != nil {
+		return nil, p.entryError(entry, fmt.Errorf("error reading object header: %w", err))
+	}
+
+	var object io.Writer = io.Discard
+	objectHash := sha1.New()
+
+	switch entry.Type {
+	case "ofs-delta":
+		// n-byte offset interpreted as a negative offset from the type-byte
+		// of the header of the ofs-delta entry. the base always comes
+		// before the delta in the pack
+		encoded, err := p.readVarint()
+
+		if err != nil {
+			return nil, p.entryError(entry, err)
+		}
+
+		deltaOffset, _, err := ReadDeltaOffset(encoded)
+
+		if err != nil {
+			return nil, p.entryError(entry, err)
+		}
+
+		entry.BaseOffset = entry.Offset - deltaOffset
+
+		if deltaOffset <= 0 || entry.BaseOffset < 12 {
+			return nil, p.entryError(entry, errors.New("ofs-delta base points outside the pack file"))
+		}
+	case "ref-delta":
+		// the name of the base, which may be anywhere in the pack or,
+		// for a thin pack, already in the object database
+		name := make([]byte, 20)
+
+		if _, err := io.ReadFull(p, name); err != nil {
+			return nil, p.entryError(entry, err)
+		}
+
+		entry.BaseSHA = hex.EncodeToString(name)
+	default:
+		// whole objects are named while they are inflated
+		fmt.Fprintf(objectHash, "%s %d\000", entry.Type, entry.Size)
+		object = objectHash
+	}
+
+	entry.DataOffset = p.offset
+
+	reader, err := zlib.NewReader(p)
+
+	if err != nil {
+		return nil, p.entryError(entry, fmt.Errorf("%w: %w", ErrBadZlibStream, err))
+	}
+
+	size, err := io.Copy(object, reader)
+
+	if err == nil {
+		err = reader.Close()
+	}
+
+	if err != nil {
+		return nil, p.entryError(entry, fmt.Errorf("%w: %w", ErrBadZlibStream, err))
+	}
+
+	if size != entry.Size {
+		return nil, p.entryError(entry, errors.New("object length doesnt match with header"))
+	}
+
+	if object != io.Discard {
+		entry.SHA = hex.EncodeToString(objectHash.Sum(nil))
+	}
+
+	if err := p.flush(); err != nil {
+		return nil, err
+	}
+
+	entry.CRC32 = p.crc.Sum32()
+	p.read++
+
+	return entry, nil
+}
+
+// the varints of the object header and the ofs-delta offset, every byte
+// but the last has its MSB set
+func (p *PackReader) readVarint() ([]byte, error) {
+	encoded := []byte{}
+
+	for len(encoded) < 10 {
+		b, err := p.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = append(encoded, b)
+
+		if b&0x80 == 0 {
+			return encoded, nil
+		}
+	}
+
+	return nil, errors.New("varint is too long")
+}
+
+// a stream that ends early means the pack was cut short, unless reading
+// from the network or writing the spill failed
+func (p *PackReader) entryError(entry *PackEntry, err error) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = ErrTruncatedPack
+	}
+
+	return &PackError{Offset: int(entry.Offset), Err: err}
+}
+
+// after the last entry, the pack ends with a SHA-1 checksum of everything
+// before it. returns the checksum, which names the pack
+func (p *PackReader) Finish() ([]byte, error) {
+	if p.read != p.NumObjects {
+		return nil, fmt.Errorf("pack file has %d objects left to read", p.NumObjects-p.read)
+	}
+
+	if err := p.flush(); err != nil {
+		return nil, err
+	}
+
+	checksum := p.hash.Sum(nil)
+	trailer := make([]byte, 20)
+
+	// read past the hash, the trailer is not part of what it covers
+	if _, err := io.ReadFull(p.source, trailer); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = ErrTruncatedPack
+		}
+
+		return nil, &PackError{Offset: int(p.offset), Err: err}
+	}
+
+	if !bytes.Equal(checksum, trailer) {
+		return nil, &PackError{
+			Offset: int(p.offset),
+			Err:    fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, trailer, checksum),
+		}
+	}
+
+	if _, err := p.spill.Write(trailer); err != nil {
+		return nil, fmt.Errorf("error spilling pack file: %w", err)
+	}
+
+	return checksum, nil
+}
+
+// the delta instructions or the content of an entry, read back from the
+// spilled pack
+func ReadPackEntryData(pack io.ReaderAt, entry *PackEntry) ([]byte, error) {
+	data, err := inflatePackData(pack, entry.Offset, entry.DataOffset, entry.Size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != entry.Size {
+		return nil, &PackError{Offset: int(entry.Offset), Err: errors.New("object length doesnt match with header")}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package helper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// a partial clone (clone --filter=blob:none) leaves objects out, the
+// promisor remote promises to send them when they are needed. reading an
+// object that is not here fetches it first, Has only looks at what is here
+// so negotiation and push never trigger a fetch
+type PromisorObjectStore struct {
+	ObjectStore
+	fetch func(objectNames []string) error
+}
+
+func NewPromisorObjectStore(store ObjectStore, fetch func(objectNames []string) error) *PromisorObjectStore {
+	return &PromisorObjectStore{ObjectStore: store, fetch: fetch}
+}
+
+func (s *PromisorObjectStore) Read(objectName string) ([]byte, string, error) {
+	if err := s.fetchMissing(objectName); err != nil {
+		return nil, "", err
+	}
+
+	return s.ObjectStore.Read(objectName)
+}
+
+func (s *PromisorObjectStore) Stream(objectName string) (io.ReadCloser, string, int64, error) {
+	if err := s.fetchMissing(objectName); err != nil {
+		return nil, "", 0, err
+	}
+
+	return s.ObjectStore.Stream(objectName)
+}
+
+func (s *PromisorObjectStore) fetchMissing(objectName string) error {
+	if !IsObjectName(objectName) || s.ObjectStore.Has(objectName) {
+		return nil
+	}
+
+	return s.Prefetch([]string{objectName})
+}
+
+// the missing ones of objectNames in a single fetch, before a checkout
+// needs them one by one
+func (s *PromisorObjectStore) Prefetch(objectNames []string) error {
+	missing := []string{}
+
+	for _, objectName := range objectNames {
+		if !s.ObjectStore.Has(objectName) && !ArrayContains(missing, objectName) {
+			missing = append(missing, objectName)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := s.fetch(missing); err != nil {
+		return fmt.Errorf("could not fetch %d objects from the promisor remote: %w", len(missing), err)
+	}
+
+	return nil
+}
+
+// nothing to do outside a partial clone
+func (r *Repository) PrefetchObjects(objectNames []string) error {
+	promisor, ok := r.Objects.(*PromisorObjectStore)
+
+	if !ok {
+		return nil
+	}
+
+	return promisor.Prefetch(objectNames)
+}
+
+// objects/pack/pack-<checksum>.promisor says the pack came from the
+// promisor remote, git fsck does not expect what it refers to to be here
+func (r *Repository) MarkPromisorPack(packName string) error {
+	return os.WriteFile(filepath.Join(r.GitDir, "objects", "pack", packName+".promisor"), nil, 0644)
+}
@@ -0,0 +1,151 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the packets of protocol v2 that are only a length
+const (
+	flushPacket       = 0
+	delimPacket       = 1
+	responseEndPacket = 2
+)
+
+// a protocol v2 command, https://git-scm.com/docs/protocol-v2#_command_request
+//
+//	0014command=ls-refs\n
+//	0014agent=mygit/0.1\n      capabilities
+//	0001                       delimiter
+//	0009peel\n                 arguments
+//	0000
+func FormatCommandRequest(command string, capabilities []string, arguments []string) string {
+	request := FormatPacketLine("command=" + command + "\n")
+
+	for _, capability := range capabilities {
+		request += FormatPacketLine(capability + "\n")
+	}
+
+	request += "0001"
+
+	for _, argument := range arguments {
+		request += FormatPacketLine(argument + "\n")
+	}
+
+	return request + "0000"
+}
+
+// the answer to a protocol v2 fetch, sections separated by delimiters
+//
+//	acknowledgments        NAK, or ACK <sha> for every have the server has
+//	ACK <sha>              and ready when it can send the pack
+//	ready
+//	0001
+//	shallow-info           shallow <sha> / unshallow <sha> for shallow clones
+//	0001
+//	packfile               side-band pkt-lines until the flush
+//	\x01PACK...
+//	0000
+//
+// https://git-scm.com/docs/protocol-v2#_fetch
+type FetchResponse struct {
+	Acknowledgments []string
+	Ready           bool
+	Shallow         []string
+	Unshallow       []string
+	// nil when the server did not send the pack yet. it is read straight
+	// from the response as it arrives, Close the response when done
+	Pack io.Reader
+
+	body io.Closer
+}
+
+func (r *FetchResponse) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	return r.body.Close()
+}
+
+// a response with only a pack that does not come from a server,
+// closing the response closes the pack
+func NewPackResponse(pack io.ReadCloser) *FetchResponse {
+	return &FetchResponse{Pack: pack, body: pack}
+}
+
+// the body stays open while there is a pack to read from it
+func holdResponseBody(response *FetchResponse, err error, body io.Closer) (*FetchResponse, error) {
+	if err != nil || response.Pack == nil {
+		body.Close()
+		return response, err
+	}
+
+	response.body = body
+
+	return response, nil
+}
+
+func ParseFetchResponse(body io.ReadCloser, progress io.Writer) (*FetchResponse, error) {
+	response, err := parseFetchResponse(NewPacketReader(body), progress)
+
+	return holdResponseBody(response, err, body)
+}
+
+func parseFetchResponse(packets *PacketReader, progress io.Writer) (*FetchResponse, error) {
+	response := &FetchResponse{}
+	section := ""
+
+	for {
+		payload, kind, err := packets.ReadPacket()
+
+		if errors.Is(err, io.EOF) {
+			return response, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case flushPacket, responseEndPacket:
+			return response, nil
+		case delimPacket:
+			section = ""
+			continue
+		}
+
+		line := strings.TrimSuffix(string(payload), "\n")
+
+		if message, found := strings.CutPrefix(line, "ERR "); found {
+			return nil, fmt.Errorf("remote error: %s", message)
+		}
+
+		if section == "" {
+			section = line
+
+			// everything after the section header is side-band
+			if section == "packfile" {
+				response.Pack = NewSideBandReader(packets, progress)
+				return response, nil
+			}
+
+			continue
+		}
+
+		keyword, value, _ := strings.Cut(line, " ")
+
+		switch {
+		case section == "acknowledgments" && keyword == "ACK":
+			response.Acknowledgments = append(response.Acknowledgments, value)
+		case section == "acknowledgments" && keyword == "ready":
+			response.Ready = true
+		case section == "shallow-info" && keyword == "shallow":
+			response.Shallow = append(response.Shallow, value)
+		case section == "shallow-info" && keyword == "unshallow":
+			response.Unshallow = append(response.Unshallow, value)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatCommandRequest(t *testing.T) {
+	got := FormatCommandRequest("ls-refs", []string{"agent=mygit/0.1"}, []string{"peel", "ref-prefix refs/heads/"})
+	want := "0014command=ls-refs\n" +
+		"0014agent=mygit/0.1\n" +
+		"0001" +
+		"0009peel\n" +
+		"001bref-prefix refs/heads/\n" +
+		"0000"
+
+	if got != want {
+		t.Errorf("FormatCommandRequest = %q, want %q", got, want)
+	}
+
+	if got := FormatCommandRequest("fetch", nil, nil); got != "0012command=fetch\n00010000" {
+		t.Errorf("FormatCommandRequest without arguments = %q", got)
+	}
+}
+
+func TestParseFetchResponse(t *testing.T) {
+	a := strings.Repeat("a", 40)
+	b := strings.Repeat("b", 40)
+	line := FormatPacketLine
+
+	tests := []struct {
+		name string
+		data string
+		want FetchResponse
+		// nil when there is no pack
+		pack     []byte
+		progress string
+		err      string
+	}{
+		{
+			"acknowledgments only",
+			line("acknowledgments\n") + line("ACK "+a+"\n") + line("ACK "+b+"\n") + "0000",
+			FetchResponse{Acknowledgments: []string{a, b}},
+			nil,
+			"",
+			"",
+		},
+		{
+			"nothing in common",
+			line("acknowledgments\n") + line("NAK\n") + "0000",
+			FetchResponse{},
+			nil,
+			"",
+			"",
+		},
+		{
+			"ready with the pack",
+			line("acknowledgments\n") + line("ACK "+a+"\n") + line("ready\n") + "0001" +
+				line("shallow-info\n") + line("shallow "+a+"\n") + line("unshallow "+b+"\n") + "0001" +
+				line("packfile\n") + line("\x02Enumerating objects: 3, done.\n") + line("\x01PACK") + line("\x01data") + "0000",
+			FetchResponse{Acknowledgments: []string{a}, Ready: true, Shallow: []string{a}, Unshallow: []string{b}},
+			[]byte("PACKdata"),
+			"remote: Enumerating objects: 3, done.\n",
+			"",
+		},
+		{
+			"empty packfile",
+			line("packfile\n") + "0000",
+			FetchResponse{},
+			[]byte{},
+			"",
+			"",
+		},
+		{"error", line("ERR not our ref " + a + "\n"), FetchResponse{}, nil, "", "remote error: not our ref " + a},
+		{"band 3", line("packfile\n") + line("\x03no space left\n"), FetchResponse{}, nil, "", "remote error: no space left"},
+		{"truncated", line("acknowledgments\n") + "0020ACK", FetchResponse{}, nil, "", "truncated"},
+	}
+
+	for _, test := range tests {
+		var progress bytes.Buffer
+		response, err := ParseFetchResponse(io.NopCloser(strings.NewReader(test.data)), &progress)
+
+		// the pack is read after the sections, progress and errors come with it
+		var pack []byte
+
+		if err == nil && response.Pack != nil {
+			pack, err = io.ReadAll(response.Pack)
+			response.Close()
+		}
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || (pack == nil) != (test.pack == nil) || !bytes.Equal(pack, test.pack) {
+			t.Errorf("%s: pack %q, %v, want %q", test.name, pack, err, test.pack)
+			continue
+		}
+
+		response.Pack, response.body = nil, nil
+
+		if !reflect.DeepEqual(*response, test.want) || progress.String() != test.progress {
+			t.Errorf("%s: got %+v, %q, want %+v, %q", test.name, response, progress.String(), test.want, test.progress)
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	packets := NewPacketReader(strings.NewReader("0000" + "0001" + "0002" + "0008NAK\n" + "0009ab"))
+
+	tests := []struct {
+		payload string
+		kind    int
+	}{
+		{"", flushPacket},
+		{"", delimPacket},
+		{"", responseEndPacket},
+		{"NAK\n", -1},
+	}
+
+	for i, test := range tests {
+		payload, kind, err := packets.ReadPacket()
+
+		if err != nil || string(payload) != test.payload || kind != test.kind {
+			t.Errorf("packet %d = %q, %d, %v, want %q, %d", i, payload, kind, err, test.payload, test.kind)
+		}
+	}
+
+	if _, _, err := packets.ReadPacket(); !errors.Is(err, ErrTruncatedPack) {
+		t.Errorf("a packet cut short: got %v, want ErrTruncatedPack", err)
+	}
+
+	if _, _, err := packets.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("the end of the response: got %v, want io.EOF", err)
+	}
+
+	if _, _, err := NewPacketReader(strings.NewReader("00")).ReadPacket(); !errors.Is(err, ErrTruncatedPack) {
+		t.Errorf("a length cut short: got %v, want ErrTruncatedPack", err)
+	}
+}
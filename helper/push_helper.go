@@ -0,0 +1,147 @@
+package helper
+
+// git rev-list --objects <tips> --not <known>, every object reachable from
+// tips that is not reachable from the known commits, what the other side of
+// a push is missing. tips may be annotated tags, the tag objects are sent too
+//
+// the known history is not walked all the way, only the trees of the known
+// commits and of the parents at the edge of the new history are excluded,
+// like git does. a few objects too many only make the pack bigger
+func (r *Repository) ReachableObjects(tips []string, known []string) ([]string, error) {
+	seen := map[string]bool{}
+	knownCommits := []string{}
+
+	for _, sha := range known {
+		// refs the remote has but we never fetched
+		if !r.Objects.Has(sha) {
+			continue
+		}
+
+		seen[sha] = true
+
+		if commitSHA, err := r.peelToCommit(sha); err == nil {
+			knownCommits = append(knownCommits, commitSHA)
+		}
+	}
+
+	objects := []string{}
+	commits := []string{}
+
+	for _, sha := range tips {
+		commitSHA, err := r.peelToCommit(sha)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if sha != commitSHA && !seen[sha] {
+			objects = append(objects, sha)
+			seen[sha] = true
+		}
+
+		commits = append(commits, commitSHA)
+	}
+
+	walker, err := r.NewCommitWalker(append(commits, knownCommits...))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sha := range knownCommits {
+		walker.Hide(sha)
+	}
+
+	newCommits := []*Commit{}
+	isNew := map[string]bool{}
+
+	for {
+		sha, err := walker.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if sha == "" {
+			break
+		}
+
+		commit, err := r.ReadCommit(sha)
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, sha)
+		newCommits = append(newCommits, commit)
+		isNew[sha] = true
+	}
+
+	// the trees the remote already has, from the known tips and the edge
+	edge := knownCommits
+
+	for _, commit := range newCommits {
+		for _, parent := range commit.Parents {
+			if !isNew[parent] {
+				edge = append(edge, parent)
+			}
+		}
+	}
+
+	for _, sha := range edge {
+		commit, err := r.ReadCommit(sha)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.collectTree(commit.Tree, seen, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, commit := range newCommits {
+		objects, err = r.collectTree(commit.Tree, seen, objects)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+// appends the tree and everything under it that is not in seen yet.
+// submodules are commits of another repository and are left out
+func (r *Repository) collectTree(treeSHA string, seen map[string]bool, objects []string) ([]string, error) {
+	if seen[treeSHA] {
+		return objects, nil
+	}
+
+	seen[treeSHA] = true
+	objects = append(objects, treeSHA)
+
+	entries, err := r.ReadTree(treeSHA)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.Mode == "160000":
+			continue
+		case entry.Mode == "40000":
+			objects, err = r.collectTree(entry.SHA, seen, objects)
+
+			if err != nil {
+				return nil, err
+			}
+		case !seen[entry.SHA]:
+			seen[entry.SHA] = true
+			objects = append(objects, entry.SHA)
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,409 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var ErrRefNotFound = errors.New("ref not found")
+
+// refs live either as loose files (.git/refs/heads/main containing a sha)
+// or in .git/packed-refs once git has packed them
+//
+//	# pack-refs with: peeled fully-peeled sorted
+//	47b37f1a82bfe85f6d8df52b6258b75e4343b7fd refs/heads/master
+//	2cb58b79488a98d2721cea644875a8dd0026b115 refs/tags/v1.0
+//	^a3c2e2402b99163d1d59756e5f207ae21cccba4c
+//
+// a "^" line is the peeled commit of the annotated tag above it
+func (r *Repository) readPackedRefs() (map[string]string, error) {
+	refs := map[string]string{}
+
+	file, err := os.Open(filepath.Join(r.GitDir, "packed-refs"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return refs, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		sha, name, found := strings.Cut(line, " ")
+
+		if found && IsObjectName(sha) {
+			refs[name] = sha
+		}
+	}
+
+	return refs, scanner.Err()
+}
+
+// the raw content of a ref, either "ref: <target>" or a sha
+func (r *Repository) readRefFile(name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(r.GitDir, filepath.FromSlash(name)))
+
+	if err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	// refs/heads/a is a file when looking up refs/heads/a/b, the ref simply does not exist
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+		return "", err
+	}
+
+	packedRefs, err := r.readPackedRefs()
+
+	if err != nil {
+		return "", err
+	}
+
+	if sha, ok := packedRefs[name]; ok {
+		return sha, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrRefNotFound, name)
+}
+
+// the target of a symbolic ref like HEAD, ok is false when it points at a sha
+func (r *Repository) ReadSymbolicRef(name string) (string, bool, error) {
+	content, err := r.readRefFile(name)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	target, found := strings.CutPrefix(content, "ref: ")
+
+	return target, found, nil
+}
+
+// follow symbolic refs down to a sha, an unborn branch
+// (HEAD -> refs/heads/main before the first commit) is ErrRefNotFound
+func (r *Repository) ResolveRef(name string) (string, error) {
+	for depth := 0; depth < 5; depth++ {
+		content, err := r.readRefFile(name)
+
+		if err != nil {
+			return "", err
+		}
+
+		target, found := strings.CutPrefix(content, "ref: ")
+
+		if !found {
+			if !IsObjectName(content) {
+				return "", fmt.Errorf("invalid ref %s: %q", name, content)
+			}
+
+			return content, nil
+		}
+
+		name = target
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic refs at %s", name)
+}
+
+// the all-zero sha, as the old value of UpdateRef it means the ref must not exist yet
+const ZeroSHA = "0000000000000000000000000000000000000000"
+
+// point the ref at newSHA. the ref is locked by creating <ref>.lock, which
+// fails if someone else holds it, and oldSHA (when not empty) must still be
+// its current value so a concurrent update is never silently overwritten
+func (r *Repository) UpdateRef(name string, newSHA string, oldSHA string) error {
+	if !IsObjectName(newSHA) {
+		return fmt.Errorf("invalid sha %q for %s", newSHA, name)
+	}
+
+	return r.writeRef(name, newSHA+"\n", oldSHA)
+}
+
+// make name point at another ref, "ref: refs/heads/main"
+func (r *Repository) WriteSymbolicRef(name string, target string) error {
+	return r.writeRef(name, "ref: "+target+"\n", "")
+}
+
+func (r *Repository) writeRef(name string, content string, oldSHA string) error {
+	refPath := filepath.Join(r.GitDir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+
+	lockPath := refPath + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+
+	defer os.Remove(lockPath)
+
+	if oldSHA != "" {
+		currentSHA, err := r.resolveRefFile(name)
+
+		if errors.Is(err, ErrRefNotFound) {
+			currentSHA = ZeroSHA
+		} else if err != nil {
+			lock.Close()
+			return err
+		}
+
+		if currentSHA != oldSHA {
+			lock.Close()
+			return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", name, currentSHA, oldSHA)
+		}
+	}
+
+	_, err = lock.WriteString(content)
+	lock.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(lockPath, refPath)
+}
+
+// the sha the ref itself holds, without following it if it is symbolic
+func (r *Repository) resolveRefFile(name string) (string, error) {
+	content, err := r.readRefFile(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(content, "ref: ") {
+		return "", fmt.Errorf("%s is a symbolic ref", name)
+	}
+
+	return content, nil
+}
+
+// the ref a new commit moves: the branch HEAD points to, even when it
+// does not exist yet (unborn), or HEAD itself when it is detached
+func (r *Repository) HeadRef() (string, error) {
+	name := "HEAD"
+
+	for depth := 0; depth < 5; depth++ {
+		target, symbolic, err := r.ReadSymbolicRef(name)
+
+		if errors.Is(err, ErrRefNotFound) {
+			return name, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if !symbolic {
+			return name, nil
+		}
+
+		name = target
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic refs at %s", name)
+}
+
+type Ref struct {
+	Name string
+	SHA  string
+}
+
+// every ref under prefix ("refs/heads/", "refs/tags/", "refs/" for all of them),
+// loose refs win over packed ones, symbolic refs are resolved. Sorted by name
+func (r *Repository) ListRefs(prefix string) ([]Ref, error) {
+	packedRefs, err := r.readPackedRefs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]string{}
+
+	for name, sha := range packedRefs {
+		if strings.HasPrefix(name, prefix) {
+			refs[name] = sha
+		}
+	}
+
+	refsDir := filepath.Join(r.GitDir, "refs")
+
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(r.GitDir, path)
+
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(relativePath)
+
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		sha, err := r.ResolveRef(name)
+
+		// a symbolic ref pointing at a ref that does not exist
+		if errors.Is(err, ErrRefNotFound) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		refs[name] = sha
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	result := make([]Ref, 0, len(refs))
+
+	for name, sha := range refs {
+		result = append(result, Ref{Name: name, SHA: sha})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// remove the ref, both the loose file and its packed-refs line.
+// like UpdateRef, oldSHA (when not empty) must be its current value
+func (r *Repository) DeleteRef(name string, oldSHA string) error {
+	refPath := filepath.Join(r.GitDir, filepath.FromSlash(name))
+	lockPath := refPath + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+
+	lock.Close()
+	defer os.Remove(lockPath)
+
+	// a symbolic ref (refs/remotes/origin/HEAD) can only be deleted unconditionally
+	currentSHA, err := r.readRefFile(name)
+
+	if err != nil {
+		return err
+	}
+
+	if oldSHA != "" && currentSHA != oldSHA {
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", name, currentSHA, oldSHA)
+	}
+
+	if err := r.removePackedRef(name); err != nil {
+		return err
+	}
+
+	err = os.Remove(refPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// refs/heads/feature/x leaves an empty refs/heads/feature behind,
+	// refs/heads itself stays
+	os.Remove(lockPath)
+	refsDir := filepath.Join(r.GitDir, "refs")
+
+	for dir := filepath.Dir(refPath); filepath.Dir(dir) != refsDir && strings.HasPrefix(dir, refsDir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// rewrite packed-refs without name and the peeled "^" line that follows it
+func (r *Repository) removePackedRef(name string) error {
+	packedRefsPath := filepath.Join(r.GitDir, "packed-refs")
+	content, err := os.ReadFile(packedRefsPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	kept := strings.Builder{}
+	removed := false
+	skipPeeled := false
+
+	for _, line := range lines {
+		if skipPeeled && strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		skipPeeled = false
+
+		if _, refName, found := strings.Cut(strings.TrimSuffix(line, "\n"), " "); found && refName == name && !strings.HasPrefix(line, "#") {
+			removed = true
+			skipPeeled = true
+			continue
+		}
+
+		kept.WriteString(line)
+	}
+
+	if !removed {
+		return nil
+	}
+
+	lockPath := packedRefsPath + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("cannot lock packed-refs: %w", err)
+	}
+
+	defer os.Remove(lockPath)
+
+	_, err = lock.WriteString(kept.String())
+	lock.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(lockPath, packedRefsPath)
+}
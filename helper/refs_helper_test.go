@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateRef(t *testing.T) {
+	repo := newTestRepository(t)
+	first := blobSHA("first")
+	second := blobSHA("second")
+
+	tests := []struct {
+		name   string
+		newSHA string
+		oldSHA string
+		err    string
+	}{
+		{"create", first, ZeroSHA, ""},
+		{"create again", second, ZeroSHA, "expected " + ZeroSHA},
+		{"stale old value", second, second, "expected " + second},
+		{"advance", second, first, ""},
+		{"no old value", first, "", ""},
+		{"not a sha", "main", first, "invalid sha"},
+	}
+
+	for _, test := range tests {
+		err := repo.UpdateRef("refs/heads/main", test.newSHA, test.oldSHA)
+
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	if sha, err := repo.ResolveRef("HEAD"); err != nil || sha != first {
+		t.Errorf("HEAD resolves to %s, %v, want %s", sha, err, first)
+	}
+
+	// the lock is gone, and a held lock refuses the update
+	lockPath := filepath.Join(repo.GitDir, "refs", "heads", "main.lock")
+
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock left behind: %v", err)
+	}
+
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateRef("refs/heads/main", second, first); err == nil || !strings.Contains(err.Error(), "cannot lock ref") {
+		t.Errorf("update with a held lock: got %v", err)
+	}
+}
+
+func TestHeadRef(t *testing.T) {
+	repo := newTestRepository(t)
+	sha := blobSHA("commit")
+
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"unborn branch", "ref: refs/heads/main\n", "refs/heads/main"},
+		{"other branch", "ref: refs/heads/feature\n", "refs/heads/feature"},
+		{"detached", sha + "\n", "HEAD"},
+	}
+
+	for _, test := range tests {
+		if err := os.WriteFile(filepath.Join(repo.GitDir, "HEAD"), []byte(test.head), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := repo.HeadRef(); err != nil || got != test.want {
+			t.Errorf("%s: HeadRef() = %s, %v, want %s", test.name, got, err, test.want)
+		}
+	}
+
+	if err := repo.WriteSymbolicRef("HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, symbolic, err := repo.ReadSymbolicRef("HEAD"); err != nil || !symbolic || target != "refs/heads/main" {
+		t.Errorf("HEAD is %s, %v, %v after WriteSymbolicRef", target, symbolic, err)
+	}
+}
@@ -0,0 +1,220 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// a git repository, GitDir is the .git directory and WorkTree the directory
+// its files are checked out into (empty for a bare repository).
+// both paths are absolute so nothing depends on the current directory
+type Repository struct {
+	GitDir   string
+	WorkTree string
+	Objects  ObjectStore
+
+	// the commits of .git/shallow, read the first time they are needed
+	shallow map[string]bool
+}
+
+func NewRepository(gitDir string, workTree string) *Repository {
+	return &Repository{
+		GitDir:   gitDir,
+		WorkTree: workTree,
+		Objects:  NewFileObjectStore(filepath.Join(gitDir, "objects")),
+	}
+}
+
+// find the repository path belongs to the way git does:
+//   - $GIT_DIR wins, the work tree is then $GIT_WORK_TREE or path itself
+//   - otherwise walk up from path until a directory has a .git directory
+//     (or a .git file saying "gitdir: <dir>"), or is a bare repository
+//   - $GIT_WORK_TREE overrides the discovered work tree
+func OpenRepository(path string) (*Repository, error) {
+	path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	workTreeEnv := os.Getenv("GIT_WORK_TREE")
+
+	if gitDirEnv := os.Getenv("GIT_DIR"); gitDirEnv != "" {
+		gitDir, err := filepath.Abs(gitDirEnv)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !isGitDir(gitDir) {
+			return nil, fmt.Errorf("not a git repository: '%s'", gitDirEnv)
+		}
+
+		workTree := path
+
+		if workTreeEnv != "" {
+			workTree = workTreeEnv
+		}
+
+		return newRepositoryWithWorkTree(gitDir, workTree)
+	}
+
+	dir := path
+
+	for {
+		gitDir, workTree, found, err := discoverGitDir(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			if workTreeEnv != "" {
+				workTree = workTreeEnv
+			}
+
+			return newRepositoryWithWorkTree(gitDir, workTree)
+		}
+
+		parent := filepath.Dir(dir)
+
+		if parent == dir {
+			return nil, errors.New("not a git repository (or any of the parent directories): .git")
+		}
+
+		dir = parent
+	}
+}
+
+// the repository at exactly path, a work tree with a .git or a bare
+// repository. unlike OpenRepository the parent directories and $GIT_DIR
+// are not looked at, path is the other side of a clone or fetch
+func OpenRepositoryAt(path string) (*Repository, error) {
+	path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gitDir, workTree, found, err := discoverGitDir(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("'%s' does not appear to be a git repository", path)
+	}
+
+	return newRepositoryWithWorkTree(gitDir, workTree)
+}
+
+func newRepositoryWithWorkTree(gitDir string, workTree string) (*Repository, error) {
+	if workTree == "" {
+		return NewRepository(gitDir, ""), nil
+	}
+
+	workTree, err := filepath.Abs(workTree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRepository(gitDir, workTree), nil
+}
+
+// returns the git dir and work tree if dir is the top of a repository
+func discoverGitDir(dir string) (string, string, bool, error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+
+	if err == nil && info.IsDir() && isGitDir(dotGit) {
+		return dotGit, dir, true, nil
+	}
+
+	// worktrees and submodules have a .git file pointing at the real git dir
+	// gitdir: ../.git/worktrees/feature
+	if err == nil && !info.IsDir() {
+		content, err := os.ReadFile(dotGit)
+
+		if err != nil {
+			return "", "", false, err
+		}
+
+		target, found := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+
+		if !found {
+			return "", "", false, fmt.Errorf("invalid gitfile format: %s", dotGit)
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+
+		if !isGitDir(target) {
+			return "", "", false, fmt.Errorf("not a git repository: %s", target)
+		}
+
+		return target, dir, true, nil
+	}
+
+	if isGitDir(dir) {
+		return dir, "", true, nil
+	}
+
+	return "", "", false, nil
+}
+
+// a directory is a git dir when it has a HEAD and an object database
+func isGitDir(dir string) bool {
+	if info, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil || info.IsDir() {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "objects"))
+
+	return err == nil && info.IsDir()
+}
+
+// create the .git directory inside path, an existing HEAD or config is
+// left alone so that running init twice does not switch branches
+func InitRepository(path string) (*Repository, error) {
+	workTree, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gitDir := filepath.Join(workTree, ".git")
+
+	for _, dir := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, dir), 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory: %w", err)
+		}
+	}
+
+	headPath := filepath.Join(gitDir, "HEAD")
+
+	if _, err := os.Stat(headPath); errors.Is(err, os.ErrNotExist) {
+		headFileContents := []byte("ref: refs/heads/main\n")
+
+		if err := os.WriteFile(headPath, headFileContents, 0644); err != nil {
+			return nil, fmt.Errorf("error writing file: %w", err)
+		}
+	}
+
+	configPath := filepath.Join(gitDir, "config")
+
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		configFileContents := []byte("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n")
+
+		if err := os.WriteFile(configPath, configFileContents, 0644); err != nil {
+			return nil, fmt.Errorf("error writing file: %w", err)
+		}
+	}
+
+	return NewRepository(gitDir, workTree), nil
+}
@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// .git/shallow lists the commits of a shallow clone whose parents are not
+// in the repository, one sha per line. like git, those commits are read as
+// if they had no parents so history walks end there instead of failing
+func (r *Repository) shallowPath() string {
+	return filepath.Join(r.GitDir, "shallow")
+}
+
+// the boundary commits, sorted. none for a complete repository
+func (r *Repository) ShallowCommits() ([]string, error) {
+	data, err := os.ReadFile(r.shallowPath())
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []string{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if IsObjectName(line) {
+			commits = append(commits, line)
+		}
+	}
+
+	sort.Strings(commits)
+
+	return commits, nil
+}
+
+func (r *Repository) IsShallow(sha string) bool {
+	if r.shallow == nil {
+		commits, _ := r.ShallowCommits()
+		r.shallow = map[string]bool{}
+
+		for _, commit := range commits {
+			r.shallow[commit] = true
+		}
+	}
+
+	return r.shallow[sha]
+}
+
+// what the server said after a deepen request, "shallow <sha>" adds a
+// boundary and "unshallow <sha>" removes one whose parents came in the
+// pack. the file goes away once nothing is shallow anymore
+func (r *Repository) UpdateShallow(shallow []string, unshallow []string) error {
+	if len(shallow) == 0 && len(unshallow) == 0 {
+		return nil
+	}
+
+	commits, err := r.ShallowCommits()
+
+	if err != nil {
+		return err
+	}
+
+	updated := map[string]bool{}
+
+	for _, sha := range append(commits, shallow...) {
+		updated[sha] = true
+	}
+
+	for _, sha := range unshallow {
+		delete(updated, sha)
+	}
+
+	r.shallow = updated
+
+	if len(updated) == 0 {
+		err := os.Remove(r.shallowPath())
+
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	lines := []string{}
+
+	for sha := range updated {
+		lines = append(lines, sha+"\n")
+	}
+
+	sort.Strings(lines)
+
+	return writeLockedFile(r.shallowPath(), []byte(strings.Join(lines, "")))
+}
@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateShallow(t *testing.T) {
+	repo := newTestRepository(t)
+	root := commitAt(t, repo, 1000)
+	middle := commitAt(t, repo, 2000, root)
+	tip := commitAt(t, repo, 3000, middle)
+
+	if commits, err := repo.ShallowCommits(); err != nil || commits != nil {
+		t.Fatalf("a complete repository has shallow commits %v, %v", commits, err)
+	}
+
+	steps := []struct {
+		name      string
+		shallow   []string
+		unshallow []string
+		want      []string
+	}{
+		{"nothing to do", nil, nil, nil},
+		{"depth 1", []string{tip}, nil, []string{tip}},
+		{"deepen by one", []string{middle}, []string{tip}, []string{middle}},
+		{"unshallow", nil, []string{middle}, nil},
+	}
+
+	for _, step := range steps {
+		if err := repo.UpdateShallow(step.shallow, step.unshallow); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		// a fresh Repository reads the file, not what UpdateShallow remembered
+		reopened := NewRepository(repo.GitDir, repo.WorkTree)
+		commits, err := reopened.ShallowCommits()
+
+		if err != nil || !reflect.DeepEqual(commits, step.want) {
+			t.Errorf("%s: shallow commits %v, %v, want %v", step.name, commits, err, step.want)
+		}
+
+		for _, sha := range []string{root, middle, tip} {
+			want := len(step.want) > 0 && step.want[0] == sha
+
+			if repo.IsShallow(sha) != want || reopened.IsShallow(sha) != want {
+				t.Errorf("%s: IsShallow(%s) = %v, want %v", step.name, sha, repo.IsShallow(sha), want)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(repo.GitDir, "shallow")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the shallow file is still there: %v", err)
+	}
+}
+
+func TestReadShallowCommit(t *testing.T) {
+	repo := newTestRepository(t)
+	root := commitAt(t, repo, 1000)
+	tip := commitAt(t, repo, 2000, root)
+
+	if err := os.WriteFile(filepath.Join(repo.GitDir, "shallow"), []byte(tip+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parent of a boundary commit is not there, history ends at it
+	commit, err := repo.ReadCommit(tip)
+
+	if err != nil || len(commit.Parents) != 0 {
+		t.Errorf("shallow commit %+v, %v, want no parents", commit, err)
+	}
+
+	walker, err := repo.NewCommitWalker([]string{tip})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first, _ := walker.Next(); first != tip {
+		t.Errorf("walked %s first, want %s", first, tip)
+	}
+
+	if next, err := walker.Next(); err != nil || next != "" {
+		t.Errorf("walked past the shallow boundary to %s, %v", next, err)
+	}
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// with side-band-64k every pkt-line after the negotiation starts with
+// the band it belongs to, https://git-scm.com/docs/protocol-capabilities#_side_band_side_band_64k
+//
+//	0006\x01P...                           1: pack data, up to 65515 bytes at a time
+//	0024\x02Counting objects: 3, done.\n   2: progress for the user
+//	0013\x03access denied\n                3: fatal error, nothing follows
+//	0000
+//
+// returns band 1 put back together, progress goes to the writer with
+// "remote: " in front of every line like git shows it
+func DemuxSideBand(data []byte, progress io.Writer) ([]byte, error) {
+	return io.ReadAll(NewSideBandReader(NewPacketReader(bytes.NewReader(data)), progress))
+}
+
+// band 1 as a stream, the pkt-lines are read as the data is, up to the flush
+type sideBandReader struct {
+	packets  *PacketReader
+	progress io.Writer
+	data     []byte
+	// progress up to the end of its line
+	pending []byte
+	err     error
+}
+
+func NewSideBandReader(packets *PacketReader, progress io.Writer) io.Reader {
+	return &sideBandReader{packets: packets, progress: progress}
+}
+
+func (s *sideBandReader) Read(p []byte) (int, error) {
+	for len(s.data) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		s.err = s.readPacket()
+	}
+
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+
+	return n, nil
+}
+
+func (s *sideBandReader) readPacket() error {
+	payload, kind, err := s.packets.ReadPacket()
+
+	if errors.Is(err, io.EOF) || (err == nil && kind == flushPacket) {
+		if len(s.pending) > 0 {
+			fmt.Fprintf(s.progress, "remote: %s\n", s.pending)
+			s.pending = nil
+		}
+
+		return io.EOF
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid side-band pkt-line: %w", err)
+	}
+
+	if kind != -1 || len(payload) == 0 {
+		return fmt.Errorf("invalid side-band pkt-line length %d", len(payload)+4)
+	}
+
+	switch band := payload[0]; band {
+	case 1:
+		s.data = payload[1:]
+	case 2:
+		s.pending = append(s.pending, payload[1:]...)
+
+		// progress lines end in \r while they are still counting
+		for {
+			end := bytes.IndexAny(s.pending, "\r\n")
+
+			if end == -1 {
+				break
+			}
+
+			fmt.Fprintf(s.progress, "remote: %s", s.pending[:end+1])
+			s.pending = s.pending[end+1:]
+		}
+	case 3:
+		return fmt.Errorf("remote error: %s", bytes.TrimSpace(payload[1:]))
+	default:
+		return fmt.Errorf("invalid side-band %d", band)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDemuxSideBand(t *testing.T) {
+	band := func(number byte, payload string) string {
+		return FormatPacketLine(string(number) + payload)
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		pack     string
+		progress string
+		err      string
+	}{
+		{
+			"pack and progress",
+			band(2, "Counting objects: 1\rCounting objects: 2, done.\n") + band(1, "PACK") + band(2, "Compressing") + band(1, "rest") + band(2, " objects: done.\n") + "0000",
+			"PACKrest",
+			"remote: Counting objects: 1\rremote: Counting objects: 2, done.\nremote: Compressing objects: done.\n",
+			"",
+		},
+		{
+			"unterminated progress",
+			band(1, "PACK") + band(2, "Total 3") + "0000",
+			"PACK",
+			"remote: Total 3\n",
+			"",
+		},
+		{
+			"no flush",
+			band(1, "PACK"),
+			"PACK",
+			"",
+			"",
+		},
+		{"fatal error", band(1, "PA") + band(3, "access denied\n") + band(1, "CK"), "", "", "remote error: access denied"},
+		{"unknown band", band(4, "?"), "", "", "invalid side-band 4"},
+		{"too short", "0004", "", "", "invalid side-band pkt-line length 4"},
+		{"past the end", "0010\x01PACK", "", "", "truncated"},
+		{"not hex", "zzzz\x01PACK", "", "", "invalid side-band pkt-line"},
+	}
+
+	for _, test := range tests {
+		var progress bytes.Buffer
+		pack, err := DemuxSideBand([]byte(test.data), &progress)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || string(pack) != test.pack || progress.String() != test.progress {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q", test.name, pack, progress.String(), err, test.pack, test.progress)
+		}
+	}
+}
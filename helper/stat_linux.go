@@ -0,0 +1,26 @@
+//go:build linux
+
+package helper
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fillStatData(entry *IndexEntry, info fs.FileInfo) {
+	entry.MTimeSeconds = uint32(info.ModTime().Unix())
+	entry.MTimeNanoseconds = uint32(info.ModTime().Nanosecond())
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return
+	}
+
+	entry.CTimeSeconds = uint32(stat.Ctim.Sec)
+	entry.CTimeNanoseconds = uint32(stat.Ctim.Nsec)
+	entry.Dev = uint32(stat.Dev)
+	entry.Ino = uint32(stat.Ino)
+	entry.UID = stat.Uid
+	entry.GID = stat.Gid
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package helper
+
+import "io/fs"
+
+// only the portable part of the stat data is available here,
+// the rest of the fields stay zero like git does on windows
+func fillStatData(entry *IndexEntry, info fs.FileInfo) {
+	entry.MTimeSeconds = uint32(info.ModTime().Unix())
+	entry.MTimeNanoseconds = uint32(info.ModTime().Nanosecond())
+}
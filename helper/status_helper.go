@@ -0,0 +1,324 @@
+package helper
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the state of one path, using the letters of git status --porcelain
+// Staged compares HEAD with the index, Unstaged the index with the working tree
+//
+//	' ' unmodified   A added   M modified   D deleted
+//	T type changed (file <-> symlink)   U unmerged   ? untracked
+type FileStatus struct {
+	Path     string
+	Staged   byte
+	Unstaged byte
+}
+
+// HeadTreeEntries is every file of the commit HEAD points to,
+// an unborn branch has none
+func (r *Repository) HeadTreeEntries() (map[string]TreeEntry, error) {
+	result := map[string]TreeEntry{}
+
+	headHash, err := r.ResolveRef("HEAD")
+
+	if errors.Is(err, ErrRefNotFound) {
+		return result, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := r.ReadCommit(headHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := r.FlattenTree(commit.Tree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		result[entry.Name] = entry
+	}
+
+	return result, nil
+}
+
+func (r *Repository) Status() ([]FileStatus, error) {
+	if r.WorkTree == "" {
+		return nil, errors.New("this operation must be run in a work tree")
+	}
+
+	headEntries, err := r.HeadTreeEntries()
+
+	if err != nil {
+		return nil, err
+	}
+
+	index, racyTime, err := r.statusIndex(headEntries)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]*FileStatus{}
+
+	status := func(path string) *FileStatus {
+		if _, ok := statuses[path]; !ok {
+			statuses[path] = &FileStatus{Path: path, Staged: ' ', Unstaged: ' '}
+		}
+
+		return statuses[path]
+	}
+
+	// HEAD vs index
+	for _, entry := range index.Entries {
+		if entry.Stage() != 0 {
+			status(entry.Path).Staged = 'U'
+			status(entry.Path).Unstaged = 'U'
+			continue
+		}
+
+		headEntry, ok := headEntries[entry.Path]
+
+		if !ok {
+			status(entry.Path).Staged = 'A'
+			continue
+		}
+
+		headMode, _ := strconv.ParseUint(headEntry.Mode, 8, 32)
+
+		if change := compareEntry(uint32(headMode), headEntry.SHA, entry.Mode, entry.SHA); change != ' ' {
+			status(entry.Path).Staged = change
+		}
+	}
+
+	for path := range headEntries {
+		if _, ok := index.Entry(path); !ok {
+			status(path).Staged = 'D'
+		}
+	}
+
+	// index vs working tree
+	for _, entry := range index.Entries {
+		// submodules are not looked into
+		if entry.Stage() != 0 || entry.Mode == 0160000 {
+			continue
+		}
+
+		change, err := r.workTreeChange(entry, racyTime)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if change != ' ' {
+			status(entry.Path).Unstaged = change
+		}
+	}
+
+	rules, err := r.IgnoreRules()
+
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, err := r.untrackedFiles(index, r.WorkTree, rules)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]FileStatus, 0, len(statuses)+len(untracked))
+
+	for _, fileStatus := range statuses {
+		result = append(result, *fileStatus)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	// a path deleted from the index but still on disk is both "D " and "??",
+	// untracked files come after the tracked ones like in git
+	for _, path := range untracked {
+		result = append(result, FileStatus{Path: path, Staged: '?', Unstaged: '?'})
+	}
+
+	return result, nil
+}
+
+// the index to compare against, plus the time it was written. a file modified
+// in the same second the index was written may have the same stat data before
+// and after the change, so such "racily clean" entries are always rehashed.
+// without an index nothing is staged, which is the same as an index matching HEAD
+func (r *Repository) statusIndex(headEntries map[string]TreeEntry) (*Index, time.Time, error) {
+	if r.HasIndex() {
+		index, err := r.ReadIndex()
+
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		info, err := os.Stat(r.indexPath())
+
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return index, info.ModTime(), nil
+	}
+
+	index := &Index{Version: 2}
+
+	for path, headEntry := range headEntries {
+		mode, _ := strconv.ParseUint(headEntry.Mode, 8, 32)
+		index.Entries = append(index.Entries, IndexEntry{Mode: uint32(mode), SHA: headEntry.SHA, Path: path})
+	}
+
+	sortIndexEntries(index.Entries)
+
+	// no stat data, every file gets hashed
+	return index, time.Time{}, nil
+}
+
+// ' ' if both sides are the same, T if the kind of file changed, M otherwise
+func compareEntry(oldMode uint32, oldSHA string, newMode uint32, newSHA string) byte {
+	if oldMode>>12 != newMode>>12 {
+		return 'T'
+	}
+
+	if oldMode != newMode || oldSHA != newSHA {
+		return 'M'
+	}
+
+	return ' '
+}
+
+func (r *Repository) workTreeChange(entry IndexEntry, racyTime time.Time) (byte, error) {
+	fullPath := filepath.Join(r.WorkTree, filepath.FromSlash(entry.Path))
+	info, err := os.Lstat(fullPath)
+
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return 'D', nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	// fast path, same stat data as when it was staged
+	entryTime := time.Unix(int64(entry.MTimeSeconds), int64(entry.MTimeNanoseconds))
+
+	if !StatChanged(entry, info) && entryTime.Before(racyTime) {
+		return ' ', nil
+	}
+
+	content, err := readWorkTreeFile(fullPath, info)
+
+	if err != nil {
+		return 0, err
+	}
+
+	hash, _ := GetObjectSHA(content, "blob")
+
+	return compareEntry(entry.Mode, entry.SHA, fileMode(info), hex.EncodeToString(hash[:])), nil
+}
+
+// files that are not in the index, a directory without a single
+// tracked file is reported once as "dir/" like git does. ignored files
+// are left out, the .gitignore of every directory on the way adds to rules
+func (r *Repository) untrackedFiles(index *Index, dir string, rules *IgnoreRules) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	relativeDir, err := filepath.Rel(r.WorkTree, dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if relativeDir = filepath.ToSlash(relativeDir); relativeDir == "." {
+		relativeDir = ""
+	}
+
+	rules, err = rules.WithFile(filepath.Join(dir, ".gitignore"), relativeDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, entry := range entries {
+		fullPath := filepath.Join(dir, entry.Name())
+
+		if entry.Name() == ".git" || fullPath == r.GitDir {
+			continue
+		}
+
+		relativePath := path.Join(relativeDir, entry.Name())
+
+		if !entry.IsDir() {
+			if _, ok := index.Entry(relativePath); !ok && !rules.IsIgnored(relativePath, false) {
+				result = append(result, relativePath)
+			}
+
+			continue
+		}
+
+		if _, ok := index.Entry(relativePath); ok {
+			// a submodule
+			continue
+		}
+
+		// nothing below an ignored directory comes back, not even with "!"
+		if rules.IsIgnored(relativePath, true) {
+			continue
+		}
+
+		subResult, err := r.untrackedFiles(index, fullPath, rules)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// empty directories and those with only ignored files do not show
+		if !index.hasEntriesUnder(relativePath) {
+			if len(subResult) > 0 {
+				result = append(result, relativePath+"/")
+			}
+
+			continue
+		}
+
+		result = append(result, subResult...)
+	}
+
+	return result, nil
+}
+
+func (index *Index) hasEntriesUnder(dir string) bool {
+	prefix := dir + "/"
+	position := sort.Search(len(index.Entries), func(i int) bool {
+		return index.Entries[i].Path >= prefix
+	})
+
+	return position < len(index.Entries) && strings.HasPrefix(index.Entries[position].Path, prefix)
+}
@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stages everything in the work tree and commits it on the branch HEAD points to
+func commitWorkTree(t *testing.T, repo *Repository) string {
+	t.Helper()
+
+	if err := repo.Add([]string{repo.WorkTree}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := repo.ReadIndex()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := repo.WriteIndexTree(index)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	branch, err := repo.HeadRef()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parents := []string{}
+	oldSHA := ZeroSHA
+
+	if parent, err := repo.ResolveRef(branch); err == nil {
+		parents = append(parents, parent)
+		oldSHA = parent
+	}
+
+	signature := Signature{"A U Thor", "author@example.com", time.Unix(1587572148, 0).UTC()}
+	commit, err := repo.CommitTree(tree, parents, "commit\n", signature, signature)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UpdateRef(branch, commit, oldSHA); err != nil {
+		t.Fatal(err)
+	}
+
+	return commit
+}
+
+func TestStatus(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, path := range []string{"staged.txt", "unstaged.txt", "deleted.txt", "both.txt", "dir/kept.txt"} {
+		writeWorkTreeFile(t, repo, path, path+"\n")
+	}
+
+	commitWorkTree(t, repo)
+
+	writeWorkTreeFile(t, repo, "staged.txt", "changed and staged\n")
+	writeWorkTreeFile(t, repo, "both.txt", "changed and staged\n")
+	writeWorkTreeFile(t, repo, "added.txt", "new\n")
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "staged.txt"), filepath.Join(repo.WorkTree, "both.txt"), filepath.Join(repo.WorkTree, "added.txt")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	writeWorkTreeFile(t, repo, "both.txt", "changed again\n")
+	writeWorkTreeFile(t, repo, "unstaged.txt", "changed, not staged\n")
+	writeWorkTreeFile(t, repo, "untracked.txt", "?\n")
+	writeWorkTreeFile(t, repo, "dir/untracked.txt", "?\n")
+	writeWorkTreeFile(t, repo, "new/deep/file.txt", "?\n")
+
+	if err := os.Remove(filepath.Join(repo.WorkTree, "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// git does not show empty directories
+	if err := os.MkdirAll(filepath.Join(repo.WorkTree, "empty", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := repo.Status()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileStatus{
+		{"added.txt", 'A', ' '},
+		{"both.txt", 'M', 'M'},
+		{"deleted.txt", ' ', 'D'},
+		{"staged.txt", 'M', ' '},
+		{"unstaged.txt", ' ', 'M'},
+		{"dir/untracked.txt", '?', '?'},
+		{"new/", '?', '?'},
+		{"untracked.txt", '?', '?'},
+	}
+
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status\n%q\nwant\n%q", statuses, want)
+	}
+}
+
+func TestStatusWithoutCommits(t *testing.T) {
+	repo := newTestRepository(t)
+	writeWorkTreeFile(t, repo, "staged.txt", "staged\n")
+	writeWorkTreeFile(t, repo, "untracked.txt", "untracked\n")
+
+	if err := repo.Add([]string{filepath.Join(repo.WorkTree, "staged.txt")}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := repo.Status()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileStatus{{"staged.txt", 'A', ' '}, {"untracked.txt", '?', '?'}}
+
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status %q, want %q", statuses, want)
+	}
+}
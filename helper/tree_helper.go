@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 )
@@ -16,21 +14,14 @@ type TreeEntry struct {
 	SHA  string
 }
 
-// parse tree entries
-// tree <size>\0
+// parse tree entries, the data is the tree as returned by ObjectStore.Read
+// so the "tree <size>\0" header is already stripped
 // <mode> <name>\0<20_byte_sha>
 // <mode> <name>\0<20_byte_sha>
 func ParseTreeEntries(data []byte) ([]TreeEntry, error) {
-
-	headerEnd := bytes.IndexByte(data, '\000')
-
 	var result []TreeEntry
 
-	if headerEnd == -1 {
-		return nil, fmt.Errorf("error: could not find null byte after header")
-	}
-
-	offset := headerEnd + 1
+	offset := 0
 
 	for offset < len(data) {
 		nullByteIndex := bytes.IndexByte(data[offset:], '\000')
@@ -42,10 +33,19 @@ func ParseTreeEntries(data []byte) ([]TreeEntry, error) {
 		modeName := data[offset : offset+nullByteIndex]
 		offset += nullByteIndex + 1
 
+		if offset+20 > len(data) {
+			return nil, fmt.Errorf("error: tree entry is missing its sha")
+		}
+
 		shaBytes := data[offset : offset+20]
 		offset += 20
 
-		splittedModeName := strings.Split(string(modeName), " ")
+		// names may contain spaces, the mode never does
+		splittedModeName := strings.SplitN(string(modeName), " ", 2)
+
+		if len(splittedModeName) != 2 {
+			return nil, fmt.Errorf("error: invalid tree entry %q", modeName)
+		}
 
 		mode := string(splittedModeName[0])
 		name := string(splittedModeName[1])
@@ -65,61 +65,87 @@ func ParseTreeEntries(data []byte) ([]TreeEntry, error) {
 
 }
 
-func CheckoutTree(treeHash, dir string) error {
-	os.MkdirAll(dir, 0755)
+func (r *Repository) ReadTree(treeHash string) ([]TreeEntry, error) {
+	tree, objectType, err := r.Objects.Read(treeHash)
 
-	fullPath := filepath.Join(".git/objects", treeHash[:2], treeHash[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	if objectType != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", treeHash, objectType)
+	}
+
+	return ParseTreeEntries(tree)
+}
+
+// every file of the tree and its subtrees, Name is the full path
+// from the top of the tree with / separators
+func (r *Repository) FlattenTree(treeHash string) ([]TreeEntry, error) {
+	return r.flattenTree(treeHash, "")
+}
 
-	// Read file
-	file, err := os.Open(fullPath) // <-- the file is compressed
+func (r *Repository) flattenTree(treeHash string, prefix string) ([]TreeEntry, error) {
+	entries, err := r.ReadTree(treeHash)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	defer file.Close()
+	result := []TreeEntry{}
 
-	decompressedFile, err := DecompressFile(file)
+	for i, entry := range entries {
+		if err := verifyPathComponent(entry.Name); err != nil {
+			return nil, fmt.Errorf("invalid path '%s%s': %w", prefix, entry.Name, err)
+		}
 
-	if err != nil {
-		return err
+		// a file and a directory of the same name would both be written
+		if i > 0 && entries[i-1].Name == entry.Name {
+			return nil, fmt.Errorf("invalid path '%s%s': duplicate entry in tree %s", prefix, entry.Name, treeHash)
+		}
+
+		entry.Name = prefix + entry.Name
+
+		if entry.Mode != "40000" {
+			result = append(result, entry)
+			continue
+		}
+
+		subEntries, err := r.flattenTree(entry.SHA, entry.Name+"/")
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, subEntries...)
 	}
 
-	// tree <size>\0
-	// <mode> <name>\0<20_byte_sha>
-	// <mode> <name>\0<20_byte_sha>
-	res, err := ParseTreeEntries(decompressedFile)
+	return result, nil
+}
 
-	if err != nil {
-		return err
+// a tree entry name becomes a file name in the work tree, like git's
+// verify_path it may not step out of it or into .git
+//
+//	""  "."  ".."  "a/b"  ".git"  ".GIT"
+func verifyPathComponent(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("%q is not a file name", name)
+	case strings.Contains(name, "/"):
+		return errors.New("a name with a slash")
+	case strings.EqualFold(name, ".git"):
+		return errors.New("the .git directory")
 	}
 
-	for _, entry := range res {
-		hashStr := entry.SHA
-		mode := entry.Mode
-		name := entry.Name
-		path := fmt.Sprintf("%s/%s", dir, name)
-
-		// recursively checkout tree since this is a dir
-		if entry.Mode == "40000" {
-			err = CheckoutTree(hashStr, path)
-			if err != nil {
-				return err
-			}
-		} else if mode == "100644" || mode == "100755" /* file */ {
-			blob, objectType, err := OpenObject(hashStr)
-			if err != nil {
-				fmt.Println(err)
-				return err
-			}
-			if objectType != "blob" {
-				fmt.Println(err)
-				return errors.New("object not a blob")
-			}
-			os.WriteFile(path, blob, 0644)
-		}
+	return nil
+}
 
+// every component of a path relative to the work tree
+func verifyPath(path string) error {
+	for _, name := range strings.Split(path, "/") {
+		if err := verifyPathComponent(name); err != nil {
+			return fmt.Errorf("invalid path '%s': %w", path, err)
+		}
 	}
 
 	return nil